@@ -0,0 +1,196 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package agentconfig converts the agent configuration files written by
+// 1.25 agents (format-1.18) into the format read by 2.x agents
+// (format-2.0).
+package agentconfig
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/juju/errors"
+	"github.com/juju/loggo"
+	"github.com/juju/utils"
+	"github.com/juju/version"
+	"gopkg.in/juju/names.v2"
+
+	agent1 "github.com/juju/1.25-upgrade/juju1/agent"
+	agent2 "github.com/juju/1.25-upgrade/juju2/agent"
+	"github.com/juju/1.25-upgrade/juju2/state/multiwatcher"
+)
+
+var logger = loggo.GetLogger("upgrader.agentconfig")
+
+// BackupSuffix is appended to the path of a 1.25 agent config file to
+// name the copy kept when it is converted.
+const BackupSuffix = ".1.25"
+
+// copiedValues are the 1.25 config values that still mean the same
+// thing to a 2.x agent. The others (storage and secure connection
+// settings) only applied to the 1.25 state server and are dropped.
+var copiedValues = []string{
+	agent2.LxcBridge,
+	agent2.ProviderType,
+	agent2.ContainerType,
+	agent2.Namespace,
+	agent2.AgentServiceName,
+	agent2.MongoOplogSize,
+	agent2.NUMACtlPreference,
+}
+
+// Params holds the details of the 2.x controller that the converted
+// agent is to connect to.
+type Params struct {
+	// Controller is the tag of the target controller.
+	Controller names.ControllerTag
+
+	// APIAddresses are the addresses of the target controller's API
+	// servers, in host:port form.
+	APIAddresses []string
+
+	// CACert is the target controller's CA certificate.
+	CACert string
+
+	// Version is the version of the target controller. The converted
+	// config records that all upgrade steps up to this version have
+	// been run, as the model has already been migrated.
+	Version version.Number
+}
+
+// Validate returns an error if any of the params are missing.
+func (p Params) Validate() error {
+	if !names.IsValidController(p.Controller.Id()) {
+		return errors.NotValidf("controller tag %q", p.Controller.Id())
+	}
+	if len(p.APIAddresses) == 0 {
+		return errors.NotValidf("empty API addresses")
+	}
+	if p.CACert == "" {
+		return errors.NotValidf("empty CA cert")
+	}
+	if p.Version == version.Zero {
+		return errors.NotValidf("zero version")
+	}
+	return nil
+}
+
+// Convert returns the 2.x agent config equivalent of the 1.25 config,
+// pointed at the controller described by params.
+//
+// The agent keeps its tag, nonce, passwords, directories and the
+// values that still apply. The model is the 1.25 environment, whose
+// UUID is kept by the migration. State addresses are dropped, as only
+// controller agents connect to mongo in 2.x, and all jobs other than
+// hosting units are dropped, as migrated machines only host units.
+func Convert(config agent1.Config, params Params) (agent2.ConfigSetterWriter, error) {
+	if err := params.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	tag, err := names.ParseTag(config.Tag().String())
+	if err != nil {
+		return nil, errors.Annotate(err, "parsing agent tag")
+	}
+	model := names.NewModelTag(config.Environment().Id())
+
+	var jobs []multiwatcher.MachineJob
+	for _, job := range config.Jobs() {
+		if string(job) == string(multiwatcher.JobHostUnits) {
+			jobs = append(jobs, multiwatcher.JobHostUnits)
+		} else {
+			logger.Debugf("%s: dropping job %s", tag, job)
+		}
+	}
+
+	values := make(map[string]string)
+	for _, key := range copiedValues {
+		if value := config.Value(key); value != "" {
+			values[key] = value
+		}
+	}
+
+	// The current password is only available through the connection
+	// info. If there isn't one, the agent has never changed its
+	// initial password, which is the old password.
+	password := config.OldPassword()
+	if info, ok := config.APIInfo(); ok && info.Password != "" {
+		password = info.Password
+	}
+
+	converted, err := agent2.NewAgentConfig(agent2.AgentConfigParams{
+		Paths: agent2.Paths{
+			DataDir: config.DataDir(),
+			LogDir:  config.LogDir(),
+		},
+		Jobs:              jobs,
+		UpgradedToVersion: params.Version,
+		Tag:               tag,
+		Password:          password,
+		Nonce:             config.Nonce(),
+		Controller:        params.Controller,
+		Model:             model,
+		APIAddresses:      params.APIAddresses,
+		CACert:            params.CACert,
+		Values:            values,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "converting config for %s", tag)
+	}
+	converted.SetPassword(password)
+	if config.OldPassword() != "" {
+		converted.SetOldPassword(config.OldPassword())
+	}
+	return converted, nil
+}
+
+// ConvertFile converts the config file of the agent with the given tag
+// in dataDir. The original file is kept alongside, with BackupSuffix
+// appended, and the new file is written atomically. If the backup
+// already exists the config has been converted before, so the backup
+// is used as the source; this makes converting again safe.
+func ConvertFile(dataDir string, tag names.Tag, params Params) (agent2.Config, error) {
+	path := agent2.ConfigPath(dataDir, tag)
+	backup := path + BackupSuffix
+
+	source := path
+	if _, err := os.Stat(backup); err == nil {
+		source = backup
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+
+	config, err := agent1.ReadConfig(source)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading 1.25 agent config")
+	}
+	converted, err := Convert(config, params)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if source == path {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := utils.AtomicWriteFile(backup, data, 0600); err != nil {
+			return nil, errors.Annotate(err, "backing up 1.25 agent config")
+		}
+	}
+	if err := converted.Write(); err != nil {
+		return nil, errors.Annotate(err, "writing 2.x agent config")
+	}
+	return converted, nil
+}
+
+// Restore puts back the 1.25 config file of the agent with the given
+// tag, if it was converted.
+func Restore(dataDir string, tag names.Tag) error {
+	path := agent2.ConfigPath(dataDir, tag)
+	backup := path + BackupSuffix
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Trace(os.Rename(backup, path))
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package agentconfig_test
+
+import (
+	"io/ioutil"
+
+	names1 "github.com/juju/names"
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/version"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/agentconfig"
+	agent1 "github.com/juju/1.25-upgrade/juju1/agent"
+	multiwatcher1 "github.com/juju/1.25-upgrade/juju1/state/multiwatcher"
+	version1 "github.com/juju/1.25-upgrade/juju1/version"
+	agent2 "github.com/juju/1.25-upgrade/juju2/agent"
+	"github.com/juju/1.25-upgrade/juju2/state/multiwatcher"
+)
+
+const (
+	envUUID        = "deadbeef-0bad-400d-8000-4b1d0d06f00d"
+	controllerUUID = "c0ffee00-0bad-400d-8000-4b1d0d06f00d"
+)
+
+type convertSuite struct {
+	dataDir string
+	params  agentconfig.Params
+}
+
+var _ = gc.Suite(&convertSuite{})
+
+func (s *convertSuite) SetUpTest(c *gc.C) {
+	s.dataDir = c.MkDir()
+	s.params = agentconfig.Params{
+		Controller:   names.NewControllerTag(controllerUUID),
+		APIAddresses: []string{"10.0.0.1:17070", "10.0.0.2:17070"},
+		CACert:       "new ca cert",
+		Version:      version.MustParse("2.1.2"),
+	}
+}
+
+func (s *convertSuite) writeConfig(c *gc.C, tag names1.Tag, jobs ...multiwatcher1.MachineJob) agent1.ConfigSetterWriter {
+	config, err := agent1.NewAgentConfig(agent1.AgentConfigParams{
+		DataDir:           s.dataDir,
+		LogDir:            c.MkDir(),
+		Jobs:              jobs,
+		UpgradedToVersion: version1.MustParse("1.25.10"),
+		Tag:               tag,
+		Password:          "old password",
+		Nonce:             "machine-nonce",
+		Environment:       names1.NewEnvironTag(envUUID),
+		StateAddresses:    []string{"10.0.1.1:37017"},
+		APIAddresses:      []string{"10.0.1.1:17070"},
+		CACert:            "old ca cert",
+		Values: map[string]string{
+			agent1.ProviderType:  "ec2",
+			agent1.ContainerType: "lxc",
+			agent1.StorageDir:    "/var/lib/juju/storage",
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	config.SetPassword("current password")
+	c.Assert(config.Write(), jc.ErrorIsNil)
+	return config
+}
+
+func (s *convertSuite) TestConvertFile(c *gc.C) {
+	original := s.writeConfig(c, names1.NewMachineTag("4"),
+		multiwatcher1.JobHostUnits, multiwatcher1.JobManageNetworking)
+	tag := names.NewMachineTag("4")
+
+	_, err := agentconfig.ConvertFile(s.dataDir, tag, s.params)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The 2.x agent can read what was written.
+	config, err := agent2.ReadConfig(agent2.ConfigPath(s.dataDir, tag))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(config.Tag(), gc.Equals, names.Tag(tag))
+	c.Check(config.Model(), gc.Equals, names.NewModelTag(envUUID))
+	c.Check(config.Controller(), gc.Equals, s.params.Controller)
+	c.Check(config.CACert(), gc.Equals, "new ca cert")
+	c.Check(config.Nonce(), gc.Equals, "machine-nonce")
+	c.Check(config.OldPassword(), gc.Equals, "old password")
+	c.Check(config.UpgradedToVersion(), gc.Equals, s.params.Version)
+	c.Check(config.DataDir(), gc.Equals, s.dataDir)
+	c.Check(config.LogDir(), gc.Equals, original.LogDir())
+	c.Check(config.Jobs(), jc.DeepEquals, []multiwatcher.MachineJob{multiwatcher.JobHostUnits})
+	c.Check(config.Value(agent2.ProviderType), gc.Equals, "ec2")
+	c.Check(config.Value(agent2.ContainerType), gc.Equals, "lxc")
+	c.Check(config.Value(agent1.StorageDir), gc.Equals, "")
+	addrs, err := config.APIAddresses()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(addrs, jc.DeepEquals, s.params.APIAddresses)
+	info, ok := config.APIInfo()
+	c.Assert(ok, jc.IsTrue)
+	c.Check(info.Password, gc.Equals, "current password")
+	_, ok = config.MongoInfo()
+	c.Check(ok, jc.IsFalse)
+
+	// The 1.25 agent can still read the backup.
+	backup, err := agent1.ReadConfig(agent2.ConfigPath(s.dataDir, tag) + agentconfig.BackupSuffix)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(backup.CACert(), gc.Equals, "old ca cert")
+	c.Check(backup.UpgradedToVersion(), gc.Equals, original.UpgradedToVersion())
+}
+
+func (s *convertSuite) TestConvertFileUnitAgent(c *gc.C) {
+	s.writeConfig(c, names1.NewUnitTag("mysql/0"))
+	tag := names.NewUnitTag("mysql/0")
+
+	_, err := agentconfig.ConvertFile(s.dataDir, tag, s.params)
+	c.Assert(err, jc.ErrorIsNil)
+
+	config, err := agent2.ReadConfig(agent2.ConfigPath(s.dataDir, tag))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(config.Tag(), gc.Equals, names.Tag(tag))
+	c.Check(config.Jobs(), gc.HasLen, 0)
+}
+
+func (s *convertSuite) TestConvertFileAgain(c *gc.C) {
+	s.writeConfig(c, names1.NewMachineTag("4"), multiwatcher1.JobHostUnits)
+	tag := names.NewMachineTag("4")
+	path := agent2.ConfigPath(s.dataDir, tag)
+
+	_, err := agentconfig.ConvertFile(s.dataDir, tag, s.params)
+	c.Assert(err, jc.ErrorIsNil)
+	backup, err := ioutil.ReadFile(path + agentconfig.BackupSuffix)
+	c.Assert(err, jc.ErrorIsNil)
+
+	s.params.APIAddresses = []string{"10.0.0.3:17070"}
+	_, err = agentconfig.ConvertFile(s.dataDir, tag, s.params)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The backup is untouched, and the config reflects the new params.
+	again, err := ioutil.ReadFile(path + agentconfig.BackupSuffix)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(again), gc.Equals, string(backup))
+	config, err := agent2.ReadConfig(path)
+	c.Assert(err, jc.ErrorIsNil)
+	addrs, err := config.APIAddresses()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(addrs, jc.DeepEquals, []string{"10.0.0.3:17070"})
+}
+
+func (s *convertSuite) TestRestore(c *gc.C) {
+	s.writeConfig(c, names1.NewMachineTag("4"), multiwatcher1.JobHostUnits)
+	tag := names.NewMachineTag("4")
+
+	_, err := agentconfig.ConvertFile(s.dataDir, tag, s.params)
+	c.Assert(err, jc.ErrorIsNil)
+	err = agentconfig.Restore(s.dataDir, tag)
+	c.Assert(err, jc.ErrorIsNil)
+
+	config, err := agent1.ReadConfig(agent2.ConfigPath(s.dataDir, tag))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(config.CACert(), gc.Equals, "old ca cert")
+}
+
+func (s *convertSuite) TestInvalidParams(c *gc.C) {
+	config := s.writeConfig(c, names1.NewMachineTag("4"), multiwatcher1.JobHostUnits)
+	s.params.APIAddresses = nil
+	_, err := agentconfig.Convert(config, s.params)
+	c.Assert(err, gc.ErrorMatches, "empty API addresses not valid")
+}
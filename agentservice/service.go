@@ -0,0 +1,220 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package agentservice regenerates the init system service definitions
+// and helper command links of converted agents, so that they run the
+// 2.x jujud with the flags and log paths it expects.
+package agentservice
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/juju/errors"
+	"github.com/juju/loggo"
+	"github.com/juju/utils"
+	"github.com/juju/utils/series"
+	"github.com/juju/utils/shell"
+	"gopkg.in/juju/names.v2"
+
+	agent2 "github.com/juju/1.25-upgrade/juju2/agent"
+	"github.com/juju/1.25-upgrade/juju2/service"
+	"github.com/juju/1.25-upgrade/juju2/service/common"
+	"github.com/juju/1.25-upgrade/juju2/service/systemd"
+	"github.com/juju/1.25-upgrade/juju2/service/upstart"
+)
+
+var logger = loggo.GetLogger("upgrader.agentservice")
+
+// Name returns the name of the init system service for the agent with
+// the given tag. It is the same in 1.25 and 2.x.
+func Name(tag names.Tag) string {
+	return "jujud-" + tag.String()
+}
+
+// BackupDir returns the directory, under dataDir, where the 1.25
+// definition of the named service is kept once it has been rewritten.
+func BackupDir(dataDir, name string) string {
+	return filepath.Join(dataDir, "1.25-upgrade", "init", name)
+}
+
+// Conf returns the 2.x service definition for the agent with the given
+// config, as the 2.x provisioner (for machine agents) and deployer (for
+// unit agents) would write it.
+func Conf(config agent2.Config) (common.Conf, error) {
+	renderer, err := shell.NewRenderer("")
+	if err != nil {
+		return common.Conf{}, errors.Trace(err)
+	}
+	tag := config.Tag()
+	switch tag.(type) {
+	case names.MachineTag:
+		info := service.NewMachineAgentInfo(tag.Id(), config.DataDir(), config.LogDir())
+		return service.AgentConf(info, renderer), nil
+	case names.UnitTag:
+		info := service.NewUnitAgentInfo(tag.Id(), config.DataDir(), config.LogDir())
+		containerType := config.Value(agent2.ContainerType)
+		return service.ContainerAgentConf(info, renderer, containerType), nil
+	}
+	return common.Conf{}, errors.NotValidf("agent tag %q", tag)
+}
+
+// Rewrite replaces the service definition of the agent with the given
+// 2.x config. The 1.25 definition is copied to BackupDir first, unless
+// a copy is already there, in which case it has been rewritten before.
+// The service is not started. The path of the new definition (the
+// upstart job file, or the directory of the systemd unit) is returned.
+func Rewrite(config agent2.Config) (string, error) {
+	conf, err := Conf(config)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	initSystem, err := hostInitSystem()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	name := Name(config.Tag())
+	if err := backup(config.DataDir(), name, initSystem); err != nil {
+		return "", errors.Annotatef(err, "backing up 1.25 definition of %s", name)
+	}
+
+	svc, err := NewService(name, conf, config.DataDir(), initSystem)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	// Installing replaces an existing definition that differs, and
+	// reloads systemd. Upstart only needs to be told to look again.
+	if err := svc.Install(); err != nil {
+		return "", errors.Annotatef(err, "installing %s", name)
+	}
+	path, _ := serviceDir(config.DataDir(), name, initSystem)
+	if initSystem == service.InitSystemUpstart {
+		path = filepath.Join(path, name+".conf")
+		return path, errors.Trace(runCommand("initctl", "reload-configuration"))
+	}
+	return path, nil
+}
+
+// NewService returns the named service with the given definition, for
+// the given init system. Systemd units are kept under dataDir, where
+// serviceDir expects them.
+func NewService(name string, conf common.Conf, dataDir, initSystem string) (service.Service, error) {
+	switch initSystem {
+	case service.InitSystemUpstart:
+		return upstart.NewService(name, conf), nil
+	case service.InitSystemSystemd:
+		svc, err := systemd.NewService(name, conf, dataDir)
+		if err != nil {
+			return nil, errors.Annotatef(err, "creating service %s", name)
+		}
+		return svc, nil
+	}
+	return nil, errors.NotSupportedf("init system %q", initSystem)
+}
+
+// Restore puts back the 1.25 service definition of the agent with the
+// given tag, if it was rewritten.
+func Restore(dataDir string, tag names.Tag) error {
+	name := Name(tag)
+	backupDir := BackupDir(dataDir, name)
+	if _, err := os.Stat(backupDir); os.IsNotExist(err) {
+		return nil
+	}
+	initSystem, err := hostInitSystem()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	target, reload := serviceDir(dataDir, name, initSystem)
+	if err := copyFiles(target, backupDir); err != nil {
+		return errors.Annotatef(err, "restoring 1.25 definition of %s", name)
+	}
+	if err := runCommand(reload[0], reload[1:]...); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.RemoveAll(backupDir))
+}
+
+func hostInitSystem() (string, error) {
+	hostSeries, err := series.HostSeries()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	initSystem, err := service.VersionInitSystem(hostSeries)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	switch initSystem {
+	case service.InitSystemUpstart, service.InitSystemSystemd:
+		return initSystem, nil
+	}
+	return "", errors.NotSupportedf("init system %q", initSystem)
+}
+
+// serviceDir returns the directory holding the definition of the named
+// service, and the command that makes the init system reread it.
+// Upstart reads /etc/init directly; systemd units written by juju live
+// in their own directory under the data dir, linked into systemd.
+func serviceDir(dataDir, name, initSystem string) (string, []string) {
+	if initSystem == service.InitSystemUpstart {
+		return upstart.InitDir, []string{"initctl", "reload-configuration"}
+	}
+	return filepath.Join(dataDir, "init", name), []string{"systemctl", "daemon-reload"}
+}
+
+func backup(dataDir, name, initSystem string) error {
+	backupDir := BackupDir(dataDir, name)
+	if _, err := os.Stat(backupDir); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	source, _ := serviceDir(dataDir, name, initSystem)
+	if initSystem == service.InitSystemUpstart {
+		// Only this service's file, not all of /etc/init.
+		return errors.Trace(copyFile(backupDir, filepath.Join(source, name+".conf")))
+	}
+	return errors.Trace(copyFiles(backupDir, source))
+}
+
+func copyFiles(targetDir, sourceDir string) error {
+	infos, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		if err := copyFile(targetDir, filepath.Join(sourceDir, info.Name())); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies source into targetDir, keeping its name and mode.
+func copyFile(targetDir, source string) error {
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+	target := filepath.Join(targetDir, filepath.Base(source))
+	if err := utils.CopyFile(target, source); err != nil {
+		return errors.Trace(err)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Chmod(target, info.Mode()))
+}
+
+func runCommand(command string, args ...string) error {
+	logger.Debugf("running %s %v", command, args)
+	out, err := exec.Command(command, args...).CombinedOutput()
+	if err != nil {
+		return errors.Annotatef(err, "%s failed: %s", command, out)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package agentservice_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/version"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/agentservice"
+	"github.com/juju/1.25-upgrade/juju2/agent"
+	"github.com/juju/1.25-upgrade/juju2/service"
+	"github.com/juju/1.25-upgrade/juju2/service/common"
+	"github.com/juju/1.25-upgrade/juju2/service/systemd"
+	"github.com/juju/1.25-upgrade/juju2/service/upstart"
+)
+
+type serviceSuite struct{}
+
+var _ = gc.Suite(&serviceSuite{})
+
+func (s *serviceSuite) newConfig(c *gc.C, tag names.Tag, values map[string]string) agent.Config {
+	config, err := agent.NewAgentConfig(agent.AgentConfigParams{
+		Paths: agent.Paths{
+			DataDir: "/var/lib/juju",
+			LogDir:  "/var/log/juju",
+		},
+		UpgradedToVersion: version.MustParse("2.1.0"),
+		Tag:               tag,
+		Password:          "sekrit",
+		Nonce:             "nonce",
+		Controller:        names.NewControllerTag("c0ffee00-0bad-400d-8000-4b1d0d06f00d"),
+		Model:             names.NewModelTag("deadbeef-0bad-400d-8000-4b1d0d06f00d"),
+		APIAddresses:      []string{"10.0.0.1:17070"},
+		CACert:            "ca cert",
+		Values:            values,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	return config
+}
+
+func (s *serviceSuite) TestName(c *gc.C) {
+	c.Check(agentservice.Name(names.NewMachineTag("0/lxc/1")), gc.Equals, "jujud-machine-0-lxc-1")
+	c.Check(agentservice.Name(names.NewUnitTag("wordpress/0")), gc.Equals, "jujud-unit-wordpress-0")
+}
+
+func (s *serviceSuite) TestMachineConf(c *gc.C) {
+	conf, err := agentservice.Conf(s.newConfig(c, names.NewMachineTag("3"), nil))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(conf.ExecStart, gc.Equals,
+		"'/var/lib/juju/tools/machine-3/jujud' machine --data-dir '/var/lib/juju' --machine-id 3 --debug")
+	c.Check(conf.Logfile, gc.Equals, "/var/log/juju/machine-3.log")
+	c.Check(conf.Limit, jc.DeepEquals, map[string]int{"nofile": 20000})
+}
+
+func (s *serviceSuite) TestUnitConf(c *gc.C) {
+	config := s.newConfig(c, names.NewUnitTag("wordpress/0"), map[string]string{
+		agent.ContainerType: "lxc",
+	})
+	conf, err := agentservice.Conf(config)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(conf.ExecStart, gc.Equals,
+		"'/var/lib/juju/tools/unit-wordpress-0/jujud' unit --data-dir '/var/lib/juju' --unit-name wordpress/0 --debug")
+	c.Check(conf.Logfile, gc.Equals, "/var/log/juju/unit-wordpress-0.log")
+	c.Check(conf.Env["JUJU_CONTAINER_TYPE"], gc.Equals, "lxc")
+}
+
+func (s *serviceSuite) TestNewServiceSystemd(c *gc.C) {
+	conf, err := agentservice.Conf(s.newConfig(c, names.NewMachineTag("3"), nil))
+	c.Assert(err, jc.ErrorIsNil)
+	svc, err := agentservice.NewService("jujud-machine-3", conf, "/var/lib/juju", service.InitSystemSystemd)
+	c.Assert(err, jc.ErrorIsNil)
+	systemdService, ok := svc.(*systemd.Service)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(systemdService.Name(), gc.Equals, "jujud-machine-3")
+	c.Check(systemdService.Dirname, gc.Equals, "/var/lib/juju/init/jujud-machine-3")
+	c.Check(systemdService.ConfName, gc.Equals, "jujud-machine-3.service")
+}
+
+func (s *serviceSuite) TestNewServiceUpstart(c *gc.C) {
+	conf, err := agentservice.Conf(s.newConfig(c, names.NewUnitTag("wordpress/0"), nil))
+	c.Assert(err, jc.ErrorIsNil)
+	svc, err := agentservice.NewService("jujud-unit-wordpress-0", conf, "/var/lib/juju", service.InitSystemUpstart)
+	c.Assert(err, jc.ErrorIsNil)
+	upstartService, ok := svc.(*upstart.Service)
+	c.Assert(ok, jc.IsTrue)
+	c.Check(upstartService.Name(), gc.Equals, "jujud-unit-wordpress-0")
+	c.Check(upstartService.Conf(), jc.DeepEquals, conf)
+}
+
+func (s *serviceSuite) TestNewServiceUnsupportedInitSystem(c *gc.C) {
+	_, err := agentservice.NewService("jujud-machine-3", common.Conf{}, "/var/lib/juju", service.InitSystemWindows)
+	c.Assert(err, gc.ErrorMatches, `init system "windows" not supported`)
+}
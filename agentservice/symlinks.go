@@ -0,0 +1,140 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package agentservice
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/series"
+	"github.com/juju/utils/symlink"
+	"github.com/juju/version"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/juju2/agent/tools"
+	jujunames "github.com/juju/1.25-upgrade/juju2/juju/names"
+	"github.com/juju/1.25-upgrade/juju2/juju/paths"
+)
+
+// HelperSymlinks returns the paths of the helper commands that the 2.x
+// machine agent links to its jujud: juju-run, which 1.25 also had, and
+// juju-dumplogs, which it didn't.
+func HelperSymlinks() ([]string, error) {
+	hostSeries, err := series.HostSeries()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	jujuRun, err := paths.JujuRun(hostSeries)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	jujuDumpLogs, err := paths.JujuDumpLogs(hostSeries)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []string{jujuRun, jujuDumpLogs}, nil
+}
+
+// WriteHelperSymlinks points the helper commands at the jujud of the
+// machine agent with the given tag, as the 2.x machine agent does when
+// it starts, so that they work before it does. It returns the links
+// that had to be created or changed.
+func WriteHelperSymlinks(dataDir string, tag names.MachineTag) ([]string, error) {
+	links, err := HelperSymlinks()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	jujud := filepath.Join(tools.ToolsDir(dataDir, tag.String()), jujunames.Jujud)
+	var written []string
+	for _, link := range links {
+		current, err := symlink.Read(link)
+		if err == nil && current == jujud {
+			continue
+		} else if err == nil {
+			if err := os.Remove(link); err != nil {
+				return written, errors.Trace(err)
+			}
+		} else if !os.IsNotExist(err) {
+			return written, errors.Annotatef(err, "reading %s", link)
+		}
+		if err := symlink.New(jujud, link); err != nil {
+			return written, errors.Annotatef(err, "linking %s", link)
+		}
+		written = append(written, link)
+	}
+	return written, nil
+}
+
+// RemoveHelperSymlinks removes the helper links that 1.25 didn't have.
+// The juju-run link is left, as it points at the same path in both
+// versions.
+func RemoveHelperSymlinks() error {
+	links, err := HelperSymlinks()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, link := range links {
+		if filepath.Base(link) == jujunames.JujuRun {
+			continue
+		}
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// toolsBackupPath returns where the 1.25 target of the tools link of
+// the agent with the given tag is kept once the link has been changed.
+func toolsBackupPath(dataDir string, tag names.Tag) string {
+	return filepath.Join(dataDir, "1.25-upgrade", "tools", tag.String())
+}
+
+// RelinkTools points the tools directory of the agent with the given
+// tag at the tools for vers, which must already be unpacked under
+// dataDir. The 1.25 target of the link is kept first, unless it has
+// been already, so that RestoreTools can put it back. It returns the
+// path of the link.
+func RelinkTools(dataDir string, tag names.Tag, vers version.Binary) (string, error) {
+	link := tools.ToolsDir(dataDir, tag.String())
+	backup := toolsBackupPath(dataDir, tag)
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		current, err := symlink.Read(link)
+		if err != nil {
+			return "", errors.Annotatef(err, "reading %s", link)
+		}
+		if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+			return "", errors.Trace(err)
+		}
+		if err := ioutil.WriteFile(backup, []byte(current+"\n"), 0644); err != nil {
+			return "", errors.Annotate(err, "backing up 1.25 tools link")
+		}
+	} else if err != nil {
+		return "", errors.Trace(err)
+	}
+	if _, err := tools.ChangeAgentTools(dataDir, tag.String(), vers); err != nil {
+		return "", errors.Trace(err)
+	}
+	return link, nil
+}
+
+// RestoreTools points the tools directory of the agent with the given
+// tag back at its 1.25 tools, if RelinkTools changed it.
+func RestoreTools(dataDir string, tag names.Tag) error {
+	backup := toolsBackupPath(dataDir, tag)
+	data, err := ioutil.ReadFile(backup)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	link := tools.ToolsDir(dataDir, tag.String())
+	if err := symlink.Replace(link, strings.TrimSpace(string(data))); err != nil {
+		return errors.Annotatef(err, "restoring %s", link)
+	}
+	return errors.Trace(os.Remove(backup))
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package agentservice_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/utils/symlink"
+	"github.com/juju/version"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/agentservice"
+)
+
+type symlinksSuite struct{}
+
+var _ = gc.Suite(&symlinksSuite{})
+
+func (s *symlinksSuite) TestRelinkAndRestoreTools(c *gc.C) {
+	dataDir := c.MkDir()
+	toolsDir := filepath.Join(dataDir, "tools")
+	for _, vers := range []string{"1.25.6-trusty-amd64", "2.1.2-trusty-amd64"} {
+		dir := filepath.Join(toolsDir, vers)
+		c.Assert(os.MkdirAll(dir, 0755), jc.ErrorIsNil)
+		metadata := []byte(`{"version":"` + vers + `"}`)
+		err := ioutil.WriteFile(filepath.Join(dir, "downloaded-tools.txt"), metadata, 0644)
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	link := filepath.Join(toolsDir, "unit-wordpress-0")
+	oldTools := filepath.Join(toolsDir, "1.25.6-trusty-amd64")
+	c.Assert(symlink.New(oldTools, link), jc.ErrorIsNil)
+
+	tag := names.NewUnitTag("wordpress/0")
+	vers := version.MustParseBinary("2.1.2-trusty-amd64")
+	changed, err := agentservice.RelinkTools(dataDir, tag, vers)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(changed, gc.Equals, link)
+	target, err := symlink.Read(link)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(target, gc.Equals, filepath.Join(toolsDir, "2.1.2-trusty-amd64"))
+
+	// Relinking again keeps the 1.25 target.
+	_, err = agentservice.RelinkTools(dataDir, tag, vers)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = agentservice.RestoreTools(dataDir, tag)
+	c.Assert(err, jc.ErrorIsNil)
+	target, err = symlink.Read(link)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(target, gc.Equals, oldTools)
+
+	// Restoring again does nothing.
+	err = agentservice.RestoreTools(dataDir, tag)
+	c.Assert(err, jc.ErrorIsNil)
+}
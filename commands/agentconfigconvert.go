@@ -0,0 +1,275 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"github.com/juju/utils/arch"
+	"github.com/juju/utils/series"
+	"github.com/juju/version"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/agentconfig"
+	"github.com/juju/1.25-upgrade/agentservice"
+	agent2 "github.com/juju/1.25-upgrade/juju2/agent"
+	"github.com/juju/1.25-upgrade/uniterstate"
+)
+
+var agentConfigConvertImplDoc = `
+
+agent-config-convert-impl must be executed on a machine of a 1.25 environment;
+upgrade-agents-impl installs the plugin and the 2.x tools on each machine and
+runs it there.
+
+The command converts the agent.conf of every agent on the machine from the
+1.25 format to the 2.x format, pointing them at the target controller. The
+original files are kept alongside with a .1.25 suffix, and converting again
+starts from those. The tools of each agent are linked to the 2.x tools for the
+controller's version, which must already be unpacked under /var/lib/juju/tools.
+The init system (upstart or systemd) service definition of each agent is then
+regenerated to run the 2.x jujud, and the init system reloaded; the 1.25
+definitions are kept under /var/lib/juju/1.25-upgrade/init. The juju-run and
+juju-dumplogs helpers are linked to the machine agent's jujud. Each file
+changed is listed, for upgrade-agents-impl to record. With --restore,
+the original files, tools links and definitions are put back instead.
+
+The uniter state of each unit agent is converted too: the operation state is
+rewritten in the 2.x format (again keeping the original with a .1.25 suffix),
+the relation state is checked and a git-deployed charm directory is moved to
+the manifest layout.
+
+Units are expected to be idle. If any unit is part way through an operation,
+such as running (or failing) a hook, running an action or upgrading its
+charm, the units are listed and nothing is converted. Either resolve them and
+stop the agents again, or use --force to carry each operation over as it is,
+so the 2.x agent resumes it. Every operation carried over is reported.
+
+`
+
+func newAgentConfigConvertImplCommand() cmd.Command {
+	return &agentConfigConvertImplCommand{}
+}
+
+type agentConfigConvertImplCommand struct {
+	baseRemoteCommand
+
+	restore bool
+	force   bool
+}
+
+func (c *agentConfigConvertImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "agent-config-convert-impl",
+		Purpose: "convert the agent configs on this machine to the 2.x format",
+		Doc:     agentConfigConvertImplDoc,
+	}
+}
+
+func (c *agentConfigConvertImplCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.BoolVar(&c.restore, "restore", false, "put back the 1.25 agent configs")
+	f.BoolVar(&c.force, "force", false, "convert units that are part way through an operation")
+}
+
+func (c *agentConfigConvertImplCommand) Init(args []string) error {
+	// Restoring doesn't need the controller.
+	c.needsController = !c.restore
+	args, err := c.baseRemoteCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cmd.CheckEmpty(args)
+}
+
+func (c *agentConfigConvertImplCommand) Run(ctx *cmd.Context) error {
+	tags, err := getAgentTags(dataDir)
+	if err != nil {
+		return errors.Annotate(err, "finding agents")
+	}
+
+	if c.restore {
+		for _, tag := range tags {
+			if unitTag, ok := tag.(names.UnitTag); ok {
+				if err := uniterstate.Restore(dataDir, unitTag); err != nil {
+					return errors.Annotatef(err, "restoring uniter state for %s", tag)
+				}
+			}
+			if err := agentconfig.Restore(dataDir, tag); err != nil {
+				return errors.Annotatef(err, "restoring config for %s", tag)
+			}
+			if err := agentservice.RestoreTools(dataDir, tag); err != nil {
+				return errors.Annotatef(err, "restoring tools for %s", tag)
+			}
+			if err := agentservice.Restore(dataDir, tag); err != nil {
+				return errors.Annotatef(err, "restoring service for %s", tag)
+			}
+			fmt.Fprintf(ctx.Stdout, "%s: restored\n", tag)
+		}
+		return errors.Annotate(agentservice.RemoveHelperSymlinks(), "removing helper symlinks")
+	}
+
+	var units []names.UnitTag
+	for _, tag := range tags {
+		if unitTag, ok := tag.(names.UnitTag); ok {
+			units = append(units, unitTag)
+		}
+	}
+	if err := c.checkUnits(ctx, units); err != nil {
+		return errors.Trace(err)
+	}
+	// Convert the uniter state first, so a unit with bad state stops
+	// the conversion before any agent is pointed at the controller.
+	for _, tag := range units {
+		st, err := uniterstate.ConvertUnit(dataDir, tag, c.force)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if st != nil {
+			reportChange(ctx, tag, uniterstate.StateFile(dataDir, tag))
+		}
+		if st != nil && !uniterstate.Idle(st) {
+			fmt.Fprintf(ctx.Stdout, "%s: carried over %s\n", tag, uniterstate.Describe(st))
+		}
+		if st != nil && uniterstate.UpgradePending(st) {
+			fmt.Fprintf(ctx.Stdout, "%s: charm directory left for the pending upgrade\n", tag)
+		}
+	}
+
+	params, err := c.convertParams()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	hostSeries, err := series.HostSeries()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	toolsVersion := version.Binary{
+		Number: params.Version,
+		Series: hostSeries,
+		Arch:   arch.HostArch(),
+	}
+	for _, tag := range tags {
+		link, err := agentservice.RelinkTools(dataDir, tag, toolsVersion)
+		if err != nil {
+			return errors.Annotatef(err, "linking tools for %s", tag)
+		}
+		reportChange(ctx, tag, link)
+		converted, err := agentconfig.ConvertFile(dataDir, tag, params)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		reportChange(ctx, tag, agent2.ConfigPath(dataDir, tag))
+		definition, err := agentservice.Rewrite(converted)
+		if err != nil {
+			return errors.Annotatef(err, "rewriting service for %s", tag)
+		}
+		reportChange(ctx, tag, definition)
+		fmt.Fprintf(ctx.Stdout, "%s: converted\n", tag)
+
+		if machineTag, ok := tag.(names.MachineTag); ok {
+			links, err := agentservice.WriteHelperSymlinks(dataDir, machineTag)
+			if err != nil {
+				return errors.Annotate(err, "updating helper symlinks")
+			}
+			for _, link := range links {
+				reportChange(ctx, tag, link)
+			}
+		}
+	}
+	return nil
+}
+
+// changedPrefix starts the path of each file changed for an agent in
+// the output of agent-config-convert-impl, after the agent's tag, so
+// that upgrade-agents-impl can record the changes in the audit log.
+const changedPrefix = "changed "
+
+func reportChange(ctx *cmd.Context, tag names.Tag, path string) {
+	fmt.Fprintf(ctx.Stdout, "%s: %s%s\n", tag, changedPrefix, path)
+}
+
+// convertChanges returns the paths of the files changed on a machine,
+// from the output of agent-config-convert-impl.
+func convertChanges(stdout string) []string {
+	var paths []string
+	for _, line := range strings.Split(stdout, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[1], changedPrefix) {
+			paths = append(paths, strings.TrimPrefix(parts[1], changedPrefix))
+		}
+	}
+	return paths
+}
+
+// checkUnits returns an error listing the units that are part way
+// through an operation, unless --force was given.
+func (c *agentConfigConvertImplCommand) checkUnits(ctx *cmd.Context, units []names.UnitTag) error {
+	var busy []string
+	for _, tag := range units {
+		st, err := uniterstate.ReadState(dataDir, tag)
+		if err != nil {
+			return errors.Annotatef(err, "checking %s", tag)
+		}
+		if st != nil && !uniterstate.Idle(st) {
+			fmt.Fprintf(ctx.Stdout, "%s: %s\n", tag, uniterstate.Describe(st))
+			busy = append(busy, tag.Id())
+		}
+	}
+	if len(busy) > 0 && !c.force {
+		return errors.Errorf("units part way through an operation: %s; resolve them or use --force",
+			strings.Join(busy, ", "))
+	}
+	return nil
+}
+
+func (c *agentConfigConvertImplCommand) convertParams() (agentconfig.Params, error) {
+	conn, err := c.getControllerConnection()
+	if err != nil {
+		return agentconfig.Params{}, errors.Annotate(err, "getting controller connection")
+	}
+	defer conn.Close()
+
+	ver, ok := conn.ServerVersion()
+	if !ok {
+		return agentconfig.Params{}, errors.New("controller did not report its version")
+	}
+	return agentconfig.Params{
+		Controller:   conn.ControllerTag(),
+		APIAddresses: c.controllerInfo.Addrs,
+		CACert:       c.controllerInfo.CACert,
+		Version:      ver,
+	}, nil
+}
+
+// getAgentTags returns the tags of the machine and unit agents with
+// directories under datadir.
+func getAgentTags(datadir string) ([]names.Tag, error) {
+	dirs, err := filepath.Glob(filepath.Join(datadir, "agents", "*"))
+	if err != nil {
+		return nil, errors.Annotate(err, "problem globbing")
+	}
+	var tags []names.Tag
+	for _, dir := range dirs {
+		tag, err := names.ParseTag(filepath.Base(dir))
+		if err != nil {
+			logger.Warningf("skipping %s: %v", dir, err)
+			continue
+		}
+		switch tag.(type) {
+		case names.MachineTag, names.UnitTag:
+			tags = append(tags, tag)
+		default:
+			logger.Warningf("skipping %s: not an agent", dir)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, errors.Errorf("no agents found")
+	}
+	return tags, nil
+}
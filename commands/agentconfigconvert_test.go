@@ -0,0 +1,32 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type agentConfigConvertSuite struct{}
+
+var _ = gc.Suite(&agentConfigConvertSuite{})
+
+func (*agentConfigConvertSuite) TestConvertChanges(c *gc.C) {
+	stdout := `
+unit-mysql-0: changed /var/lib/juju/agents/unit-mysql-0/state/uniter
+unit-mysql-0: carried over running hook config-changed
+machine-1: changed /var/lib/juju/tools/machine-1
+machine-1: changed /var/lib/juju/agents/machine-1/agent.conf
+machine-1: changed /etc/init/jujud-machine-1.conf
+machine-1: converted
+machine-1: changed /usr/bin/juju-run
+`
+	c.Assert(convertChanges(stdout), jc.DeepEquals, []string{
+		"/var/lib/juju/agents/unit-mysql-0/state/uniter",
+		"/var/lib/juju/tools/machine-1",
+		"/var/lib/juju/agents/machine-1/agent.conf",
+		"/etc/init/jujud-machine-1.conf",
+		"/usr/bin/juju-run",
+	})
+}
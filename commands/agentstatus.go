@@ -12,6 +12,9 @@ import (
 
 	"github.com/juju/cmd"
 	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"github.com/juju/utils/set"
+	"gopkg.in/juju/names.v2"
 
 	"github.com/juju/1.25-upgrade/juju1/state"
 	"github.com/juju/1.25-upgrade/juju2/cmd/output"
@@ -23,6 +26,15 @@ agents of a 1.25 environment. The agents may be running the 1.25 binary, or a
 2.x binary. The command will return the status of the agent, and what tools
 they are currently set to use.
 
+The command can be restricted to some of the machines. --machines selects
+machines by id, along with the containers on them; --applications selects the
+machines hosting units of the applications; --exclude leaves out machines
+(and their containers) or the machines hosting applications. Machines that
+can't be reached are listed as unreachable. For example:
+
+  --machines 1,4,7/lxc/2 --exclude 4/lxc/0
+  --applications mysql,wordpress
+
 `
 
 func newAgentStatusCommand() cmd.Command {
@@ -35,6 +47,10 @@ type agentStatusCommand struct {
 	baseClientCommand
 }
 
+func (c *agentStatusCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.setFilterFlags(f)
+}
+
 func (c *agentStatusCommand) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "agent-status",
@@ -71,6 +87,10 @@ type agentStatusImplCommand struct {
 	baseRemoteCommand
 }
 
+func (c *agentStatusImplCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.setMachineFlags(f)
+}
+
 func (c *agentStatusImplCommand) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "agent-status-impl",
@@ -89,7 +109,7 @@ func (c *agentStatusImplCommand) Run(ctx *cmd.Context) error {
 	// Here we always use the 1.25 environment to get all of the machine
 	// addresses. We then use those to ssh into every one of those machine
 	// and run the service status script against all the agents.
-	machines, err := getMachines(st)
+	machines, err := c.selectedMachines(st)
 	if err != nil {
 		return errors.Annotate(err, "unable to get addresses for machines")
 	}
@@ -123,6 +143,13 @@ func parseStatus(status []DistResult) []statusResult {
 	var results []statusResult
 
 	for _, r := range status {
+		if r.Error != nil || r.Code == sshFailureCode {
+			results = append(results, statusResult{
+				agent:  names.NewMachineTag(r.MachineID).String(),
+				status: "unreachable",
+			})
+			continue
+		}
 		agents := strings.Split(r.Stdout, "-- end-of-agent --\n")
 		for _, agent := range agents[:len(agents)-1] {
 			var result statusResult
@@ -196,41 +223,67 @@ done
 }
 
 func getMachines(st *state.State) ([]FlatMachine, error) {
+	cfg, err := machineSSHConfig()
+	if err != nil {
+		return nil, errors.Annotate(err, "reading SSH config")
+	}
 	machines, err := st.AllMachines()
 	if err != nil {
 		return nil, errors.Annotate(err, "getting 1.25 machines")
 	}
+	addresses, err := selectAddresses(st, machines, cfg)
+	if err != nil {
+		return nil, errors.Annotate(err, "selecting machine addresses")
+	}
 	var result []FlatMachine
 	for i, m := range machines {
-		address, err := getMachineAddress(m)
-		if err != nil {
-			return nil, errors.Annotatef(err, "address for machine %q", m.Id())
-		}
+		address := addresses[m.Id()]
 		fm := FlatMachine{
-			Model:   st.EnvironUUID(),
-			Series:  m.Series(),
-			ID:      m.Id(),
-			Address: address,
+			Model:         st.EnvironUUID(),
+			Series:        m.Series(),
+			ID:            m.Id(),
+			Address:       address.Address,
+			AddressReason: address.Reason,
+			Reachability:  address.Reachability,
+		}
+		if parentID, ok := m.ParentId(); ok {
+			fm.ParentID = parentID
 		}
 		if tools, err := m.AgentTools(); err == nil {
 			fm.Tools = tools.Version.String()
 		}
+		units, err := m.Units()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		applications := set.NewStrings()
+		for _, u := range units {
+			applications.Add(u.ServiceName())
+		}
+		fm.Applications = applications.SortedValues()
 		logger.Debugf("%d: %#v", i, fm)
 		result = append(result, fm)
 	}
+	if err := setSSHRoutes(result, cfg); err != nil {
+		return nil, errors.Annotate(err, "working out SSH routes")
+	}
 	return result, nil
 }
 
-func getMachineAddress(m *state.Machine) (string, error) {
-	// Start with the private address, which is more likely to be set
-	// fallback to the public address, and error out if they are both missing.
-	private, err := m.PrivateAddress()
-	if err == nil {
-		return private.Value, nil
+// selectMachines returns the machines with the given ids, in the order
+// of ids.
+func selectMachines(machines []FlatMachine, ids []string) ([]FlatMachine, error) {
+	byID := make(map[string]FlatMachine)
+	for _, m := range machines {
+		byID[m.ID] = m
 	}
-	public, err := m.PublicAddress()
-	if err != nil {
-		return "", errors.New("no private nor public address")
+	var result []FlatMachine
+	for _, id := range ids {
+		m, found := byID[id]
+		if !found {
+			return nil, errors.NotFoundf("machine %q", id)
+		}
+		result = append(result, m)
 	}
-	return public.Value, nil
+	return result, nil
 }
@@ -0,0 +1,435 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+
+	"github.com/juju/1.25-upgrade/juju2/api"
+	"github.com/juju/1.25-upgrade/juju2/cmd/output"
+)
+
+const (
+	// auditLogFile is the audit log kept for each environment, in its
+	// local state directory.
+	auditLogFile = "audit.jsonl"
+
+	// remoteAuditFile is where remote commands record what they do,
+	// in remotePluginDir; the client moves the records into its own
+	// audit log after each command.
+	remoteAuditFile = "1.25-upgrade-audit.jsonl"
+
+	// machineAuditFile is where agent-config-convert-impl records what
+	// it does on each machine, in remotePluginDir. It's kept apart from
+	// remoteAuditFile, which the state server is writing to while it
+	// runs agent-config-convert-impl on itself; upgrade-agents-impl
+	// moves the records into remoteAuditFile afterwards.
+	machineAuditFile = "1.25-upgrade-machine-audit.jsonl"
+)
+
+// The kinds of audit records.
+const (
+	auditSSH  = "ssh"
+	auditAPI  = "api"
+	auditFile = "file"
+)
+
+// auditRecord is an entry in the audit log: a command run over SSH, a
+// call to the controller API, or a file changed on a machine.
+type auditRecord struct {
+	Start    time.Time
+	End      time.Time
+	Duration float64
+
+	// Phase is the command that was running.
+	Phase string
+	Kind  string
+
+	// Host is the address the action was taken on, and Machine the
+	// id of the environment machine with that address, if it is one.
+	Host    string `json:",omitempty"`
+	Machine string `json:",omitempty"`
+
+	// Via is the machine that took the action, if the client didn't:
+	// the state server, or a machine it ran a command on.
+	Via string `json:",omitempty"`
+
+	// Action is the script run, with secrets redacted, the API call
+	// made or the path of the file changed.
+	Action string
+	Code   int    `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// auditLog is where audit records are appended. Nothing is recorded
+// until setAuditPath is called.
+var auditLog struct {
+	sync.Mutex
+	path  string
+	phase string
+}
+
+// setAuditPath makes audit records go to the given file.
+func setAuditPath(path string) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	auditLog.path = path
+}
+
+// setAuditPhase sets the phase of the records made from now on.
+func setAuditPhase(phase string) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	auditLog.phase = phase
+}
+
+// startAuditPhase is called as each command is run. The remote
+// commands run from remotePluginDir and record what they do there, for
+// the client (or, for agent-config-convert-impl, the state server) to
+// collect; client commands set the audit log path once they know the
+// environment.
+func startAuditPhase(name string) {
+	switch {
+	case name == "agent-config-convert-impl":
+		setAuditPath(machineAuditFile)
+	case strings.HasSuffix(name, "-impl"):
+		setAuditPath(remoteAuditFile)
+	}
+	setAuditPhase(name)
+}
+
+// recordAudit appends a record of an action started at start that has
+// just finished, with err. A record that can't be written is logged,
+// rather than failing the action.
+func recordAudit(start time.Time, record auditRecord, err error) {
+	end := time.Now()
+	record.Start = start.UTC()
+	record.End = end.UTC()
+	record.Duration = end.Sub(start).Seconds()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if auditLog.path == "" {
+		return
+	}
+	if record.Phase == "" {
+		record.Phase = auditLog.phase
+	}
+	line, err := json.Marshal(record)
+	if err == nil {
+		err = appendAuditLines(auditLog.path, append(line, '\n'))
+	}
+	if err != nil {
+		logger.Warningf("recording %s %q: %v", record.Kind, record.Action, err)
+	}
+}
+
+func appendAuditLines(path string, lines []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Annotate(err, "opening audit log")
+	}
+	defer f.Close()
+	_, err = f.Write(lines)
+	return errors.Annotate(err, "writing audit log")
+}
+
+// secretPattern matches the values set for anything that looks like a
+// secret in a script.
+var secretPattern = regexp.MustCompile(`(?i)((?:password|passwd|secret|token|macaroon|private-?key)[\w-]*["']?(?:\s*[:=]\s*|\s+))("[^"]*"|'[^']*'|[^\s;&|"']+)`)
+
+// redactScript returns the script with secret values replaced.
+func redactScript(script string) string {
+	return secretPattern.ReplaceAllString(script, "${1}<redacted>")
+}
+
+// auditRun records a script run over SSH on the host. The script's
+// stdin isn't recorded, as that's how secrets are passed.
+func auditRun(start time.Time, host, machine, script string, result RunResult, err error) {
+	recordAudit(start, auditRecord{
+		Kind:    auditSSH,
+		Host:    host,
+		Machine: machine,
+		Action:  redactScript(strings.TrimSpace(script)),
+		Code:    result.Code,
+	}, err)
+}
+
+// auditFileChange records that a file on the host was changed.
+func auditFileChange(start time.Time, host, machine, path string, err error) {
+	recordAudit(start, auditRecord{
+		Kind:    auditFile,
+		Host:    host,
+		Machine: machine,
+		Action:  path,
+	}, err)
+}
+
+// auditedConnection records the calls made on an API connection. Only
+// the facade and method are recorded, not the arguments.
+type auditedConnection struct {
+	api.Connection
+}
+
+func (c auditedConnection) APICall(objType string, version int, id, request string, args, response interface{}) error {
+	start := time.Now()
+	err := c.Connection.APICall(objType, version, id, request, args, response)
+	action := fmt.Sprintf("%s(%d).%s", objType, version, request)
+	if id != "" {
+		action = fmt.Sprintf("%s(%d)[%s].%s", objType, version, id, request)
+	}
+	recordAudit(start, auditRecord{Kind: auditAPI, Host: c.Addr(), Action: action}, err)
+	return err
+}
+
+// openAuditedAPI opens an API connection with open, recording the
+// login, and returns it wrapped so that the calls made on it are
+// recorded too.
+func openAuditedAPI(open func() (api.Connection, error)) (api.Connection, error) {
+	start := time.Now()
+	conn, err := open()
+	record := auditRecord{Kind: auditAPI, Action: "login"}
+	if err == nil {
+		record.Host = conn.Addr()
+	}
+	recordAudit(start, record, err)
+	if err != nil {
+		return nil, err
+	}
+	return auditedConnection{conn}, nil
+}
+
+// takeAuditScript returns a script that prints the records in the named
+// file in remotePluginDir, if there are any, and removes it.
+func takeAuditScript(name string) string {
+	return fmt.Sprintf("[ ! -f %[1]s ] || { cat %[1]s && rm %[1]s; }", path.Join(remotePluginDir, name))
+}
+
+// collectRemoteAudit moves the records made by remote commands on the
+// state server into the local audit log, setting their phase to the
+// one running locally.
+func collectRemoteAudit(address string) error {
+	result, err := execSSH(address, takeAuditScript(remoteAuditFile), "", nil)
+	if err != nil {
+		return errors.Annotate(err, "reading remote audit records")
+	}
+	if result.Code != 0 {
+		return errors.Errorf("reading remote audit records: %q", result.Stderr)
+	}
+	return errors.Trace(mergeAudit(result.Stdout, address))
+}
+
+// collectMachineAudit moves the records made by
+// agent-config-convert-impl on the machines into the audit log, to be
+// collected from the state server with its own.
+func collectMachineAudit(machines []FlatMachine) error {
+	addresses := make(map[string]string)
+	for _, m := range machines {
+		addresses[m.ID] = m.Address
+	}
+	var failed []string
+	for _, r := range parallelCall(machines, takeAuditScript(machineAuditFile)) {
+		err := r.Error
+		if err == nil && r.Code != 0 {
+			err = errors.Errorf("%q", r.Stderr)
+		}
+		if err == nil {
+			err = mergeAudit(r.Stdout, addresses[r.MachineID])
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("machine %s: %v", r.MachineID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("reading machine audit records: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// mergeAudit appends the records read from another machine to the
+// audit log, setting their phase to the one running here. Records that
+// don't say which machine took the action were taken by the one at the
+// given address.
+func mergeAudit(output, address string) error {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if auditLog.path == "" {
+		return nil
+	}
+	var lines []byte
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			logger.Warningf("skipping remote audit record %q: %v", scanner.Text(), err)
+			continue
+		}
+		record.Phase = auditLog.phase
+		if record.Via == "" {
+			record.Via = address
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Annotate(err, "reading remote audit records")
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return errors.Trace(appendAuditLines(auditLog.path, lines))
+}
+
+// readAuditLog reads the records in an audit log.
+func readAuditLog(path string) ([]auditRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Annotate(err, "opening audit log")
+	}
+	defer f.Close()
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, errors.Annotatef(err, "audit log line %d", line)
+		}
+		records = append(records, record)
+	}
+	return records, errors.Annotate(scanner.Err(), "reading audit log")
+}
+
+// filterAudit returns the records for the machine, which can be a
+// machine id or an address, and the phase, either of which can be
+// empty to match all records.
+func filterAudit(records []auditRecord, machine, phase string) []auditRecord {
+	var result []auditRecord
+	for _, record := range records {
+		if machine != "" && record.Machine != machine && record.Host != machine {
+			continue
+		}
+		if phase != "" && record.Phase != phase {
+			continue
+		}
+		result = append(result, record)
+	}
+	return result
+}
+
+// newAuditCommand returns the audit supercommand, for looking at what
+// was done to an environment.
+func newAuditCommand() cmd.Command {
+	audit := cmd.NewSuperCommand(cmd.SuperCommandParams{
+		Name:        "audit",
+		UsagePrefix: "juju 1.25-upgrade",
+		Purpose:     "look at the audit log of an environment",
+		Doc:         auditDoc,
+	})
+	audit.Register(newAuditShowCommand())
+	return audit
+}
+
+var auditDoc = `
+Every command run over SSH, call made to the controller API and file
+installed, converted or moved on a machine is recorded in an audit log, with
+when it started and finished, where it ran and how it ended. Scripts are
+recorded with anything that looks like a secret redacted, and API calls
+without their arguments. The log is kept as JSON lines in
+$JUJU_DATA/1.25-upgrade/<envname>/audit.jsonl.
+
+`
+
+var auditShowDoc = `
+The show command lists the records in the audit log of an environment, oldest
+first, limited to those for a machine (by id or address) or a phase (the
+upgrade command that was running) if given. Only the first line of each
+script is shown; the whole record is in the audit log.
+
+`
+
+func newAuditShowCommand() cmd.Command {
+	return &auditShowCommand{}
+}
+
+type auditShowCommand struct {
+	cmd.CommandBase
+
+	name    string
+	machine string
+	phase   string
+}
+
+func (c *auditShowCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "show",
+		Args:    "<environment name>",
+		Purpose: "show the audit log of an environment",
+		Doc:     auditShowDoc,
+	}
+}
+
+func (c *auditShowCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.StringVar(&c.machine, "machine", "", "only show records for this machine id or address")
+	f.StringVar(&c.phase, "phase", "", "only show records for this phase")
+}
+
+func (c *auditShowCommand) Init(args []string) error {
+	if len(args) == 0 {
+		return errors.Errorf("no environment name specified")
+	}
+	c.name, args = args[0], args[1:]
+	return cmd.CheckEmpty(args)
+}
+
+func (c *auditShowCommand) Run(ctx *cmd.Context) error {
+	records, err := readAuditLog(filepath.Join(localStateDir(c.name), auditLogFile))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	writer := output.TabWriter(ctx.Stdout)
+	wrapper := output.Wrapper{writer}
+	wrapper.Println("START", "DURATION", "PHASE", "KIND", "MACHINE", "HOST", "CODE", "ACTION")
+	for _, record := range filterAudit(records, c.machine, c.phase) {
+		action := strings.SplitN(record.Action, "\n", 2)[0]
+		if record.Error != "" {
+			action += " (" + record.Error + ")"
+		}
+		wrapper.Println(
+			record.Start.Format(time.RFC3339),
+			fmt.Sprintf("%.3fs", record.Duration),
+			record.Phase,
+			record.Kind,
+			record.Machine,
+			record.Host,
+			record.Code,
+			action,
+		)
+	}
+	return writer.Flush()
+}
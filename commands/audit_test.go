@@ -0,0 +1,136 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type auditSuite struct{}
+
+var _ = gc.Suite(&auditSuite{})
+
+func (*auditSuite) TearDownTest(c *gc.C) {
+	setAuditPath("")
+	setAuditPhase("")
+}
+
+func (*auditSuite) TestRedactScript(c *gc.C) {
+	script := `
+mongo --password 'hunter2' --quiet
+export OLD_PASSWORD=s3cret; echo done
+echo "oldpassword: abc123" >> agent.conf
+echo keep-this
+`
+	c.Assert(redactScript(script), gc.Equals, `
+mongo --password <redacted> --quiet
+export OLD_PASSWORD=<redacted>; echo done
+echo "oldpassword: <redacted>" >> agent.conf
+echo keep-this
+`)
+}
+
+func (*auditSuite) TestRecordAndRead(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "env", auditLogFile)
+	start := time.Now()
+
+	// Nothing is recorded until there's somewhere to record it.
+	auditRun(start, "10.0.0.1", "", "uptime", RunResult{}, nil)
+
+	setAuditPath(path)
+	setAuditPhase("stop-agents")
+	auditRun(start, "10.0.0.2", "2", "\nservice jujud-machine-2 stop\n", RunResult{Code: 1}, nil)
+	auditFileChange(start, "10.0.0.3", "3", "/etc/rsyslog.d/25-juju.conf", errors.New("boom"))
+
+	records, err := readAuditLog(path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(records, gc.HasLen, 2)
+	c.Assert(records[0].Start.After(records[0].End), jc.IsFalse)
+	for i := range records {
+		records[i].Start = time.Time{}
+		records[i].End = time.Time{}
+		records[i].Duration = 0
+	}
+	c.Assert(records, jc.DeepEquals, []auditRecord{{
+		Phase:   "stop-agents",
+		Kind:    auditSSH,
+		Host:    "10.0.0.2",
+		Machine: "2",
+		Action:  "service jujud-machine-2 stop",
+		Code:    1,
+	}, {
+		Phase:   "stop-agents",
+		Kind:    auditFile,
+		Host:    "10.0.0.3",
+		Machine: "3",
+		Action:  "/etc/rsyslog.d/25-juju.conf",
+		Error:   "boom",
+	}})
+}
+
+func (*auditSuite) TestReadMissingAuditLog(c *gc.C) {
+	records, err := readAuditLog(filepath.Join(c.MkDir(), auditLogFile))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(records, gc.HasLen, 0)
+}
+
+func (*auditSuite) TestFilterAudit(c *gc.C) {
+	records := []auditRecord{
+		{Phase: "stop-agents", Host: "10.0.0.1"},
+		{Phase: "stop-agents", Host: "10.0.0.2", Machine: "2"},
+		{Phase: "upgrade-agents", Host: "10.0.0.2", Machine: "2"},
+		{Phase: "upgrade-agents", Host: "10.0.0.3", Machine: "3"},
+	}
+	c.Assert(filterAudit(records, "", ""), jc.DeepEquals, records)
+	c.Assert(filterAudit(records, "2", ""), jc.DeepEquals, records[1:3])
+	c.Assert(filterAudit(records, "10.0.0.1", ""), jc.DeepEquals, records[:1])
+	c.Assert(filterAudit(records, "", "upgrade-agents"), jc.DeepEquals, records[2:])
+	c.Assert(filterAudit(records, "2", "upgrade-agents"), jc.DeepEquals, records[2:3])
+}
+
+func (*auditSuite) TestStartAuditPhase(c *gc.C) {
+	startAuditPhase("upgrade-agents-impl")
+	c.Check(auditLog.path, gc.Equals, remoteAuditFile)
+	c.Check(auditLog.phase, gc.Equals, "upgrade-agents-impl")
+
+	// The machines keep their records apart from the state server's.
+	startAuditPhase("agent-config-convert-impl")
+	c.Check(auditLog.path, gc.Equals, machineAuditFile)
+	c.Check(auditLog.phase, gc.Equals, "agent-config-convert-impl")
+}
+
+func (*auditSuite) TestMergeAudit(c *gc.C) {
+	path := filepath.Join(c.MkDir(), remoteAuditFile)
+	setAuditPath(path)
+	setAuditPhase("upgrade-agents")
+
+	output := `{"Phase":"agent-config-convert-impl","Kind":"api","Host":"10.0.0.1:17070","Action":"login"}
+not a record
+{"Phase":"upgrade-agents-impl","Kind":"file","Host":"10.0.0.3","Machine":"3","Via":"10.0.0.2","Action":"/var/lib/juju/tools/2.2.0-trusty-amd64"}
+`
+	err := mergeAudit(output, "10.0.0.2")
+	c.Assert(err, jc.ErrorIsNil)
+
+	records, err := readAuditLog(path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(records, jc.DeepEquals, []auditRecord{{
+		Phase:  "upgrade-agents",
+		Kind:   auditAPI,
+		Host:   "10.0.0.1:17070",
+		Via:    "10.0.0.2",
+		Action: "login",
+	}, {
+		Phase:   "upgrade-agents",
+		Kind:    auditFile,
+		Host:    "10.0.0.3",
+		Machine: "3",
+		Via:     "10.0.0.2",
+		Action:  "/var/lib/juju/tools/2.2.0-trusty-amd64",
+	}})
+}
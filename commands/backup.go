@@ -0,0 +1,233 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju1/state/backups"
+)
+
+const (
+	remoteBackupDir = "/home/ubuntu/juju-1.25-upgrade-backups"
+
+	// backupChecksumFormat is the only checksum format the 1.25
+	// backups machinery produces.
+	backupChecksumFormat = "SHA-1, base64 encoded"
+)
+
+// backupInfo is written by backup-impl to describe the archive it
+// created on the state server.
+type backupInfo struct {
+	ID             string
+	Path           string
+	Checksum       string
+	ChecksumFormat string
+	Size           int64
+}
+
+// createBackup takes a 1.25 backup on the state server, downloads the
+// archive into backupDir and checks it against the backup metadata.
+// The path of the local archive is recorded in the run log and
+// returned.
+func (c *baseClientCommand) createBackup(ctx *cmd.Context, backupDir string) (string, error) {
+	ctx.Infof("creating backup of the 1.25 state server")
+	result, err := c.runRemote(ctx, "backup-impl")
+	if err != nil {
+		return "", errors.Annotate(err, "running backup-impl via SSH")
+	}
+	if result.Code != 0 {
+		return "", errors.Errorf("creating backup failed: %s", result.Stderr)
+	}
+	var info backupInfo
+	if err := json.Unmarshal([]byte(result.Stdout), &info); err != nil {
+		return "", errors.Annotate(err, "unmarshalling backup info")
+	}
+	if info.ChecksumFormat != backupChecksumFormat {
+		return "", errors.Errorf("unexpected checksum format %q", info.ChecksumFormat)
+	}
+
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return "", errors.Trace(err)
+	}
+	local := filepath.Join(backupDir, filepath.Base(info.Path))
+	ctx.Infof("downloading backup %s", info.ID)
+	scp := exec.Command("scp", fmt.Sprintf("ubuntu@%s:%s", c.address, info.Path), local)
+	if out, err := scp.CombinedOutput(); err != nil {
+		return "", errors.Annotatef(err, "downloading backup archive: %s", out)
+	}
+
+	checksum, err := checkBackup(local, info)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	// The archive holds the state server's secrets, so don't leave
+	// the copy in the ubuntu home directory once it is verified.
+	if _, err := runViaSSH(c.address, "rm -f "+info.Path, ""); err != nil {
+		logger.Warningf("removing %s from state server: %v", info.Path, err)
+	}
+
+	if err := appendRunLog(c.name, "backup %s saved to %s (checksum %s)", info.ID, local, checksum); err != nil {
+		return "", errors.Trace(err)
+	}
+	return local, nil
+}
+
+// checkBackup checks the downloaded archive against the metadata of the
+// backup, returning its checksum.
+func checkBackup(local string, info backupInfo) (string, error) {
+	checksum, size, err := backupChecksum(local)
+	if err != nil {
+		return "", errors.Annotate(err, "calculating backup checksum")
+	}
+	if size != info.Size || checksum != info.Checksum {
+		return "", errors.Errorf(
+			"backup %s does not match its metadata: got checksum %q (%d bytes), expected %q (%d bytes)",
+			local, checksum, size, info.Checksum, info.Size)
+	}
+	return checksum, nil
+}
+
+// backupChecksum returns the checksum of the archive in the same format
+// as the 1.25 backup metadata, along with its size.
+func backupChecksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, errors.Trace(err)
+	}
+	defer f.Close()
+	hasher := sha1.New()
+	size, err := io.Copy(hasher, f)
+	if err != nil {
+		return "", 0, errors.Trace(err)
+	}
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+var backupImplDoc = `
+
+backup-impl must be executed on an API server machine of a 1.25
+environment.
+
+The command creates a 1.25 backup using the environment's own backup
+machinery, and writes the archive to a file owned by the ubuntu user so
+that it can be downloaded.
+
+`
+
+func newBackupImplCommand() cmd.Command {
+	return &backupImplCommand{}
+}
+
+type backupImplCommand struct {
+	baseRemoteCommand
+}
+
+func (c *backupImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "backup-impl",
+		Purpose: "controller aspect of the pre-migration backup",
+		Doc:     backupImplDoc,
+	}
+}
+
+func (c *backupImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	defer st.Close()
+
+	tag, err := getCurrentMachineTag(dataDir)
+	if err != nil {
+		return errors.Annotate(err, "finding machine tag")
+	}
+
+	session := st.MongoSession().Copy()
+	defer session.Close()
+	dbInfo, err := backups.NewDBInfo(st.MongoConnectionInfo(), session)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	meta, err := backups.NewMetadataState(st, tag.Id())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	meta.Notes = "juju 1.25-upgrade pre-migration backup"
+
+	stor := backups.NewStorage(st)
+	defer stor.Close()
+	b := backups.NewBackups(stor)
+	paths := backups.Paths{
+		DataDir: dataDir,
+		LogsDir: "/var/log/juju",
+	}
+	if err := b.Create(meta, &paths, dbInfo); err != nil {
+		return errors.Annotate(err, "creating backup")
+	}
+
+	_, archive, err := b.Get(meta.ID())
+	if err != nil {
+		return errors.Annotate(err, "getting backup archive")
+	}
+	defer archive.Close()
+
+	path, err := writeBackupArchive(meta.ID(), archive)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	bytes, err := json.Marshal(backupInfo{
+		ID:             meta.ID(),
+		Path:           path,
+		Checksum:       meta.Checksum(),
+		ChecksumFormat: meta.ChecksumFormat(),
+		Size:           meta.Size(),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = ctx.GetStdout().Write(bytes)
+	return errors.Annotate(err, "writing backup info")
+}
+
+func writeBackupArchive(id string, archive io.Reader) (string, error) {
+	ubuntu, err := user.Lookup("ubuntu")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	uid, err := strconv.Atoi(ubuntu.Uid)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	gid, err := strconv.Atoi(ubuntu.Gid)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	if err := os.MkdirAll(remoteBackupDir, 0700); err != nil {
+		return "", errors.Trace(err)
+	}
+	if err := os.Chown(remoteBackupDir, uid, gid); err != nil {
+		return "", errors.Trace(err)
+	}
+	path := filepath.Join(remoteBackupDir, id+".tar.gz")
+	if err := writeFile(path, 0600, archive); err != nil {
+		return "", errors.Annotate(err, "writing backup archive")
+	}
+	return path, errors.Trace(os.Chown(path, uid, gid))
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/1.25-upgrade/juju1/state/backups"
+)
+
+type backupSuite struct{}
+
+var _ = gc.Suite(&backupSuite{})
+
+const (
+	testBackupContent  = "not really a tar.gz, but the bytes are all that count\n"
+	testBackupChecksum = "WfXmrxmRD4SJAr6zMyivQtSO4VM="
+)
+
+func writeBackup(c *gc.C) string {
+	path := filepath.Join(c.MkDir(), "juju-backup-20170601-120000.tar.gz")
+	err := ioutil.WriteFile(path, []byte(testBackupContent), 0600)
+	c.Assert(err, jc.ErrorIsNil)
+	return path
+}
+
+func (*backupSuite) TestBackupChecksum(c *gc.C) {
+	path := writeBackup(c)
+	checksum, size, err := backupChecksum(path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(checksum, gc.Equals, testBackupChecksum)
+	c.Check(size, gc.Equals, int64(len(testBackupContent)))
+
+	// The checksum matches the one 1.25 records for the archive.
+	f, err := os.Open(path)
+	c.Assert(err, jc.ErrorIsNil)
+	defer f.Close()
+	meta, err := backups.BuildMetadata(f)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(meta.Checksum(), gc.Equals, checksum)
+	c.Check(meta.ChecksumFormat(), gc.Equals, backupChecksumFormat)
+}
+
+func (*backupSuite) TestCheckBackup(c *gc.C) {
+	path := writeBackup(c)
+	checksum, err := checkBackup(path, backupInfo{
+		Checksum: testBackupChecksum,
+		Size:     int64(len(testBackupContent)),
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(checksum, gc.Equals, testBackupChecksum)
+}
+
+func (*backupSuite) TestCheckBackupMismatch(c *gc.C) {
+	path := writeBackup(c)
+	_, err := checkBackup(path, backupInfo{
+		Checksum: "2jmj7l5rSw0yVb/vlWAYkK/YBwk=",
+		Size:     int64(len(testBackupContent)),
+	})
+	c.Assert(err, gc.ErrorMatches, `backup .* does not match its metadata: got checksum "WfXmrxmRD4SJAr6zMyivQtSO4VM=" \(54 bytes\), expected "2jmj7l5rSw0yVb/vlWAYkK/YBwk=" \(54 bytes\)`)
+
+	_, err = checkBackup(path, backupInfo{
+		Checksum: testBackupChecksum,
+		Size:     1024,
+	})
+	c.Assert(err, gc.ErrorMatches, `backup .* does not match its metadata: .* expected .* \(1024 bytes\)`)
+}
+
+func (*backupSuite) TestCheckBackupMissing(c *gc.C) {
+	_, err := checkBackup(filepath.Join(c.MkDir(), "missing.tar.gz"), backupInfo{})
+	c.Assert(err, gc.ErrorMatches, "calculating backup checksum: .*")
+}
@@ -4,13 +4,15 @@
 package commands
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"io"
 	"path/filepath"
 	"strings"
 
+	"gopkg.in/juju/names.v2"
 	"gopkg.in/macaroon-bakery.v1/httpbakery"
+	"gopkg.in/macaroon.v1"
 
 	"github.com/juju/cmd"
 	"github.com/juju/errors"
@@ -18,6 +20,7 @@ import (
 
 	"github.com/juju/1.25-upgrade/juju1/environs/configstore"
 	"github.com/juju/1.25-upgrade/juju2/api"
+	"github.com/juju/1.25-upgrade/juju2/api/migrationcredentials"
 	"github.com/juju/1.25-upgrade/juju2/cmd/modelcmd"
 	"github.com/juju/1.25-upgrade/juju2/jujuclient"
 )
@@ -29,16 +32,46 @@ type baseClientCommand struct {
 
 	info configstore.EnvironInfo
 
-	name    string
-	address string
-	plugin  string
+	name   string
+	plugin string
+
+	// addresses holds the hosts of the environment's API addresses.
+	// address is the one remote commands run on, picked by
+	// selectStateServer when the first command runs.
+	addresses []string
+	address   string
 
 	controller modelcmd.ControllerCommandBase
 
 	remoteCommand string
 	remoteArgs    string
+
+	// filter selects the machines agent commands act on; it's only
+	// set by commands that call setFilterFlags.
+	filter machineFilter
+
+	// checkReachable makes agent commands leave out the machines that
+	// can't be reached, which is an error unless allowUnreachable is
+	// set.
+	checkReachable   bool
+	allowUnreachable bool
+
+	// selected holds the machines picked by filter and checkReachable
+	// when Run ran the remote command.
+	selected []string
+
+	// passController makes the remote command get the controller info
+	// on its stdin; see remoteControllerInfo. It's set for commands
+	// that need the controller, unless they clear it.
+	passController bool
 }
 
+// These are variables so that tests can replace them.
+var (
+	runRemoteCommand           = (*baseClientCommand).runRemoteWithStdin
+	getMigrationControllerInfo = (*baseClientCommand).getMigrationControllerInfo
+)
+
 // Init will grab the first arg as the environment name.
 // Validation of the name is also done here.
 func (c *baseClientCommand) init(args []string) ([]string, error) {
@@ -49,10 +82,21 @@ func (c *baseClientCommand) init(args []string) ([]string, error) {
 		c.plugin = plugin
 	}
 
+	if err := c.filter.validate(); err != nil {
+		return args, errors.Trace(err)
+	}
+
 	if len(args) == 0 {
 		return args, errors.Errorf("no environment name specified")
 	}
 	c.name, args = args[0], args[1:]
+	setAuditPath(filepath.Join(localStateDir(c.name), auditLogFile))
+
+	// The environment's UUID is needed to get credentials for the
+	// controller, so load its info first.
+	if err := c.loadInfo(); err != nil {
+		return args, err
+	}
 
 	if c.needsController {
 		if len(args) == 0 {
@@ -62,44 +106,28 @@ func (c *baseClientCommand) init(args []string) ([]string, error) {
 		if err := c.controller.SetControllerName(args[0]); err != nil {
 			return args, errors.Trace(err)
 		}
-		if err := c.setRemoteControllerInfo(); err != nil {
-			return args, errors.Trace(err)
-		}
+		c.passController = true
 		args = args[1:]
 	}
 
-	if err := c.loadInfo(); err != nil {
-		return args, err
-	}
-
 	return args, nil
 }
 
-func (c *baseClientCommand) setRemoteControllerInfo() error {
-	// Read the controller info and pass it as extra args to the
-	// remote command.
-	cinfo, err := c.GetControllerAPIInfo()
-	if err != nil {
-		return errors.Trace(err)
+// remoteControllerInfo returns the controller info to write to the
+// remote command's stdin, rather than pass as an argument, as it has a
+// macaroon in it; or nil if the command doesn't need the controller.
+// The macaroon expires after an hour, so a new one is got for each
+// remote command run, however long the command has been running.
+func (c *baseClientCommand) remoteControllerInfo() ([]byte, error) {
+	if !c.passController {
+		return nil, nil
 	}
-
-	info := Info{
-		Addrs:       cinfo.Addrs,
-		SNIHostName: cinfo.SNIHostName,
-		CACert:      cinfo.CACert,
-		Tag:         cinfo.Tag.String(),
-		Password:    cinfo.Password,
-		Macaroons:   cinfo.Macaroons,
-	}
-
-	// Lets serialize to yaml, then base64 encode it.
-	bytes, err := json.Marshal(info)
+	cinfo, err := getMigrationControllerInfo(c)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
-	logger.Debugf("info: %s", bytes)
-	c.remoteArgs = base64.StdEncoding.EncodeToString(bytes)
-	return nil
+	info, err := encodeControllerInfo(cinfo)
+	return info, errors.Trace(err)
 }
 
 func (c *baseClientCommand) GetControllerAPIInfo() (*api.Info, error) {
@@ -118,15 +146,46 @@ func (c *baseClientCommand) GetControllerAPIInfo() (*api.Info, error) {
 
 	// Connect to the target controller, ensuring up-to-date macaroons,
 	// and return the macaroons in the cookie jar for the controller.
-	//
-	// TODO(axw,mjs) add a controller API that returns a macaroon that
-	// may be used for the sole purpose of migration.
-	api, err := c.controller.NewAPIRoot()
+	root, err := c.newAPIRoot()
+	if err != nil {
+		return nil, errors.Annotate(err, "connecting to target controller")
+	}
+	defer root.Close()
+	info.Macaroons = httpbakery.MacaroonsForURL(apiContext.Jar, root.CookieURL())
+	return info, nil
+}
+
+// getMigrationControllerInfo returns the controller info passed to
+// remote commands, which run on the 1.25 state servers. Rather than the
+// controller admin's password and macaroons, it has a macaroon that
+// only lets the environment be migrated into the controller, and that
+// expires after an hour.
+func (c *baseClientCommand) getMigrationControllerInfo() (*api.Info, error) {
+	info, err := c.controller.GetControllerAPIInfo(
+		c.controller.ClientStore(),
+		c.controller.ControllerName())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	root, err := c.newAPIRoot()
 	if err != nil {
 		return nil, errors.Annotate(err, "connecting to target controller")
 	}
-	defer api.Close()
-	info.Macaroons = httpbakery.MacaroonsForURL(apiContext.Jar, api.CookieURL())
+	defer root.Close()
+	if root.BestFacadeVersion("MigrationCredentials") == 0 {
+		return nil, errors.Errorf(
+			"controller %q doesn't support migration credentials",
+			c.controller.ControllerName(),
+		)
+	}
+
+	modelTag := names.NewModelTag(c.info.APIEndpoint().EnvironUUID)
+	m, err := migrationcredentials.NewClient(root).CreateMacaroon(modelTag)
+	if err != nil {
+		return nil, errors.Annotate(err, "getting migration credentials")
+	}
+	info.Password = ""
+	info.Macaroons = []macaroon.Slice{{m}}
 	return info, nil
 }
 
@@ -147,32 +206,25 @@ func (c *baseClientCommand) loadInfo() error {
 	}
 
 	c.info = info
-
-	// Grab the first address
-	addresses := info.APIEndpoint().Addresses
-	address := addresses[0]
-	c.address = address[:strings.LastIndex(address, ":")]
-
+	c.addresses = stateServerHosts(info.APIEndpoint().Addresses)
 	return nil
 }
 
 func (c *baseClientCommand) Run(ctx *cmd.Context) error {
-	if err := checkUpdatePlugin(ctx, c.plugin, c.address); err != nil {
-		return errors.Annotate(err, "checking remote plugin")
+	args := []string{c.remoteArgs}
+	if c.filter.enabled() || c.checkReachable {
+		ids, err := c.selectedMachineIDs(ctx)
+		if err != nil {
+			return errors.Annotate(err, "selecting machines")
+		}
+		c.selected = ids
+		args = append([]string{"--machines=" + strings.Join(ids, ",")}, args...)
 	}
-
-	pluginBase := filepath.Base(c.plugin)
-
-	debug := ""
-	if logger.IsDebugEnabled() {
-		debug = "--debug"
+	stdin, err := c.remoteControllerInfo()
+	if err != nil {
+		return errors.Trace(err)
 	}
-
-	result, err := runViaSSH(
-		c.address,
-		fmt.Sprintf("./%s %s %s %s\n", pluginBase, c.remoteCommand, c.remoteArgs, debug),
-		"")
-
+	result, err := runRemoteCommand(c, ctx, stdin, c.remoteCommand, args...)
 	if err != nil {
 		return errors.Annotatef(err, "running %s via SSH", c.remoteCommand)
 	}
@@ -186,3 +238,52 @@ func (c *baseClientCommand) Run(ctx *cmd.Context) error {
 
 	return nil
 }
+
+// runRemote runs the plugin on the state server with the given command
+// and arguments, making sure the remote plugin is up to date first.
+func (c *baseClientCommand) runRemote(ctx *cmd.Context, command string, args ...string) (RunResult, error) {
+	return runRemoteCommand(c, ctx, nil, command, args...)
+}
+
+// runRemoteWithStdin is like runRemote, but writes stdin to the remote
+// command's standard input if it isn't empty.
+func (c *baseClientCommand) runRemoteWithStdin(ctx *cmd.Context, stdin []byte, command string, args ...string) (RunResult, error) {
+	if c.address == "" {
+		if err := c.selectStateServer(ctx); err != nil {
+			return RunResult{}, errors.Annotate(err, "selecting state server")
+		}
+		if err := c.installSSHConfig(ctx, c.address); err != nil {
+			c.address = ""
+			return RunResult{}, errors.Trace(err)
+		}
+	}
+	return c.runPlugin(c.address, stdin, command, args...)
+}
+
+// runPlugin runs the plugin, which must be up to date, on the state
+// server with the given address.
+func (c *baseClientCommand) runPlugin(address string, stdin []byte, command string, args ...string) (RunResult, error) {
+	pluginBase := filepath.Base(c.plugin)
+
+	debug := ""
+	if logger.IsDebugEnabled() {
+		debug = "--debug"
+	}
+
+	script := fmt.Sprintf("cd %s && ./%s %s %s %s\n", remotePluginDir, pluginBase, command, strings.Join(args, " "), debug)
+	var stdinReader io.Reader
+	if len(stdin) > 0 {
+		stdinReader = bytes.NewReader(stdin)
+	}
+	result, err := runViaSSHWithStdin(address, script, "", stdinReader)
+	if auditErr := collectRemoteAudit(address); auditErr != nil {
+		logger.Warningf("%v", auditErr)
+	}
+	return result, err
+}
+
+// newAPIRoot connects to the controller, recording the calls made in
+// the audit log.
+func (c *baseClientCommand) newAPIRoot() (api.Connection, error) {
+	return openAuditedAPI(c.controller.NewAPIRoot)
+}
@@ -4,13 +4,15 @@
 package commands
 
 import (
-	"encoding/base64"
 	"encoding/json"
+	"io"
+	"os"
 
 	"gopkg.in/macaroon.v1"
 
 	"github.com/juju/cmd"
 	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
 	"github.com/juju/names"
 
 	"github.com/juju/1.25-upgrade/juju1/environs"
@@ -27,9 +29,19 @@ type baseRemoteCommand struct {
 	needsController bool
 
 	controllerInfo *api.Info
+
+	machineIDs []string
 }
 
+// controllerInfoVersion is the version of the controller info format
+// written to the stdin of remote commands that need the controller. It
+// must change whenever Info does, so that a stale plugin on the state
+// server fails clearly rather than misreading it.
+const controllerInfoVersion = 1
+
+// Info is the controller info passed to the remote commands.
 type Info struct {
+	Version     int
 	Addrs       []string
 	SNIHostName string
 	CACert      string
@@ -38,41 +50,92 @@ type Info struct {
 	Macaroons   []macaroon.Slice
 }
 
+// encodeControllerInfo returns the controller info in the format
+// readControllerInfo reads: a line of JSON.
+func encodeControllerInfo(cinfo *api.Info) ([]byte, error) {
+	info := Info{
+		Version:     controllerInfoVersion,
+		Addrs:       cinfo.Addrs,
+		SNIHostName: cinfo.SNIHostName,
+		CACert:      cinfo.CACert,
+		Tag:         cinfo.Tag.String(),
+		Password:    cinfo.Password,
+		Macaroons:   cinfo.Macaroons,
+	}
+	bytes, err := json.Marshal(info)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return append(bytes, '\n'), nil
+}
+
+// readControllerInfo reads the controller info written by
+// encodeControllerInfo.
+func readControllerInfo(r io.Reader) (*api.Info, error) {
+	var info Info
+	if err := json.NewDecoder(r).Decode(&info); err == io.EOF {
+		return nil, errors.New("missing controller info")
+	} else if err != nil {
+		return nil, errors.Annotate(err, "unmarshalling controller info")
+	}
+	if info.Version != controllerInfoVersion {
+		return nil, errors.Errorf(
+			"controller info version %d not supported (expected %d), the plugin may be out of date",
+			info.Version, controllerInfoVersion,
+		)
+	}
+	tag, err := names.ParseTag(info.Tag)
+	if err != nil {
+		return nil, errors.Annotate(err, "parsing tag")
+	}
+	return &api.Info{
+		Addrs:       info.Addrs,
+		SNIHostName: info.SNIHostName,
+		CACert:      info.CACert,
+		Tag:         tag,
+		Password:    info.Password,
+		Macaroons:   info.Macaroons,
+	}, nil
+}
+
 func (c *baseRemoteCommand) init(args []string) ([]string, error) {
 	if c.needsController {
-		if len(args) == 0 {
-			return args, errors.Errorf("missing controller info")
-		}
-
-		bytes, err := base64.StdEncoding.DecodeString(args[0])
-		if err != nil {
-			return args, errors.Annotate(err, "decoding controller info")
-		}
-		var info Info
-		err = json.Unmarshal(bytes, &info)
+		// The client writes the controller info to stdin, so that
+		// the password and macaroons aren't on the command line.
+		info, err := readControllerInfo(os.Stdin)
 		if err != nil {
-			return args, errors.Annotate(err, "unmarshalling controller info")
+			return args, errors.Trace(err)
 		}
-		tag, err := names.ParseTag(info.Tag)
-		if err != nil {
-			return args, errors.Annotate(err, "parsing tag")
-		}
-		c.controllerInfo = &api.Info{
-			Addrs:       info.Addrs,
-			SNIHostName: info.SNIHostName,
-			CACert:      info.CACert,
-			Tag:         tag,
-			Password:    info.Password,
-			Macaroons:   info.Macaroons,
-		}
-
-		args = args[1:]
+		c.controllerInfo = info
 	}
 	return args, nil
 }
 
+// setMachineFlags adds the --machines flag, used by the client to
+// restrict a command to some of the machines.
+func (c *baseRemoteCommand) setMachineFlags(f *gnuflag.FlagSet) {
+	f.Var(cmd.NewStringsValue(nil, &c.machineIDs), "machines", "only act on these machines")
+}
+
+// selectedMachines returns the machines in the environment, limited to
+// those given with --machines if any were.
+func (c *baseRemoteCommand) selectedMachines(st *state.State) ([]FlatMachine, error) {
+	machines, err := getMachines(st)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(c.machineIDs) == 0 {
+		return machines, nil
+	}
+	return selectMachines(machines, c.machineIDs)
+}
+
+// getControllerConnection connects to the controller, recording the
+// calls made in the audit log.
 func (c *baseRemoteCommand) getControllerConnection() (api.Connection, error) {
-	return api.Open(c.controllerInfo, api.DefaultDialOpts())
+	return openAuditedAPI(func() (api.Connection, error) {
+		return api.Open(c.controllerInfo, api.DefaultDialOpts())
+	})
 }
 
 func (c *baseRemoteCommand) getState(ctx *cmd.Context) (*state.State, error) {
@@ -0,0 +1,53 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"bytes"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/juju2/api"
+)
+
+type controllerInfoSuite struct{}
+
+var _ = gc.Suite(&controllerInfoSuite{})
+
+func (*controllerInfoSuite) TestRoundTrip(c *gc.C) {
+	info := &api.Info{
+		Addrs:    []string{"10.0.0.1:17070", "10.0.0.2:17070"},
+		CACert:   "ca cert",
+		Tag:      names.NewUserTag("admin"),
+		Password: "sekrit",
+	}
+	encoded, err := encodeControllerInfo(info)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(encoded), gc.Matches, `\{"Version":1,.*\}\n`)
+
+	read, err := readControllerInfo(bytes.NewReader(encoded))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(read.Addrs, jc.DeepEquals, info.Addrs)
+	c.Check(read.CACert, gc.Equals, info.CACert)
+	c.Check(read.Tag.String(), gc.Equals, "user-admin")
+	c.Check(read.Password, gc.Equals, info.Password)
+}
+
+func (*controllerInfoSuite) TestReadMissing(c *gc.C) {
+	_, err := readControllerInfo(strings.NewReader(""))
+	c.Assert(err, gc.ErrorMatches, "missing controller info")
+}
+
+func (*controllerInfoSuite) TestReadWrongVersion(c *gc.C) {
+	_, err := readControllerInfo(strings.NewReader(`{"Version":2,"Tag":"user-admin"}` + "\n"))
+	c.Assert(err, gc.ErrorMatches, `controller info version 2 not supported \(expected 1\), the plugin may be out of date`)
+}
+
+func (*controllerInfoSuite) TestReadUnversioned(c *gc.C) {
+	_, err := readControllerInfo(strings.NewReader(`{"Tag":"user-admin"}`))
+	c.Assert(err, gc.ErrorMatches, `controller info version 0 not supported .*`)
+}
@@ -2,7 +2,9 @@ package commands
 
 import (
 	"bytes"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/juju/cmd"
 	"github.com/juju/errors"
@@ -13,11 +15,28 @@ import (
 const systemIdentity = "/var/lib/juju/system-identity"
 
 type FlatMachine struct {
-	Model   string
-	Series  string
-	ID      string
-	Address string
-	Tools   string
+	Model    string
+	Series   string
+	ID       string
+	ParentID string `json:",omitempty"`
+	Address  string
+	Tools    string
+
+	// Applications holds the names of the applications with units on
+	// the machine.
+	Applications []string `json:",omitempty"`
+
+	// AddressReason says why Address was picked among the machine's
+	// addresses, or why none was.
+	AddressReason string `json:",omitempty"`
+
+	// Reachability says whether the machine can be reached from the
+	// state server.
+	Reachability reachability `json:",omitempty"`
+
+	// Route holds the hosts that SSH connections to the machine go
+	// through, if any.
+	Route []sshHop `json:",omitempty"`
 }
 
 type RunResult struct {
@@ -28,6 +47,24 @@ type RunResult struct {
 
 // runViaSSH runs script in the remote machine with address addr.
 func runViaSSH(addr string, script, identity string) (RunResult, error) {
+	return runViaSSHWithStdin(addr, script, identity, nil)
+}
+
+// runViaSSHWithStdin runs script in the remote machine with address
+// addr, with stdin as the script's standard input. Anything secret the
+// script needs is passed this way, as arguments show up in ps on the
+// remote machine and in its auth log (through sudo). The script is
+// recorded in the audit log.
+func runViaSSHWithStdin(addr string, script, identity string, stdin io.Reader) (RunResult, error) {
+	start := time.Now()
+	result, err := execSSH(addr, script, identity, stdin)
+	auditRun(start, addr, "", script, result, err)
+	return result, err
+}
+
+// execSSH does the work of runViaSSHWithStdin, without recording the
+// script in the audit log.
+func execSSH(addr string, script, identity string, stdin io.Reader) (RunResult, error) {
 	// This is taken from cmd/juju/ssh.go there is no other clear way to set user
 	userAddr := "ubuntu@" + addr
 	sshOptions := ssh.Options{}
@@ -37,6 +74,7 @@ func runViaSSH(addr string, script, identity string) (RunResult, error) {
 	userCmd := ssh.Command(userAddr, []string{"sudo", "-n", "bash", "-c " + utils.ShQuote(script)}, &sshOptions)
 	var stdoutBuf bytes.Buffer
 	var stderrBuf bytes.Buffer
+	userCmd.Stdin = stdin
 	userCmd.Stdout = &stdoutBuf
 	userCmd.Stderr = &stderrBuf
 	var result RunResult
@@ -66,6 +104,13 @@ type DistResult struct {
 }
 
 func parallelCall(machines []FlatMachine, script string) []DistResult {
+	return parallelCallWithStdin(machines, script, nil)
+}
+
+// parallelCallWithStdin is like parallelCall, but if stdin isn't nil
+// what it returns for each machine is written to the script's standard
+// input there.
+func parallelCallWithStdin(machines []FlatMachine, script string, stdin func(FlatMachine) []byte) []DistResult {
 
 	var (
 		wg      sync.WaitGroup
@@ -74,10 +119,27 @@ func parallelCall(machines []FlatMachine, script string) []DistResult {
 	)
 
 	for _, machine := range machines {
+		if machine.Reachability != reachable {
+			// Don't wait for SSH to time out on machines already
+			// known to be unreachable.
+			lock.Lock()
+			results = append(results, DistResult{
+				Model:     machine.Model,
+				Series:    machine.Series,
+				MachineID: machine.ID,
+				Error:     errors.Errorf("machine %s %s: %s", machine.ID, machine.Reachability, machine.AddressReason),
+			})
+			lock.Unlock()
+			continue
+		}
 		wg.Add(1)
 		go func(machine FlatMachine) {
 			defer wg.Done()
-			run, err := runViaSSH(machine.Address, script, systemIdentity)
+			var input io.Reader
+			if stdin != nil {
+				input = bytes.NewReader(stdin(machine))
+			}
+			run, err := runOnMachineWithStdin(machine, script, input)
 			result := DistResult{
 				Model:     machine.Model,
 				Series:    machine.Series,
@@ -0,0 +1,260 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"github.com/juju/names"
+
+	"github.com/juju/1.25-upgrade/juju1/agent"
+	"github.com/juju/1.25-upgrade/juju1/environs"
+	"github.com/juju/1.25-upgrade/juju1/juju/paths"
+	"github.com/juju/1.25-upgrade/juju1/mongo"
+	"github.com/juju/1.25-upgrade/juju1/state"
+	"github.com/juju/1.25-upgrade/juju1/state/backups"
+	"github.com/juju/1.25-upgrade/juju1/version"
+)
+
+var exportBackupDoc = `
+The export-backup command produces the same model description as
+verify-source, but reads it from a 1.25 backup archive rather than from a
+live state server.
+
+The archive is unpacked locally, its database dump is restored into a
+temporary mongod, and the state is opened from there. As with verify-source,
+--model-name gives the model a new name. Both mongod and
+mongorestore must be available on the machine running the command, either
+from the juju-mongodb package or on the $PATH.
+
+`
+
+func newExportBackupCommand() cmd.Command {
+	return &exportBackupCommand{}
+}
+
+type exportBackupCommand struct {
+	cmd.CommandBase
+
+	archive   string
+	modelName string
+}
+
+func (c *exportBackupCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.StringVar(&c.modelName, "model-name", "", "the name for the migrated model")
+}
+
+func (c *exportBackupCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "export-backup",
+		Args:    "<backup archive>",
+		Purpose: "export a 1.25 environment from a backup archive",
+		Doc:     exportBackupDoc,
+	}
+}
+
+func (c *exportBackupCommand) Init(args []string) error {
+	if len(args) == 0 {
+		return errors.Errorf("no backup archive specified")
+	}
+	c.archive, args = args[0], args[1:]
+	if err := validateModelName(c.modelName); err != nil {
+		return errors.Trace(err)
+	}
+	return cmd.CheckEmpty(args)
+}
+
+func (c *exportBackupCommand) Run(ctx *cmd.Context) error {
+	archive, err := os.Open(c.archive)
+	if err != nil {
+		return errors.Annotate(err, "opening backup archive")
+	}
+	defer archive.Close()
+
+	ctx.Infof("unpacking backup archive")
+	ws, err := backups.NewArchiveWorkspaceReader(archive)
+	if err != nil {
+		return errors.Annotate(err, "unpacking backup archive")
+	}
+	defer ws.Close()
+
+	meta, err := ws.Metadata()
+	if err != nil {
+		return errors.Annotate(err, "reading backup metadata")
+	}
+	if !names.IsValidEnvironment(meta.Origin.Environment) {
+		return errors.Errorf("backup has no valid environment UUID: %q", meta.Origin.Environment)
+	}
+	ctx.Verbosef("environment: %s, version: %s", meta.Origin.Environment, meta.Origin.Version)
+
+	root := filepath.Join(ws.RootDir, "root")
+	if err := ws.UnpackFilesBundle(root); err != nil {
+		return errors.Annotate(err, "unpacking files bundle")
+	}
+	caCert, err := backupCACert(root)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	dbDir := filepath.Join(ws.RootDir, "db")
+	if err := os.Mkdir(dbDir, 0700); err != nil {
+		return errors.Trace(err)
+	}
+	ctx.Infof("restoring database dump")
+	if err := restoreDump(dbDir, ws.DBDumpDir, meta.Origin.Version); err != nil {
+		return errors.Annotate(err, "restoring database dump")
+	}
+
+	ctx.Infof("starting temporary mongod")
+	pemFile := filepath.Join(root, dataDir, "server.pem")
+	mongod, err := startTempMongo(dbDir, pemFile)
+	if err != nil {
+		return errors.Annotate(err, "starting temporary mongod")
+	}
+	defer mongod.stop()
+
+	// The temporary mongod runs without --auth, so connecting as
+	// the administrator (no tag, no password) is all that's needed.
+	info := &mongo.MongoInfo{
+		Info: mongo.Info{
+			Addrs:  []string{mongod.address},
+			CACert: caCert,
+		},
+	}
+	st, err := state.Open(names.NewEnvironTag(meta.Origin.Environment), info, mongo.DefaultDialOpts(), environs.NewStatePolicy())
+	if err != nil {
+		return errors.Annotate(err, "opening state connection")
+	}
+	defer st.Close()
+
+	// The machines can't be probed from a backup.
+	return errors.Trace(writeModel(ctx, st, nil, c.modelName))
+}
+
+// backupCACert returns the CA certificate from the state server agent
+// config found in the unpacked files bundle.
+func backupCACert(root string) (string, error) {
+	tag, err := getCurrentMachineTag(filepath.Join(root, dataDir))
+	if err != nil {
+		return "", errors.Annotate(err, "finding machine tag in backup")
+	}
+	config, err := agent.ReadConfig(agent.ConfigPath(filepath.Join(root, dataDir), tag))
+	if err != nil {
+		return "", errors.Annotate(err, "loading agent config from backup")
+	}
+	return config.CACert(), nil
+}
+
+// mongoRestoreArgs mirrors the restore arguments used by the 1.25
+// backups machinery, but targets dbDir rather than the live database.
+func mongoRestoreArgs(ver version.Number, dbDir, dumpDir string) ([]string, error) {
+	switch {
+	case ver.Major == 1 && ver.Minor < 22:
+		return []string{"--drop", "--journal", "--dbpath", dbDir, dumpDir}, nil
+	case ver.Major == 1 && ver.Minor >= 22:
+		return []string{"--drop", "--journal", "--oplogReplay", "--dbpath", dbDir, dumpDir}, nil
+	default:
+		return nil, errors.Errorf("backup version %s is not a 1.x backup", ver)
+	}
+}
+
+func restoreDump(dbDir, dumpDir string, ver version.Number) error {
+	mongoRestore, err := paths.MongorestorePath()
+	if err != nil {
+		return errors.Annotate(err, "mongorestore not available")
+	}
+	args, err := mongoRestoreArgs(ver, dbDir, dumpDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	out, err := exec.Command(mongoRestore, args...).CombinedOutput()
+	if err != nil {
+		return errors.Annotatef(err, "mongorestore: %s", out)
+	}
+	logger.Debugf("mongorestore: %s", out)
+	return nil
+}
+
+type tempMongo struct {
+	cmd     *exec.Cmd
+	address string
+	logFile string
+}
+
+// startTempMongo runs a mongod on a free local port, serving the
+// database in dbDir with the same TLS setup as the 1.25 state server.
+func startTempMongo(dbDir, pemFile string) (*tempMongo, error) {
+	mongod, err := mongo.Path()
+	if err != nil {
+		return nil, errors.Annotate(err, "mongod not available")
+	}
+	port, err := freePort()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	logFile := filepath.Join(filepath.Dir(dbDir), "mongod.log")
+	command := exec.Command(mongod,
+		"--dbpath", dbDir,
+		"--bind_ip", "127.0.0.1",
+		"--port", fmt.Sprint(port),
+		"--sslOnNormalPorts",
+		"--sslPEMKeyFile", pemFile,
+		"--sslPEMKeyPassword", "ignored",
+		"--noprealloc",
+		"--smallfiles",
+		"--logpath", logFile,
+	)
+	if err := command.Start(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	m := &tempMongo{
+		cmd:     command,
+		address: fmt.Sprintf("127.0.0.1:%d", port),
+		logFile: logFile,
+	}
+	if err := m.waitListening(30 * time.Second); err != nil {
+		m.stop()
+		return nil, errors.Trace(err)
+	}
+	return m, nil
+}
+
+func (m *tempMongo) waitListening(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", m.address, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	log, _ := ioutil.ReadFile(m.logFile)
+	return errors.Errorf("mongod not listening on %s after %s:\n%s", m.address, timeout, log)
+}
+
+func (m *tempMongo) stop() {
+	if err := m.cmd.Process.Kill(); err != nil {
+		logger.Warningf("stopping temporary mongod: %v", err)
+	}
+	m.cmd.Wait()
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, errors.Annotate(err, "finding a free port")
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
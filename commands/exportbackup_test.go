@@ -0,0 +1,32 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/1.25-upgrade/juju1/version"
+)
+
+type exportBackupSuite struct{}
+
+var _ = gc.Suite(&exportBackupSuite{})
+
+func (*exportBackupSuite) TestRestoreArgsLegacy(c *gc.C) {
+	args, err := mongoRestoreArgs(version.MustParse("1.20.14"), "/tmp/db", "/tmp/dump")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(args, jc.DeepEquals, []string{"--drop", "--journal", "--dbpath", "/tmp/db", "/tmp/dump"})
+}
+
+func (*exportBackupSuite) TestRestoreArgsOplog(c *gc.C) {
+	args, err := mongoRestoreArgs(version.MustParse("1.25.10"), "/tmp/db", "/tmp/dump")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(args, jc.DeepEquals, []string{"--drop", "--journal", "--oplogReplay", "--dbpath", "/tmp/db", "/tmp/dump"})
+}
+
+func (*exportBackupSuite) TestRestoreArgsUnknownVersion(c *gc.C) {
+	_, err := mongoRestoreArgs(version.MustParse("2.1.0"), "/tmp/db", "/tmp/dump")
+	c.Assert(err, gc.ErrorMatches, `backup version 2.1.0 is not a 1.x backup`)
+}
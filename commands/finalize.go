@@ -0,0 +1,264 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+
+	"github.com/juju/1.25-upgrade/juju1/state"
+)
+
+var finalizeDoc = `
+The purpose of the finalize command is to decommission the 1.25 state server
+once the environment has been migrated and every agent is running on the 2.x
+controller. It must be the last step: the 1.25 environment can't be used, or
+restored to, afterwards, other than from the backup taken by stop-agents.
+
+The status of the migrated model is checked first, and the command refuses to
+continue if any machine or unit agent hasn't connected to the controller with
+its version (see start-agents --wait). Use --force to skip the check.
+
+Then, on each 1.25 state server machine:
+ - the 1.25 machine agent is stopped and disabled, unless it was converted to
+   2.x by the upgrade (the exported model has every machine only hosting
+   units, so a converted machine agent keeps the machine's units deployed);
+ - the juju-db service is stopped and disabled;
+ - /var/lib/juju/db is archived under /var/lib/juju/1.25-upgrade and removed;
+ - the state server certificate and shared secret are moved there too.
+
+Unit agents are left alone, so workloads on the state server machines keep
+running under their migrated agents.
+
+`
+
+func newFinalizeCommand() cmd.Command {
+	return &finalizeCommand{
+		baseClientCommand: baseClientCommand{
+			needsController: true,
+			remoteCommand:   "finalize-impl",
+		},
+	}
+}
+
+type finalizeCommand struct {
+	baseClientCommand
+
+	force bool
+}
+
+func (c *finalizeCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "finalize",
+		Args:    "<environment name> <controller name>",
+		Purpose: "decommission the 1.25 state server",
+		Doc:     finalizeDoc,
+	}
+}
+
+func (c *finalizeCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.BoolVar(&c.force, "force", false, "don't check that all agents are running on the controller")
+}
+
+func (c *finalizeCommand) Init(args []string) error {
+	args, err := c.baseClientCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The controller is only used to check the agents here;
+	// finalize-impl doesn't need it.
+	c.passController = false
+	return cmd.CheckEmpty(args)
+}
+
+func (c *finalizeCommand) Run(ctx *cmd.Context) error {
+	if c.force {
+		ctx.Infof("not checking agents on the controller")
+	} else if err := c.checkAgents(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	if err := c.baseClientCommand.Run(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	return appendRunLog(c.name, "finalize: 1.25 state server decommissioned")
+}
+
+func (c *finalizeCommand) checkAgents(ctx *cmd.Context) error {
+	conn, err := c.openTargetModel()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer conn.Close()
+
+	ver, ok := conn.ServerVersion()
+	if !ok {
+		return errors.New("target controller did not report its version")
+	}
+	status, err := modelStatus(conn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if pending := pendingAgents(status, ver, nil); len(pending) > 0 {
+		writePendingAgents(ctx, pending)
+		return errors.Errorf("%d agents not running on the controller; use --force to finalize anyway", len(pending))
+	}
+	return nil
+}
+
+var finalizeImplDoc = `
+
+finalize-impl must be executed on an API server machine of a 1.25
+environment.
+
+The command will get a list of the state server machines, and their
+addresses, and then ssh to them to stop the 1.25 state server.
+
+`
+
+func newFinalizeImplCommand() cmd.Command {
+	return &finalizeImplCommand{}
+}
+
+type finalizeImplCommand struct {
+	baseRemoteCommand
+}
+
+func (c *finalizeImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "finalize-impl",
+		Purpose: "controller aspect of finalize",
+		Doc:     finalizeImplDoc,
+	}
+}
+
+func (c *finalizeImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	machines, err := getStateServerMachines(st)
+	// Close the connection now, as mongo is about to be stopped.
+	st.Close()
+	if err != nil {
+		return errors.Annotate(err, "unable to get addresses for state servers")
+	}
+
+	var failed []string
+	start := time.Now()
+	results := parallelCall(machines, finalizeScript)
+	addresses := make(map[string]string)
+	for _, m := range machines {
+		addresses[m.ID] = m.Address
+	}
+	for _, r := range results {
+		for _, line := range strings.Split(strings.TrimSpace(r.Stdout), "\n") {
+			if line != "" {
+				fmt.Fprintf(ctx.Stdout, "machine %s: %s\n", r.MachineID, line)
+			}
+		}
+		for _, changed := range finalizeChanges(r.Stdout) {
+			auditFileChange(start, addresses[r.MachineID], r.MachineID, changed, nil)
+		}
+		if r.Error != nil || r.Code != 0 {
+			logger.Warningf("machine: %s rc: %d error: %v\nstderr:%s", r.MachineID, r.Code, r.Error, r.Stderr)
+			failed = append(failed, r.MachineID)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("finalize failed on machines: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// getStateServerMachines returns the machines in the environment that
+// run a 1.25 state server.
+func getStateServerMachines(st *state.State) ([]FlatMachine, error) {
+	machines, err := st.AllMachines()
+	if err != nil {
+		return nil, errors.Annotate(err, "getting 1.25 machines")
+	}
+	var ids []string
+	for _, m := range machines {
+		if m.IsManager() {
+			ids = append(ids, m.Id())
+		}
+	}
+	all, err := getMachines(st)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return selectMachines(all, ids)
+}
+
+// finalizeChangePattern matches the lines of finalizeScript's output
+// that report files it changed.
+var finalizeChangePattern = regexp.MustCompile(`^(?:archived|moved) (\S+) to (\S+)$|^wrote (\S+)$`)
+
+// finalizeChanges returns the paths of the files changed on a machine,
+// from the output of finalizeScript: both the source and destination of
+// those archived or moved, and the upstart overrides written.
+func finalizeChanges(stdout string) []string {
+	var paths []string
+	for _, line := range strings.Split(stdout, "\n") {
+		match := finalizeChangePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		for _, path := range match[1:] {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// finalizeScript stops the 1.25 state server on a machine. A machine
+// agent whose config was converted (and so has the 1.25 config kept
+// alongside) is running 2.x and hosting units, so it is kept.
+const finalizeScript = `
+set -u
+backup=/var/lib/juju/1.25-upgrade
+mkdir -p $backup || exit 1
+stop_service() {
+	if [ -d /run/systemd/system ]; then
+		systemctl is-enabled --quiet "$1" 2>/dev/null || return 0
+		systemctl stop "$1" && systemctl disable "$1" || return 1
+	else
+		[ -e /etc/init/$1.conf ] || return 0
+		stop "$1" >/dev/null 2>&1
+		echo manual > /etc/init/$1.override || return 1
+		echo "wrote /etc/init/$1.override"
+	fi
+	echo "stopped and disabled $1"
+}
+for agent in /var/lib/juju/agents/machine-*
+do
+	name=jujud-$(basename $agent)
+	if [ -e $agent/agent.conf.1.25 ]; then
+		echo "kept $name, converted to 2.x"
+	else
+		stop_service $name || exit 1
+	fi
+done
+stop_service juju-db || exit 1
+if [ -d /var/lib/juju/db ]; then
+	archive=$backup/db-$(date +%Y%m%d%H%M%S).tar.gz
+	tar czf $archive -C /var/lib/juju db || exit 1
+	rm -rf /var/lib/juju/db
+	echo "archived /var/lib/juju/db to $archive"
+fi
+for f in server.pem shared-secret
+do
+	[ -e /var/lib/juju/$f ] || continue
+	mv /var/lib/juju/$f $backup/$f || exit 1
+	echo "moved /var/lib/juju/$f to $backup/$f"
+done
+`
@@ -0,0 +1,31 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type finalizeSuite struct{}
+
+var _ = gc.Suite(&finalizeSuite{})
+
+func (*finalizeSuite) TestFinalizeChanges(c *gc.C) {
+	stdout := `
+kept jujud-machine-0, converted to 2.x
+wrote /etc/init/juju-db.override
+stopped and disabled juju-db
+archived /var/lib/juju/db to /var/lib/juju/1.25-upgrade/db-20170601120000.tar.gz
+moved /var/lib/juju/server.pem to /var/lib/juju/1.25-upgrade/server.pem
+`
+	c.Assert(finalizeChanges(stdout), jc.DeepEquals, []string{
+		"/etc/init/juju-db.override",
+		"/var/lib/juju/db",
+		"/var/lib/juju/1.25-upgrade/db-20170601120000.tar.gz",
+		"/var/lib/juju/server.pem",
+		"/var/lib/juju/1.25-upgrade/server.pem",
+	})
+	c.Assert(finalizeChanges("stopped and disabled juju-db\n"), gc.HasLen, 0)
+}
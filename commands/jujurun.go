@@ -0,0 +1,181 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju2/cmd/output"
+)
+
+var checkJujuRunDoc = `
+The purpose of the check-juju-run command is to check that juju run will work
+on the machines of the migrated environment once their agents are running
+2.x.
+
+On every machine, the juju-run helper must link to the machine agent's jujud,
+and running a no-op command in each unit through juju-run (and so through the
+unit agent's run listener) must succeed. Each check is listed, and the command
+fails if any machine failed one.
+
+`
+
+func newCheckJujuRunCommand() cmd.Command {
+	command := &checkJujuRunCommand{}
+	command.remoteCommand = "check-juju-run-impl"
+	return command
+}
+
+type checkJujuRunCommand struct {
+	baseClientCommand
+}
+
+func (c *checkJujuRunCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "check-juju-run",
+		Args:    "<environment name>",
+		Purpose: "check that juju-run works on every machine",
+		Doc:     checkJujuRunDoc,
+	}
+}
+
+func (c *checkJujuRunCommand) Init(args []string) error {
+	args, err := c.baseClientCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cmd.CheckEmpty(args)
+}
+
+var checkJujuRunImplDoc = `
+
+check-juju-run-impl must be executed on an API server machine of a 1.25
+environment.
+
+The command will get a list of all the machines, and their addresses, and then
+ssh to all the machines to check juju-run on them.
+
+`
+
+func newCheckJujuRunImplCommand() cmd.Command {
+	return &checkJujuRunImplCommand{}
+}
+
+type checkJujuRunImplCommand struct {
+	baseRemoteCommand
+}
+
+func (c *checkJujuRunImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "check-juju-run-impl",
+		Purpose: "controller aspect of check-juju-run",
+		Doc:     checkJujuRunImplDoc,
+	}
+}
+
+func (c *checkJujuRunImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	defer st.Close()
+
+	machines, err := getMachines(st)
+	if err != nil {
+		return errors.Annotate(err, "unable to get addresses for machines")
+	}
+
+	checks := parseJujuRunChecks(parallelCall(machines, checkJujuRunScript))
+	writer := output.TabWriter(ctx.Stdout)
+	wrapper := output.Wrapper{writer}
+	wrapper.Println("MACHINE", "CHECK", "RESULT")
+	var failed []string
+	for _, check := range checks {
+		wrapper.Println(check.machine, check.check, check.result)
+		if check.result != "ok" && (len(failed) == 0 || failed[len(failed)-1] != check.machine) {
+			failed = append(failed, check.machine)
+		}
+	}
+	writer.Flush()
+	if len(failed) > 0 {
+		return errors.Errorf("juju-run failed on machines: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// checkJujuRunScript prints a "<check> <result>" line for the juju-run
+// link and for each unit on the machine. Unit names are recovered from
+// the agent directory names: unit-mysql-db-0 is mysql-db/0.
+const checkJujuRunScript = `
+set -u
+target=$(readlink /usr/bin/juju-run)
+machine=$(ls -d /var/lib/juju/agents/machine-* | head -1)
+if [ "$target" = "/var/lib/juju/tools/$(basename $machine)/jujud" ]; then
+	echo "juju-run-link ok"
+else
+	echo "juju-run-link points to '$target'"
+fi
+for agent in /var/lib/juju/agents/unit-*
+do
+	[ -d "$agent" ] || continue
+	unit=$(basename $agent)
+	unit=${unit#unit-}
+	unit="${unit%-*}/${unit##*-}"
+	if out=$(juju-run $unit true 2>&1); then
+		echo "$unit ok"
+	else
+		echo "$unit $(echo "$out" | tail -1)"
+	fi
+done
+`
+
+type jujuRunCheck struct {
+	machine string
+	check   string
+	result  string
+}
+
+// parseJujuRunChecks turns the output of checkJujuRunScript on each
+// machine into a list of checks, sorted by machine. A machine that
+// couldn't be reached, or whose script failed, is reported as such.
+func parseJujuRunChecks(results []DistResult) []jujuRunCheck {
+	var checks []jujuRunCheck
+	for _, r := range results {
+		if r.Error != nil || r.Code != 0 {
+			result := r.Stderr
+			if r.Error != nil {
+				result = r.Error.Error()
+			}
+			checks = append(checks, jujuRunCheck{
+				machine: r.MachineID,
+				check:   "ssh",
+				result:  strings.TrimSpace(result),
+			})
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSpace(r.Stdout), "\n") {
+			if line == "" {
+				continue
+			}
+			parts := strings.SplitN(line, " ", 2)
+			check := jujuRunCheck{machine: r.MachineID, check: parts[0]}
+			if len(parts) == 2 {
+				check.result = parts[1]
+			}
+			checks = append(checks, check)
+		}
+	}
+	sort.Stable(jujuRunCheckList(checks))
+	return checks
+}
+
+type jujuRunCheckList []jujuRunCheck
+
+func (l jujuRunCheckList) Len() int           { return len(l) }
+func (l jujuRunCheckList) Less(i, j int) bool { return l[i].machine < l[j].machine }
+func (l jujuRunCheckList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
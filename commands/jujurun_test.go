@@ -0,0 +1,39 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type jujuRunSuite struct{}
+
+var _ = gc.Suite(&jujuRunSuite{})
+
+func (*jujuRunSuite) TestParseJujuRunChecks(c *gc.C) {
+	checks := parseJujuRunChecks([]DistResult{{
+		MachineID: "2",
+		Error:     errors.New("connection refused"),
+	}, {
+		MachineID: "1",
+		Stdout:    "juju-run-link points to '/var/lib/juju/tools/1.25.10-trusty-amd64/jujud'\nmysql-db/0 error: dial unix: no such file\n",
+	}, {
+		MachineID: "0",
+		Stdout:    "juju-run-link ok\nwordpress/0 ok\n",
+	}, {
+		MachineID: "3",
+		Code:      1,
+		Stderr:    "sudo: a password is required\n",
+	}})
+	c.Assert(checks, jc.DeepEquals, []jujuRunCheck{
+		{machine: "0", check: "juju-run-link", result: "ok"},
+		{machine: "0", check: "wordpress/0", result: "ok"},
+		{machine: "1", check: "juju-run-link", result: "points to '/var/lib/juju/tools/1.25.10-trusty-amd64/jujud'"},
+		{machine: "1", check: "mysql-db/0", result: "error: dial unix: no such file"},
+		{machine: "2", check: "ssh", result: "connection refused"},
+		{machine: "3", check: "ssh", result: "sudo: a password is required"},
+	})
+}
@@ -0,0 +1,392 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju1/network"
+	"github.com/juju/1.25-upgrade/juju1/state"
+	"github.com/juju/1.25-upgrade/juju2/cmd/output"
+)
+
+const (
+	// addressCacheFile is where the addresses found to be reachable
+	// are kept on the state server, in remotePluginDir, so that later
+	// phases try them first.
+	addressCacheFile = "1.25-upgrade-addresses.json"
+
+	addressProbeTimeout = 3 * time.Second
+)
+
+// probeAddress checks that something is listening on the given port of
+// the address. It's a variable so tests can fake the network.
+var probeAddress = func(address string, port int) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, strconv.Itoa(port)), addressProbeTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// reachability says whether a machine can be reached over SSH.
+type reachability string
+
+const (
+	// reachable machines either answered on an address, or are
+	// reached through another machine and so weren't probed.
+	reachable   reachability = "reachable"
+	unreachable reachability = "unreachable"
+	noAddress   reachability = "no-address"
+)
+
+// addressChoice is the address picked for a machine, and why.
+type addressChoice struct {
+	Address      string
+	Reason       string
+	Reachability reachability
+}
+
+// preferredNetwork is a network whose addresses are preferred, with a
+// description of why for the reports.
+type preferredNetwork struct {
+	*net.IPNet
+	description string
+}
+
+// preferredNetworks returns the networks in the SSH config's preferred
+// CIDRs and preferred space, in that order.
+func preferredNetworks(st *state.State, cfg sshConfig) ([]preferredNetwork, error) {
+	var result []preferredNetwork
+	for _, cidr := range cfg.PreferredCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.NotValidf("preferred CIDR %q", cidr)
+		}
+		result = append(result, preferredNetwork{ipNet, "preferred CIDR " + cidr})
+	}
+	if cfg.PreferredSpace == "" {
+		return result, nil
+	}
+	space, err := st.Space(cfg.PreferredSpace)
+	if err != nil {
+		return nil, errors.Annotatef(err, "getting preferred space")
+	}
+	subnets, err := space.Subnets()
+	if err != nil {
+		return nil, errors.Annotatef(err, "getting subnets of space %q", cfg.PreferredSpace)
+	}
+	for _, subnet := range subnets {
+		_, ipNet, err := net.ParseCIDR(subnet.CIDR())
+		if err != nil {
+			return nil, errors.Annotatef(err, "parsing subnet %q", subnet.CIDR())
+		}
+		description := fmt.Sprintf("in space %s (%s)", cfg.PreferredSpace, subnet.CIDR())
+		result = append(result, preferredNetwork{ipNet, description})
+	}
+	return result, nil
+}
+
+// addressCandidate is an address a machine might be reached on.
+type addressCandidate struct {
+	network.Address
+	rank        int
+	description string
+}
+
+// addressCandidates returns the usable addresses among the given ones,
+// without duplicates and in order of preference: the cached address,
+// those in preferred networks, then cloud-local, public and others.
+func addressCandidates(addresses []network.Address, cached string, preferred []preferredNetwork) []addressCandidate {
+	var candidates []addressCandidate
+	seen := make(map[string]bool)
+	for _, addr := range addresses {
+		if seen[addr.Value] {
+			continue
+		}
+		seen[addr.Value] = true
+		switch addr.Scope {
+		case network.ScopeMachineLocal, network.ScopeLinkLocal:
+			continue
+		}
+		candidate := addressCandidate{Address: addr}
+		ip := net.ParseIP(addr.Value)
+		switch {
+		case addr.Value == cached:
+			candidate.description = "used by an earlier run"
+		case ip != nil && preferredIndex(ip, preferred) >= 0:
+			i := preferredIndex(ip, preferred)
+			candidate.rank = 1 + i
+			candidate.description = preferred[i].description
+		default:
+			candidate.rank = 1 + len(preferred) + scopeRank(addr.Scope)
+			if addr.Scope != network.ScopeUnknown {
+				candidate.description = string(addr.Scope) + " address"
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.Stable(addressCandidateList(candidates))
+	return candidates
+}
+
+func preferredIndex(ip net.IP, preferred []preferredNetwork) int {
+	for i, n := range preferred {
+		if n.Contains(ip) {
+			return i
+		}
+	}
+	return -1
+}
+
+func scopeRank(scope network.Scope) int {
+	switch scope {
+	case network.ScopeCloudLocal:
+		return 0
+	case network.ScopePublic:
+		return 1
+	default:
+		return 2
+	}
+}
+
+type addressCandidateList []addressCandidate
+
+func (l addressCandidateList) Len() int           { return len(l) }
+func (l addressCandidateList) Less(i, j int) bool { return l[i].rank < l[j].rank }
+func (l addressCandidateList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// chooseAddress picks the first of the candidates that answers probe,
+// probing them all at once. If none does, the first candidate is
+// picked so that the failure shows up when connecting to it.
+func chooseAddress(candidates []addressCandidate, probe func(string) error) addressChoice {
+	errs := make([]error, len(candidates))
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			errs[i] = probe(address)
+		}(i, candidate.Value)
+	}
+	wg.Wait()
+	var failures []string
+	for i, candidate := range candidates {
+		if errs[i] != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", candidate.Value, errs[i]))
+			continue
+		}
+		reason := "reachable"
+		if candidate.description != "" {
+			reason += ", " + candidate.description
+		}
+		if len(failures) > 0 {
+			reason += "; unreachable " + strings.Join(failures, "; ")
+		}
+		return addressChoice{Address: candidate.Value, Reason: reason, Reachability: reachable}
+	}
+	return addressChoice{
+		Address:      candidates[0].Value,
+		Reason:       "no address reachable: " + strings.Join(failures, "; "),
+		Reachability: unreachable,
+	}
+}
+
+// selectAddresses picks the address to reach each machine on. The
+// addresses of machines the state server connects to directly are
+// probed on the SSH port; the others can only be probed from the hop
+// before them, so the most preferred is used. Machines with no usable
+// address are reported as such rather than failing the selection.
+func selectAddresses(st *state.State, machines []*state.Machine, cfg sshConfig) (map[string]addressChoice, error) {
+	preferred, err := preferredNetworks(st, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 22
+	}
+	probe := func(address string) error {
+		return probeAddress(address, port)
+	}
+	cache := readAddressCache()
+
+	candidates := make(map[string][]addressCandidate)
+	for _, m := range machines {
+		addresses := append(m.MachineAddresses(), m.ProviderAddresses()...)
+		candidates[m.Id()] = addressCandidates(addresses, cache[m.Id()], preferred)
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		choices = make(map[string]addressChoice)
+	)
+	for _, m := range machines {
+		id := m.Id()
+		if len(candidates[id]) == 0 {
+			mu.Lock()
+			choices[id] = addressChoice{Reason: "no usable address", Reachability: noAddress}
+			mu.Unlock()
+			continue
+		}
+		parentID, _ := m.ParentId()
+		if !cfg.reachedDirectly(id, parentID) {
+			first := candidates[id][0]
+			reason := "not probed, reached through another machine"
+			if first.description != "" {
+				reason += ", " + first.description
+			}
+			mu.Lock()
+			choices[id] = addressChoice{Address: first.Value, Reason: reason, Reachability: reachable}
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			choice := chooseAddress(candidates[id], probe)
+			mu.Lock()
+			defer mu.Unlock()
+			choices[id] = choice
+			if choice.Reachability == reachable {
+				cache[id] = choice.Address
+			}
+		}(id)
+	}
+	wg.Wait()
+	writeAddressCache(cache)
+	for _, m := range machines {
+		logger.Debugf("machine %s: using %s (%s)", m.Id(), choices[m.Id()].Address, choices[m.Id()].Reason)
+	}
+	return choices, nil
+}
+
+// readAddressCache reads the addresses cached by earlier runs, keyed by
+// machine id. The cache is only a hint, so problems reading it are
+// logged and ignored.
+func readAddressCache() map[string]string {
+	cache := make(map[string]string)
+	data, err := ioutil.ReadFile(addressCacheFile)
+	if os.IsNotExist(err) {
+		return cache
+	} else if err != nil {
+		logger.Warningf("reading address cache: %v", err)
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		logger.Warningf("parsing address cache: %v", err)
+		return make(map[string]string)
+	}
+	return cache
+}
+
+func writeAddressCache(cache map[string]string) {
+	data, err := json.Marshal(cache)
+	if err == nil {
+		err = ioutil.WriteFile(addressCacheFile, data, 0600)
+	}
+	if err != nil {
+		logger.Warningf("writing address cache: %v", err)
+	}
+}
+
+var machineAddressesDoc = `
+The purpose of the machine-addresses command is to show the address that the
+state server uses to reach each machine of a 1.25 environment, and why it was
+picked.
+
+Every address known for a machine is checked for a listening SSH port from
+the state server, preferring the address used by an earlier run, then those
+in the preferred-cidrs and preferred-space of the environment's ssh.yaml, then
+cloud-local and public addresses. Machines reached through a bastion or their
+host machine aren't checked.
+
+`
+
+func newMachineAddressesCommand() cmd.Command {
+	command := &machineAddressesCommand{}
+	command.remoteCommand = "machine-addresses-impl"
+	return command
+}
+
+type machineAddressesCommand struct {
+	baseClientCommand
+}
+
+func (c *machineAddressesCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "machine-addresses",
+		Args:    "<environment name>",
+		Purpose: "show the address used to reach each machine",
+		Doc:     machineAddressesDoc,
+	}
+}
+
+func (c *machineAddressesCommand) Init(args []string) error {
+	args, err := c.baseClientCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cmd.CheckEmpty(args)
+}
+
+var machineAddressesImplDoc = `
+
+machine-addresses-impl must be executed on an API server machine of a 1.25
+environment.
+
+The command picks the address to reach each machine on, and lists them with
+the reason they were picked.
+
+`
+
+func newMachineAddressesImplCommand() cmd.Command {
+	return &machineAddressesImplCommand{}
+}
+
+type machineAddressesImplCommand struct {
+	baseRemoteCommand
+}
+
+func (c *machineAddressesImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "machine-addresses-impl",
+		Purpose: "controller aspect of machine-addresses",
+		Doc:     machineAddressesImplDoc,
+	}
+}
+
+func (c *machineAddressesImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	defer st.Close()
+
+	machines, err := getMachines(st)
+	if err != nil {
+		return errors.Annotate(err, "unable to get addresses for machines")
+	}
+	writer := output.TabWriter(ctx.Stdout)
+	wrapper := output.Wrapper{writer}
+	wrapper.Println("MACHINE", "ADDRESS", "REACHABILITY", "REASON")
+	for _, m := range machines {
+		wrapper.Println(m.ID, m.Address, m.Reachability, m.AddressReason)
+	}
+	writer.Flush()
+	return nil
+}
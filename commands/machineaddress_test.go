@@ -0,0 +1,78 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"net"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/1.25-upgrade/juju1/network"
+)
+
+type machineAddressSuite struct{}
+
+var _ = gc.Suite(&machineAddressSuite{})
+
+var testAddresses = []network.Address{
+	{Value: "54.0.0.1", Scope: network.ScopePublic},
+	{Value: "127.0.0.1", Scope: network.ScopeMachineLocal},
+	{Value: "10.0.0.1", Scope: network.ScopeCloudLocal},
+	{Value: "10.20.0.1", Scope: network.ScopeCloudLocal},
+	{Value: "fe80::1", Scope: network.ScopeLinkLocal},
+	{Value: "54.0.0.1", Scope: network.ScopePublic},
+}
+
+func candidateValues(candidates []addressCandidate) []string {
+	var values []string
+	for _, candidate := range candidates {
+		values = append(values, candidate.Value)
+	}
+	return values
+}
+
+func (*machineAddressSuite) TestAddressCandidatesScopeOrder(c *gc.C) {
+	candidates := addressCandidates(testAddresses, "", nil)
+	c.Assert(candidateValues(candidates), jc.DeepEquals, []string{"10.0.0.1", "10.20.0.1", "54.0.0.1"})
+	c.Assert(candidates[0].description, gc.Equals, "local-cloud address")
+}
+
+func (*machineAddressSuite) TestAddressCandidatesPreferred(c *gc.C) {
+	_, ipNet, err := net.ParseCIDR("10.20.0.0/16")
+	c.Assert(err, jc.ErrorIsNil)
+	preferred := []preferredNetwork{{ipNet, "preferred CIDR 10.20.0.0/16"}}
+	candidates := addressCandidates(testAddresses, "54.0.0.1", preferred)
+	c.Assert(candidateValues(candidates), jc.DeepEquals, []string{"54.0.0.1", "10.20.0.1", "10.0.0.1"})
+	c.Assert(candidates[0].description, gc.Equals, "used by an earlier run")
+	c.Assert(candidates[1].description, gc.Equals, "preferred CIDR 10.20.0.0/16")
+}
+
+func (*machineAddressSuite) TestChooseAddress(c *gc.C) {
+	candidates := addressCandidates(testAddresses, "", nil)
+	choice := chooseAddress(candidates, func(address string) error {
+		if address == "10.0.0.1" {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	c.Assert(choice, jc.DeepEquals, addressChoice{
+		Address:      "10.20.0.1",
+		Reason:       "reachable, local-cloud address; unreachable 10.0.0.1: connection refused",
+		Reachability: reachable,
+	})
+}
+
+func (*machineAddressSuite) TestChooseAddressNoneReachable(c *gc.C) {
+	candidates := addressCandidates(testAddresses[:1], "", nil)
+	choice := chooseAddress(candidates, func(string) error {
+		return errors.New("i/o timeout")
+	})
+	c.Assert(choice, jc.DeepEquals, addressChoice{
+		Address:      "54.0.0.1",
+		Reason:       "no address reachable: 54.0.0.1: i/o timeout",
+		Reachability: unreachable,
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"strings"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"github.com/juju/utils/set"
+	"gopkg.in/juju/names.v2"
+)
+
+// machineFilter restricts the agent commands to some of the machines
+// of an environment. A machine selects itself and the containers on
+// it, and an application selects the machines hosting its units.
+type machineFilter struct {
+	machines     []string
+	applications []string
+	exclude      []string
+}
+
+func (f *machineFilter) setFlags(fs *gnuflag.FlagSet) {
+	fs.Var(cmd.NewStringsValue(nil, &f.machines), "machines", "only act on these machines and their containers")
+	fs.Var(cmd.NewStringsValue(nil, &f.applications), "applications", "only act on the machines hosting these applications")
+	fs.Var(cmd.NewStringsValue(nil, &f.exclude), "exclude", "don't act on these machines (and their containers) or applications")
+}
+
+func (f *machineFilter) enabled() bool {
+	return len(f.machines) > 0 || len(f.applications) > 0 || len(f.exclude) > 0
+}
+
+func (f *machineFilter) validate() error {
+	for _, id := range f.machines {
+		if !names.IsValidMachine(id) {
+			return errors.NotValidf("machine %q", id)
+		}
+	}
+	for _, name := range f.applications {
+		if !names.IsValidApplication(name) {
+			return errors.NotValidf("application %q", name)
+		}
+	}
+	for _, s := range f.exclude {
+		if !names.IsValidMachine(s) && !names.IsValidApplication(s) {
+			return errors.NotValidf("machine or application %q", s)
+		}
+	}
+	return nil
+}
+
+// apply returns the machines selected by the filter, in their original
+// order.
+func (f *machineFilter) apply(machines []FlatMachine) ([]FlatMachine, error) {
+	known := set.NewStrings()
+	hosted := set.NewStrings()
+	for _, m := range machines {
+		known.Add(m.ID)
+		hosted = hosted.Union(set.NewStrings(m.Applications...))
+	}
+	for _, id := range f.machines {
+		if !known.Contains(id) {
+			return nil, errors.NotFoundf("machine %q", id)
+		}
+	}
+	for _, name := range f.applications {
+		if !hosted.Contains(name) {
+			return nil, errors.NotFoundf("units of application %q", name)
+		}
+	}
+	include := len(f.machines) == 0 && len(f.applications) == 0
+	var result []FlatMachine
+	for _, m := range machines {
+		selected := include ||
+			onMachines(m, f.machines) ||
+			hostsApplication(m, f.applications)
+		excluded := onMachines(m, f.exclude) || hostsApplication(m, f.exclude)
+		if selected && !excluded {
+			result = append(result, m)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no machines selected")
+	}
+	return result, nil
+}
+
+// onMachines returns whether the machine is one of ids, or a container
+// on one of them (at any depth).
+func onMachines(m FlatMachine, ids []string) bool {
+	for _, id := range ids {
+		if m.ID == id || strings.HasPrefix(m.ID, id+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hostsApplication returns whether the machine hosts a unit of one of
+// the applications.
+func hostsApplication(m FlatMachine, applications []string) bool {
+	hosted := set.NewStrings(m.Applications...)
+	for _, name := range applications {
+		if hosted.Contains(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// setFilterFlags adds the machine filter flags to an agent command.
+func (c *baseClientCommand) setFilterFlags(f *gnuflag.FlagSet) {
+	c.filter.setFlags(f)
+}
+
+// selectedMachines lists the machines in the environment that the
+// command acts on: those selected by its filter, less the unreachable
+// ones if it checks for them.
+func (c *baseClientCommand) selectedMachines(ctx *cmd.Context) ([]FlatMachine, error) {
+	machines, err := c.listMachines(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if c.filter.enabled() {
+		if machines, err = c.filter.apply(machines); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if c.checkReachable {
+		if machines, err = c.skipUnreachable(ctx, machines); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return machines, nil
+}
+
+// selectedMachineIDs returns the ids of the selected machines.
+func (c *baseClientCommand) selectedMachineIDs(ctx *cmd.Context) ([]string, error) {
+	machines, err := c.selectedMachines(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ids := make([]string, len(machines))
+	for i, m := range machines {
+		ids[i] = m.ID
+	}
+	return ids, nil
+}
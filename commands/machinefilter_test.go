@@ -0,0 +1,72 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type machineFilterSuite struct{}
+
+var _ = gc.Suite(&machineFilterSuite{})
+
+var filterMachines = []FlatMachine{
+	{ID: "0"},
+	{ID: "1", Applications: []string{"mysql"}},
+	{ID: "1/lxc/0", ParentID: "1", Applications: []string{"wordpress"}},
+	{ID: "1/lxc/0/kvm/0", ParentID: "1/lxc/0"},
+	{ID: "10", Applications: []string{"wordpress"}},
+}
+
+func filteredIDs(c *gc.C, f machineFilter) []string {
+	machines, err := f.apply(filterMachines)
+	c.Assert(err, jc.ErrorIsNil)
+	var ids []string
+	for _, m := range machines {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func (*machineFilterSuite) TestMachinesIncludeContainers(c *gc.C) {
+	ids := filteredIDs(c, machineFilter{machines: []string{"1"}})
+	c.Assert(ids, jc.DeepEquals, []string{"1", "1/lxc/0", "1/lxc/0/kvm/0"})
+}
+
+func (*machineFilterSuite) TestContainer(c *gc.C) {
+	ids := filteredIDs(c, machineFilter{machines: []string{"1/lxc/0"}})
+	c.Assert(ids, jc.DeepEquals, []string{"1/lxc/0", "1/lxc/0/kvm/0"})
+}
+
+func (*machineFilterSuite) TestApplications(c *gc.C) {
+	ids := filteredIDs(c, machineFilter{applications: []string{"wordpress"}})
+	c.Assert(ids, jc.DeepEquals, []string{"1/lxc/0", "10"})
+}
+
+func (*machineFilterSuite) TestExclude(c *gc.C) {
+	ids := filteredIDs(c, machineFilter{
+		machines: []string{"0", "1"},
+		exclude:  []string{"1/lxc/0"},
+	})
+	c.Assert(ids, jc.DeepEquals, []string{"0", "1"})
+	ids = filteredIDs(c, machineFilter{exclude: []string{"mysql", "0"}})
+	c.Assert(ids, jc.DeepEquals, []string{"1/lxc/0", "1/lxc/0/kvm/0", "10"})
+}
+
+func (*machineFilterSuite) TestUnknown(c *gc.C) {
+	_, err := (&machineFilter{machines: []string{"2"}}).apply(filterMachines)
+	c.Assert(err, gc.ErrorMatches, `machine "2" not found`)
+	_, err = (&machineFilter{applications: []string{"haproxy"}}).apply(filterMachines)
+	c.Assert(err, gc.ErrorMatches, `units of application "haproxy" not found`)
+	_, err = (&machineFilter{exclude: []string{"0", "1", "10"}}).apply(filterMachines)
+	c.Assert(err, gc.ErrorMatches, "no machines selected")
+}
+
+func (*machineFilterSuite) TestValidate(c *gc.C) {
+	f := machineFilter{machines: []string{"1/lxc/x"}}
+	c.Assert(f.validate(), gc.ErrorMatches, `machine "1/lxc/x" not valid`)
+	f = machineFilter{exclude: []string{"-"}}
+	c.Assert(f.validate(), gc.ErrorMatches, `machine or application "-" not valid`)
+}
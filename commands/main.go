@@ -17,8 +17,11 @@ const (
 )
 
 var (
-	logger          = loggo.GetLogger("upgrader")
-	upgraderVersion = version.MustParse("0.1.0")
+	logger = loggo.GetLogger("upgrader")
+
+	// upgraderVersion is checked against the plugin on the state
+	// server, so it must change whenever the remote commands do.
+	upgraderVersion = version.MustParse("0.3.0")
 )
 
 // NewUpgradeCommand returns the supercommand for the various upgrade
@@ -30,7 +33,8 @@ func NewUpgradeCommand(ctx *cmd.Context) cmd.Command {
 		Log: &cmd.Log{
 			DefaultConfig: os.Getenv("JUJU_LOGGING_CONFIG"),
 		},
-		Version: upgraderVersion.String(),
+		Version:   upgraderVersion.String(),
+		NotifyRun: startAuditPhase,
 	})
 	registerCommands(upgrader)
 	return upgrader
@@ -39,14 +43,35 @@ func NewUpgradeCommand(ctx *cmd.Context) cmd.Command {
 func registerCommands(super *cmd.SuperCommand) {
 	super.Register(newVerifySourceCommand())
 	super.Register(newVerifySourceImplCommand())
+	super.Register(newVerifyTargetCommand())
 	super.Register(newDumpSourceDBCommand())
 	super.Register(newDumpSourceDBImplCommand())
+	super.Register(newExportBackupCommand())
+	super.Register(newFinalizeCommand())
+	super.Register(newFinalizeImplCommand())
+	super.Register(newBackupImplCommand())
+	super.Register(newListMachinesImplCommand())
+	super.Register(newStateServersImplCommand())
+	super.Register(newMachineAddressesCommand())
+	super.Register(newMachineAddressesImplCommand())
+	super.Register(newAgentConfigConvertImplCommand())
 	super.Register(newAgentStatusCommand())
 	super.Register(newAgentStatusImplCommand())
+	super.Register(newCheckJujuRunCommand())
+	super.Register(newCheckJujuRunImplCommand())
+	super.Register(newCleanupRsyslogCommand())
+	super.Register(newCleanupRsyslogImplCommand())
+	super.Register(newRetagInstancesCommand())
+	super.Register(newRetagInstancesImplCommand())
+	super.Register(newMigrateSecurityGroupsCommand())
+	super.Register(newMigrateSecurityGroupsImplCommand())
 	super.Register(newStartAgentsCommand())
 	super.Register(newStartAgentsImplCommand())
 	super.Register(newStopAgentsCommand())
 	super.Register(newStopAgentsImplCommand())
 	super.Register(newUpgradeAgentsCommand())
 	super.Register(newUpgradeAgentsImplCommand())
+	super.Register(newRetryCommand())
+	super.Register(newRunCommand())
+	super.Register(newAuditCommand())
 }
@@ -0,0 +1,190 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/juju/description"
+)
+
+// modelValues flattens the parts of a model description that must
+// survive the migration into a map of path to value. Anything that is
+// expected to change as part of the migration (status and its history,
+// agent tools, passwords, addresses, sequences and the model config
+// other than the name) is deliberately left out, and all collections
+// are keyed by name or id so that ordering doesn't matter.
+func modelValues(model description.Model) map[string]string {
+	values := make(map[string]string)
+	values["model/name"] = fmt.Sprint(model.Config()["name"])
+	addConstraints(values, "model", model.Constraints())
+	addAnnotations(values, "model", model.Annotations())
+
+	var addMachine func(m description.Machine)
+	addMachine = func(m description.Machine) {
+		prefix := "machine " + m.Id()
+		values[prefix+"/series"] = m.Series()
+		values[prefix+"/container-type"] = m.ContainerType()
+		values[prefix+"/jobs"] = sortedJoin(m.Jobs())
+		if instance := m.Instance(); instance != nil {
+			values[prefix+"/instance-id"] = instance.InstanceId()
+		}
+		addConstraints(values, prefix, m.Constraints())
+		addAnnotations(values, prefix, m.Annotations())
+		for _, container := range m.Containers() {
+			addMachine(container)
+		}
+	}
+	for _, m := range model.Machines() {
+		addMachine(m)
+	}
+
+	for _, app := range model.Applications() {
+		prefix := "application " + app.Name()
+		values[prefix+"/charm-url"] = app.CharmURL()
+		values[prefix+"/series"] = app.Series()
+		values[prefix+"/subordinate"] = fmt.Sprint(app.Subordinate())
+		values[prefix+"/exposed"] = fmt.Sprint(app.Exposed())
+		values[prefix+"/min-units"] = fmt.Sprint(app.MinUnits())
+		addSettings(values, prefix+"/settings", app.Settings())
+		addSettings(values, prefix+"/leadership-settings", app.LeadershipSettings())
+		addConstraints(values, prefix, app.Constraints())
+		addAnnotations(values, prefix, app.Annotations())
+
+		for _, unit := range app.Units() {
+			prefix := "unit " + unit.Name()
+			values[prefix+"/machine"] = unit.Machine().Id()
+			values[prefix+"/principal"] = unit.Principal().Id()
+			var subordinates []string
+			for _, sub := range unit.Subordinates() {
+				subordinates = append(subordinates, sub.Id())
+			}
+			values[prefix+"/subordinates"] = sortedJoin(subordinates)
+			addConstraints(values, prefix, unit.Constraints())
+			addAnnotations(values, prefix, unit.Annotations())
+		}
+	}
+
+	for _, rel := range model.Relations() {
+		prefix := "relation " + rel.Key()
+		for _, ep := range rel.Endpoints() {
+			prefix := fmt.Sprintf("%s/endpoint %s:%s", prefix, ep.ApplicationName(), ep.Name())
+			values[prefix] = fmt.Sprintf("%s %s %s", ep.Role(), ep.Interface(), ep.Scope())
+		}
+		// Unit settings are only reachable through the endpoints, so
+		// walk the units of each application on the relation.
+		for _, ep := range rel.Endpoints() {
+			for _, unit := range applicationUnits(model, ep.ApplicationName()) {
+				if settings := ep.Settings(unit); len(settings) > 0 {
+					addSettings(values, fmt.Sprintf("%s/settings %s", prefix, unit), settings)
+				}
+			}
+		}
+	}
+
+	for _, storage := range model.Storages() {
+		prefix := "storage " + storage.Tag().Id()
+		values[prefix+"/kind"] = storage.Kind()
+		values[prefix+"/name"] = storage.Name()
+		if owner, err := storage.Owner(); err == nil && owner != nil {
+			values[prefix+"/owner"] = owner.String()
+		}
+		var attachments []string
+		for _, unit := range storage.Attachments() {
+			attachments = append(attachments, unit.Id())
+		}
+		values[prefix+"/attachments"] = sortedJoin(attachments)
+	}
+	for _, volume := range model.Volumes() {
+		prefix := "volume " + volume.Tag().Id()
+		values[prefix+"/storage"] = volume.Storage().Id()
+		values[prefix+"/provisioned"] = fmt.Sprint(volume.Provisioned())
+		values[prefix+"/size"] = fmt.Sprint(volume.Size())
+		values[prefix+"/pool"] = volume.Pool()
+		values[prefix+"/volume-id"] = volume.VolumeID()
+		values[prefix+"/persistent"] = fmt.Sprint(volume.Persistent())
+	}
+	for _, filesystem := range model.Filesystems() {
+		prefix := "filesystem " + filesystem.Tag().Id()
+		values[prefix+"/storage"] = filesystem.Storage().Id()
+		values[prefix+"/volume"] = filesystem.Volume().Id()
+		values[prefix+"/provisioned"] = fmt.Sprint(filesystem.Provisioned())
+		values[prefix+"/size"] = fmt.Sprint(filesystem.Size())
+		values[prefix+"/pool"] = filesystem.Pool()
+		values[prefix+"/filesystem-id"] = filesystem.FilesystemID()
+	}
+
+	return values
+}
+
+func applicationUnits(model description.Model, name string) []string {
+	var units []string
+	for _, app := range model.Applications() {
+		if app.Name() != name {
+			continue
+		}
+		for _, unit := range app.Units() {
+			units = append(units, unit.Name())
+		}
+	}
+	return units
+}
+
+func addConstraints(values map[string]string, prefix string, cons description.Constraints) {
+	if cons == nil {
+		return
+	}
+	prefix += "/constraints"
+	values[prefix+"/arch"] = cons.Architecture()
+	values[prefix+"/container"] = cons.Container()
+	values[prefix+"/cpu-cores"] = fmt.Sprint(cons.CpuCores())
+	values[prefix+"/cpu-power"] = fmt.Sprint(cons.CpuPower())
+	values[prefix+"/instance-type"] = cons.InstanceType()
+	values[prefix+"/mem"] = fmt.Sprint(cons.Memory())
+	values[prefix+"/root-disk"] = fmt.Sprint(cons.RootDisk())
+	values[prefix+"/spaces"] = sortedJoin(cons.Spaces())
+	values[prefix+"/tags"] = sortedJoin(cons.Tags())
+}
+
+func addAnnotations(values map[string]string, prefix string, annotations map[string]string) {
+	for key, value := range annotations {
+		values[prefix+"/annotations/"+key] = value
+	}
+}
+
+func addSettings(values map[string]string, prefix string, settings map[string]interface{}) {
+	for key, value := range settings {
+		values[prefix+"/"+key] = fmt.Sprint(value)
+	}
+}
+
+func sortedJoin(values []string) string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// diffValues returns a sorted, human readable description of every path
+// that is missing from, added to, or changed between source and target.
+func diffValues(source, target map[string]string) []string {
+	var diffs []string
+	for path, sourceValue := range source {
+		targetValue, found := target[path]
+		switch {
+		case !found:
+			diffs = append(diffs, fmt.Sprintf("%s: missing from target (source %q)", path, sourceValue))
+		case sourceValue != targetValue:
+			diffs = append(diffs, fmt.Sprintf("%s: source %q, target %q", path, sourceValue, targetValue))
+		}
+	}
+	for path, targetValue := range target {
+		if _, found := source[path]; !found {
+			diffs = append(diffs, fmt.Sprintf("%s: not in source (target %q)", path, targetValue))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"github.com/juju/description"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+)
+
+type modelDiffSuite struct{}
+
+var _ = gc.Suite(&modelDiffSuite{})
+
+func (*modelDiffSuite) TestSame(c *gc.C) {
+	values := map[string]string{
+		"machine 0/series":          "trusty",
+		"application mysql/exposed": "false",
+	}
+	c.Assert(diffValues(values, values), gc.HasLen, 0)
+}
+
+func (*modelDiffSuite) TestDifferences(c *gc.C) {
+	source := map[string]string{
+		"machine 0/series":             "trusty",
+		"machine 1/series":             "trusty",
+		"application mysql/settings/x": "1",
+	}
+	target := map[string]string{
+		"machine 0/series":             "xenial",
+		"application mysql/settings/x": "1",
+		"unit mysql/0/machine":         "0",
+	}
+	c.Assert(diffValues(source, target), jc.DeepEquals, []string{
+		`machine 0/series: source "trusty", target "xenial"`,
+		`machine 1/series: missing from target (source "trusty")`,
+		`unit mysql/0/machine: not in source (target "0")`,
+	})
+}
+
+func (*modelDiffSuite) TestSortedJoinIgnoresOrder(c *gc.C) {
+	c.Assert(sortedJoin([]string{"b", "a"}), gc.Equals, sortedJoin([]string{"a", "b"}))
+}
+
+// diffModelArgs holds what differs between the models made by
+// newDiffModel.
+type diffModelArgs struct {
+	name          string
+	defaultSeries string
+	password      string
+	series        string
+	settings      map[string]interface{}
+}
+
+func newDiffModel(args diffModelArgs) description.Model {
+	model := description.NewModel(description.ModelArgs{
+		Owner: names.NewUserTag("admin"),
+		Config: map[string]interface{}{
+			"name":           args.name,
+			"default-series": args.defaultSeries,
+		},
+	})
+	machine := model.AddMachine(description.MachineArgs{
+		Id:           names.NewMachineTag("0"),
+		Series:       "trusty",
+		Jobs:         []string{"host-units"},
+		PasswordHash: args.password,
+	})
+	machine.SetInstance(description.CloudInstanceArgs{InstanceId: "i-0"})
+	app := model.AddApplication(description.ApplicationArgs{
+		Tag:      names.NewApplicationTag("mysql"),
+		Series:   args.series,
+		CharmURL: "cs:trusty/mysql-1",
+		Settings: args.settings,
+	})
+	app.AddUnit(description.UnitArgs{
+		Tag:          names.NewUnitTag("mysql/0"),
+		Machine:      names.NewMachineTag("0"),
+		PasswordHash: args.password,
+	})
+	rel := model.AddRelation(description.RelationArgs{Id: 0, Key: "mysql:cluster"})
+	ep := rel.AddEndpoint(description.EndpointArgs{
+		ApplicationName: "mysql",
+		Name:            "cluster",
+		Role:            "peer",
+		Interface:       "mysql-ha",
+		Scope:           "global",
+	})
+	ep.SetUnitSettings("mysql/0", map[string]interface{}{"private-address": "10.0.0.1"})
+	return model
+}
+
+func (*modelDiffSuite) TestModelValuesSame(c *gc.C) {
+	source := newDiffModel(diffModelArgs{
+		name:          "prod",
+		defaultSeries: "trusty",
+		password:      "source-hash",
+		series:        "trusty",
+		settings:      map[string]interface{}{"port": 3306},
+	})
+	// The passwords and the rest of the model config are expected to
+	// change.
+	target := newDiffModel(diffModelArgs{
+		name:          "prod",
+		defaultSeries: "xenial",
+		password:      "target-hash",
+		series:        "trusty",
+		settings:      map[string]interface{}{"port": 3306},
+	})
+	values := modelValues(source)
+	c.Check(values["machine 0/instance-id"], gc.Equals, "i-0")
+	c.Check(values["unit mysql/0/machine"], gc.Equals, "0")
+	c.Check(values["relation mysql:cluster/endpoint mysql:cluster"], gc.Equals, "peer mysql-ha global")
+	c.Check(values["relation mysql:cluster/settings mysql/0/private-address"], gc.Equals, "10.0.0.1")
+	c.Assert(diffValues(values, modelValues(target)), gc.HasLen, 0)
+}
+
+func (*modelDiffSuite) TestModelValuesDifferences(c *gc.C) {
+	source := newDiffModel(diffModelArgs{
+		name:     "prod",
+		series:   "trusty",
+		settings: map[string]interface{}{"port": 3306, "debug": true},
+	})
+	target := newDiffModel(diffModelArgs{
+		name:     "prod-2",
+		series:   "xenial",
+		settings: map[string]interface{}{"port": 3307},
+	})
+	c.Assert(diffValues(modelValues(source), modelValues(target)), jc.DeepEquals, []string{
+		`application mysql/series: source "trusty", target "xenial"`,
+		`application mysql/settings/debug: missing from target (source "true")`,
+		`application mysql/settings/port: source "3306", target "3307"`,
+		`model/name: source "prod", target "prod-2"`,
+	})
+}
@@ -0,0 +1,471 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"github.com/juju/utils/set"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// planPhases are the phases a plan can run, in the order they must be
+// run in, with the commands that run them.
+var planPhases = []struct {
+	name            string
+	newCommand      func() cmd.Command
+	needsController bool
+}{
+	{"verify-source", newVerifySourceCommand, false},
+	{"agent-status", newAgentStatusCommand, false},
+	{"stop-agents", newStopAgentsCommand, false},
+	{"verify-target", newVerifyTargetCommand, true},
+	{"retag-instances", newRetagInstancesCommand, true},
+	{"migrate-security-groups", newMigrateSecurityGroupsCommand, true},
+	{"upgrade-agents", newUpgradeAgentsCommand, true},
+	{"start-agents", newStartAgentsCommand, true},
+	{"check-juju-run", newCheckJujuRunCommand, false},
+	{"cleanup-rsyslog", newCleanupRsyslogCommand, false},
+	{"finalize", newFinalizeCommand, true},
+}
+
+// planFields are the keys allowed in a plan file.
+var planFields = set.NewStrings(
+	"environment", "controller", "model-name",
+	"backup-dir", "skip-backup",
+	"machines", "applications", "exclude", "allow-unreachable", "force",
+	"canary", "batch", "max-failures", "wait-timeout",
+	"phases",
+)
+
+// upgradePlan describes a whole upgrade, for run --plan.
+type upgradePlan struct {
+	// Environment and Controller are the 1.25 environment and the
+	// controller it's migrated to.
+	Environment string `yaml:"environment"`
+	Controller  string `yaml:"controller"`
+
+	// ModelName is the name of the migrated model, if it isn't to
+	// keep the environment's name.
+	ModelName string `yaml:"model-name"`
+
+	BackupDir  string `yaml:"backup-dir"`
+	SkipBackup bool   `yaml:"skip-backup"`
+
+	Machines         []string `yaml:"machines"`
+	Applications     []string `yaml:"applications"`
+	Exclude          []string `yaml:"exclude"`
+	AllowUnreachable bool     `yaml:"allow-unreachable"`
+
+	// Force lets stop-agents and upgrade-agents go on with units that
+	// are part way through an operation, carrying it over.
+	Force bool `yaml:"force"`
+
+	Canary      []string `yaml:"canary"`
+	Batch       int      `yaml:"batch"`
+	MaxFailures int      `yaml:"max-failures"`
+	WaitTimeout string   `yaml:"wait-timeout"`
+
+	// Phases lists the phases to run, in order.
+	Phases []string `yaml:"phases"`
+}
+
+// parsePlan parses and validates a plan.
+func parsePlan(data []byte) (*upgradePlan, error) {
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, errors.Annotate(err, "parsing plan")
+	}
+	var unknown []string
+	for field := range fields {
+		if !planFields.Contains(field) {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.Errorf("unknown plan fields: %s", strings.Join(unknown, ", "))
+	}
+	var plan upgradePlan
+	if err := yaml.Unmarshal(data, &plan); err != nil {
+		return nil, errors.Annotate(err, "parsing plan")
+	}
+	if err := plan.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &plan, nil
+}
+
+// Validate returns an error listing everything wrong with the plan.
+func (p *upgradePlan) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if p.Environment == "" {
+		addf("environment not set")
+	}
+	if err := validateModelName(p.ModelName); err != nil {
+		addf("model-name %q not valid", p.ModelName)
+	}
+	if p.SkipBackup && p.BackupDir != "" {
+		addf("backup-dir set with skip-backup")
+	}
+	filter := p.filter()
+	if err := filter.validate(); err != nil {
+		addf("%v", err)
+	}
+	for _, id := range p.Canary {
+		if !names.IsValidMachine(id) {
+			addf("canary machine %q not valid", id)
+		}
+	}
+	if p.Batch < 0 {
+		addf("batch must not be negative")
+	}
+	if p.MaxFailures < 0 {
+		addf("max-failures must not be negative")
+	}
+	if p.WaitTimeout != "" {
+		if _, err := time.ParseDuration(p.WaitTimeout); err != nil {
+			addf("wait-timeout %q not valid", p.WaitTimeout)
+		}
+	}
+
+	if len(p.Phases) == 0 {
+		addf("no phases")
+	}
+	order := make(map[string]int)
+	for i, phase := range planPhases {
+		order[phase.name] = i
+	}
+	seen := set.NewStrings()
+	last := ""
+	for _, name := range p.Phases {
+		i, ok := order[name]
+		switch {
+		case !ok:
+			addf("unknown phase %q", name)
+			continue
+		case seen.Contains(name):
+			addf("phase %q listed twice", name)
+			continue
+		case last != "" && i < order[last]:
+			addf("phase %q must come before %q", name, last)
+		}
+		seen.Add(name)
+		last = name
+		if planPhases[i].needsController && p.Controller == "" {
+			addf("phase %q needs the controller", name)
+		}
+	}
+	// The environment is imported into the controller between
+	// stop-agents and verify-target, which this plugin doesn't do, so
+	// one plan can't run phases on both sides of the import.
+	var before, after []string
+	for i, phase := range planPhases {
+		switch {
+		case !seen.Contains(phase.name):
+		case i <= order["stop-agents"]:
+			before = append(before, phase.name)
+		case i >= order["verify-target"]:
+			after = append(after, phase.name)
+		}
+	}
+	if len(before) > 0 && len(after) > 0 {
+		addf("phases %s and %s can't be in one plan, as the environment is imported between them; "+
+			"split the plan after %q", strings.Join(before, ", "), strings.Join(after, ", "), before[len(before)-1])
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("plan not valid:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (p *upgradePlan) filter() machineFilter {
+	return machineFilter{
+		machines:     p.Machines,
+		applications: p.Applications,
+		exclude:      p.Exclude,
+	}
+}
+
+// phaseArgs returns the arguments to the command running the phase.
+func (p *upgradePlan) phaseArgs(phase string) []string {
+	var args []string
+	listFlag := func(name string, values []string) {
+		if len(values) > 0 {
+			args = append(args, "--"+name+"="+strings.Join(values, ","))
+		}
+	}
+	switch phase {
+	case "verify-source", "verify-target":
+		if p.ModelName != "" {
+			args = append(args, "--model-name="+p.ModelName)
+		}
+	case "stop-agents":
+		if p.SkipBackup {
+			args = append(args, "--skip-backup")
+		} else if p.BackupDir != "" {
+			args = append(args, "--backup-dir="+p.BackupDir)
+		}
+	case "upgrade-agents", "start-agents":
+		listFlag("canary", p.Canary)
+		if p.Batch > 0 {
+			args = append(args, "--batch="+strconv.Itoa(p.Batch))
+		}
+		if p.MaxFailures > 0 {
+			args = append(args, "--max-failures="+strconv.Itoa(p.MaxFailures))
+		}
+	}
+	switch phase {
+	case "agent-status", "stop-agents", "upgrade-agents", "start-agents":
+		listFlag("machines", p.Machines)
+		listFlag("applications", p.Applications)
+		listFlag("exclude", p.Exclude)
+	}
+	switch phase {
+	case "stop-agents", "upgrade-agents", "start-agents":
+		if p.AllowUnreachable {
+			args = append(args, "--allow-unreachable")
+		}
+	}
+	if (phase == "stop-agents" || phase == "upgrade-agents") && p.Force {
+		args = append(args, "--force")
+	}
+	if phase == "start-agents" {
+		args = append(args, "--wait")
+	}
+	if (phase == "upgrade-agents" || phase == "start-agents") && p.WaitTimeout != "" {
+		args = append(args, "--timeout="+p.WaitTimeout)
+	}
+	args = append(args, p.Environment)
+	for _, planPhase := range planPhases {
+		if planPhase.name == phase && planPhase.needsController {
+			args = append(args, p.Controller)
+		}
+	}
+	return args
+}
+
+// hash returns a hash of the plan's settings, identifying the plan
+// that progress was made on. Formatting and comments in the plan file
+// don't change it.
+func (p *upgradePlan) hash() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// planProgress records the phases of a plan that have completed, and
+// the hash of the plan they were run from.
+type planProgress struct {
+	Plan string
+	Done []string
+}
+
+// forPlan returns the progress to carry on the plan with the given
+// hash from. Progress made on a different plan is only thrown away if
+// restart is true; otherwise it's an error, as the phases done may not
+// be the ones the plan asks for.
+func (p *planProgress) forPlan(hash string, restart bool) (*planProgress, error) {
+	switch {
+	case p.Plan == hash:
+		return p, nil
+	case len(p.Done) == 0 || restart:
+		return &planProgress{Plan: hash}, nil
+	}
+	return nil, errors.Errorf("the recorded progress (phases %s done) is for a different plan; "+
+		"use --restart to run this plan from the start", strings.Join(p.Done, ", "))
+}
+
+func planProgressPath(envName string) string {
+	return filepath.Join(localStateDir(envName), "plan-progress.json")
+}
+
+func loadPlanProgress(envName string) (*planProgress, error) {
+	progress := &planProgress{}
+	bytes, err := ioutil.ReadFile(planProgressPath(envName))
+	if os.IsNotExist(err) {
+		return progress, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := json.Unmarshal(bytes, progress); err != nil {
+		return nil, errors.Annotate(err, "reading plan progress")
+	}
+	return progress, nil
+}
+
+func (p *planProgress) save(envName string) error {
+	bytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(localStateDir(envName), 0700); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(planProgressPath(envName), bytes, 0600))
+}
+
+var runDoc = `
+The purpose of the run command is to carry out an upgrade described by a plan
+file, running each of its phases in turn with the options in the plan.
+
+The plan is validated before anything is run. For example:
+
+  environment: production
+  controller: prod-2
+  exclude: ["7"]
+  canary: ["3"]
+  batch: 10
+  max-failures: 2
+  wait-timeout: 20m
+  phases:
+    - verify-target
+    - retag-instances
+    - upgrade-agents
+    - start-agents
+    - check-juju-run
+    - cleanup-rsyslog
+
+The phases are verify-source, agent-status, stop-agents, verify-target,
+retag-instances, migrate-security-groups, upgrade-agents, start-agents,
+check-juju-run, cleanup-rsyslog and finalize, and must be listed in that
+order. The environment is imported into the controller after stop-agents,
+outside this plugin, so the phases up to stop-agents and those from
+verify-target on go in separate plans. machines, applications, exclude and
+allow-unreachable apply to the agent phases, and force to stop-agents and
+upgrade-agents; canary, batch and max-failures to upgrade-agents and
+start-agents, which wait up to wait-timeout for the agents. model-name renames
+the model in the export made by verify-source, and is checked by
+verify-target.
+
+Completed phases are recorded, so running the plan again after a failure
+carries on with the phase that failed; the agent phases themselves carry on
+from where they stopped. The progress is only used for the same plan: if the
+plan has been changed since, the command fails, and --restart runs the changed
+plan from its first phase. (The saved progress of the agent phases' rollouts
+is kept.)
+
+`
+
+func newRunCommand() cmd.Command {
+	return &runCommand{}
+}
+
+type runCommand struct {
+	cmd.CommandBase
+
+	planFile string
+	restart  bool
+	plan     *upgradePlan
+}
+
+func (c *runCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "run",
+		Args:    "--plan <plan file>",
+		Purpose: "run the phases of an upgrade plan",
+		Doc:     runDoc,
+	}
+}
+
+func (c *runCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.StringVar(&c.planFile, "plan", "", "the plan file")
+	f.BoolVar(&c.restart, "restart", false, "forget the progress made on a different plan")
+}
+
+func (c *runCommand) Init(args []string) error {
+	if c.planFile == "" {
+		return errors.New("--plan must be given")
+	}
+	data, err := ioutil.ReadFile(c.planFile)
+	if err != nil {
+		return errors.Annotate(err, "reading plan")
+	}
+	if c.plan, err = parsePlan(data); err != nil {
+		return errors.Annotatef(err, "%s", c.planFile)
+	}
+	return cmd.CheckEmpty(args)
+}
+
+func (c *runCommand) Run(ctx *cmd.Context) error {
+	env := c.plan.Environment
+	hash, err := c.plan.hash()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	progress, err := loadPlanProgress(env)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if progress, err = progress.forPlan(hash, c.restart); err != nil {
+		return errors.Trace(err)
+	}
+	done := set.NewStrings(progress.Done...)
+	for _, phase := range c.plan.Phases {
+		if done.Contains(phase) {
+			ctx.Infof("phase %s already done", phase)
+			continue
+		}
+		ctx.Infof("running phase %s", phase)
+		if err := runPhase(ctx, phase, c.plan.phaseArgs(phase)); err != nil {
+			return errors.Annotatef(err, "phase %s failed; run the plan again to continue", phase)
+		}
+		done.Add(phase)
+		progress.Done = append(progress.Done, phase)
+		if err := progress.save(env); err != nil {
+			return errors.Annotate(err, "saving plan progress")
+		}
+		if err := appendRunLog(env, "run: phase %s done", phase); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// runPhase runs the command for the phase with the given arguments, as
+// if it had been run from the command line.
+func runPhase(ctx *cmd.Context, phase string, args []string) error {
+	var command cmd.Command
+	for _, planPhase := range planPhases {
+		if planPhase.name == phase {
+			command = planPhase.newCommand()
+		}
+	}
+	if command == nil {
+		return errors.NotValidf("phase %q", phase)
+	}
+	setAuditPhase(phase)
+	f := gnuflag.NewFlagSet(phase, gnuflag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	command.SetFlags(f)
+	if err := f.Parse(command.AllowInterspersedFlags(), args); err != nil {
+		return errors.Trace(err)
+	}
+	if err := command.Init(f.Args()); err != nil {
+		return errors.Trace(err)
+	}
+	return command.Run(ctx)
+}
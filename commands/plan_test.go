@@ -0,0 +1,121 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type planSuite struct{}
+
+var _ = gc.Suite(&planSuite{})
+
+const testPlan = `
+environment: production
+controller: prod-2
+model-name: prod-web
+backup-dir: /srv/backups
+exclude: ["7", nagios]
+allow-unreachable: true
+force: true
+canary: ["3"]
+batch: 10
+max-failures: 2
+wait-timeout: 20m
+phases:
+  - verify-target
+  - upgrade-agents
+  - start-agents
+`
+
+func (*planSuite) TestParsePlan(c *gc.C) {
+	plan, err := parsePlan([]byte(testPlan))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(plan.phaseArgs("verify-source"), jc.DeepEquals, []string{"--model-name=prod-web", "production"})
+	c.Assert(plan.phaseArgs("stop-agents"), jc.DeepEquals, []string{
+		"--backup-dir=/srv/backups",
+		"--exclude=7,nagios",
+		"--allow-unreachable",
+		"--force",
+		"production",
+	})
+	c.Assert(plan.phaseArgs("upgrade-agents"), jc.DeepEquals, []string{
+		"--canary=3", "--batch=10", "--max-failures=2",
+		"--exclude=7,nagios",
+		"--allow-unreachable",
+		"--force",
+		"--timeout=20m",
+		"production", "prod-2",
+	})
+	c.Assert(plan.phaseArgs("start-agents"), jc.DeepEquals, []string{
+		"--canary=3", "--batch=10", "--max-failures=2",
+		"--exclude=7,nagios",
+		"--allow-unreachable",
+		"--wait", "--timeout=20m",
+		"production", "prod-2",
+	})
+}
+
+func (*planSuite) TestParsePlanUnknownFields(c *gc.C) {
+	_, err := parsePlan([]byte("environment: production\nphases: [verify-source]\nbatchsize: 3\ncontroler: x\n"))
+	c.Assert(err, gc.ErrorMatches, "unknown plan fields: batchsize, controler")
+}
+
+func (*planSuite) TestParsePlanInvalid(c *gc.C) {
+	_, err := parsePlan([]byte(`
+model-name: Staging!
+skip-backup: true
+backup-dir: /srv/backups
+machines: ["1/lxc"]
+batch: -1
+wait-timeout: soon
+phases: [start-agents, stop-agents, stop-agents, reboot]
+`))
+	c.Assert(err, gc.ErrorMatches, `plan not valid:
+  environment not set
+  model-name "Staging!" not valid
+  backup-dir set with skip-backup
+  machine "1/lxc" not valid
+  batch must not be negative
+  wait-timeout "soon" not valid
+  phase "start-agents" needs the controller
+  phase "stop-agents" must come before "start-agents"
+  phase "stop-agents" listed twice
+  unknown phase "reboot"
+  phases stop-agents and start-agents can't be in one plan, as the environment is imported between them; split the plan after "stop-agents"`)
+}
+
+func (*planSuite) TestPlanProgressForPlan(c *gc.C) {
+	plan, err := parsePlan([]byte(testPlan))
+	c.Assert(err, jc.ErrorIsNil)
+	hash, err := plan.hash()
+	c.Assert(err, jc.ErrorIsNil)
+
+	// Reformatting the plan doesn't change it.
+	reformatted, err := parsePlan([]byte("# reviewed\n" + testPlan))
+	c.Assert(err, jc.ErrorIsNil)
+	same, err := reformatted.hash()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(same, gc.Equals, hash)
+
+	progress, err := (&planProgress{}).forPlan(hash, false)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(progress, jc.DeepEquals, &planProgress{Plan: hash})
+
+	done := &planProgress{Plan: hash, Done: []string{"verify-target", "upgrade-agents"}}
+	progress, err = done.forPlan(hash, false)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(progress, jc.DeepEquals, done)
+
+	plan.Batch = 5
+	changed, err := plan.hash()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(changed, gc.Not(gc.Equals), hash)
+	_, err = done.forPlan(changed, false)
+	c.Assert(err, gc.ErrorMatches, `the recorded progress \(phases verify-target, upgrade-agents done\) is for a different plan; use --restart to run this plan from the start`)
+	progress, err = done.forPlan(changed, true)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(progress, jc.DeepEquals, &planProgress{Plan: changed})
+}
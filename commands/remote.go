@@ -4,72 +4,220 @@
 package commands
 
 import (
-	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/juju/cmd"
 	"github.com/juju/errors"
+	"github.com/juju/utils"
 )
 
-func remoteMD5Sum(plugin, address string) (string, error) {
-	pluginBase := filepath.Base(plugin)
+const (
+	// remotePluginDir is where the plugin is installed on the state
+	// server. It's owned by root and private, as the plugin is given
+	// controller credentials. Remote commands run from there, so the
+	// files they keep are there too.
+	remotePluginDir = "/var/lib/juju/1.25-upgrade/plugin"
 
-	result, err := runViaSSH(
-		address,
-		fmt.Sprintf("md5sum %s | cut -f 1 -d ' '\n", pluginBase),
-		"")
+	// pluginBundleEnvKey names the directory holding the plugin built
+	// for each architecture, as <plugin>-<arch>. It defaults to
+	// 1.25-upgrade-bundle next to the plugin.
+	pluginBundleEnvKey = "JUJU_1_25_UPGRADE_BUNDLE"
+)
 
-	if err != nil {
-		return "", errors.Annotate(err, "getting md5sum")
+// pluginArches maps the machine names reported by uname -m to the
+// architectures the plugin is built for.
+var pluginArches = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"ppc64le": "ppc64el",
+	"s390x":   "s390x",
+}
+
+// remotePluginPath returns where the plugin is installed on the state
+// server.
+func remotePluginPath(plugin string) string {
+	return path.Join(remotePluginDir, filepath.Base(plugin))
+}
+
+// remotePlugin is what a state server reports about itself and its
+// copy of the plugin.
+type remotePlugin struct {
+	arch    string
+	version string
+	sha256  string
+}
+
+// remotePluginScript prints the state server's machine name then, if
+// the plugin is installed, its version and SHA256 sum.
+const remotePluginScript = `
+set -u
+uname -m
+if [ -x %[1]s ]; then
+	%[1]s version 2>/dev/null || echo
+	sha256sum %[1]s | cut -f 1 -d ' '
+fi
+`
+
+// parseRemotePlugin parses the output of remotePluginScript.
+func parseRemotePlugin(output string) (remotePlugin, error) {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	arch, ok := pluginArches[strings.TrimSpace(lines[0])]
+	if !ok {
+		return remotePlugin{}, errors.NotSupportedf("state server architecture %q", lines[0])
+	}
+	result := remotePlugin{arch: arch}
+	if len(lines) == 3 {
+		result.version = strings.TrimSpace(lines[1])
+		result.sha256 = strings.TrimSpace(lines[2])
 	}
+	return result, nil
+}
 
+func getRemotePlugin(plugin, address string) (remotePlugin, error) {
+	result, err := runViaSSH(address, fmt.Sprintf(remotePluginScript, remotePluginPath(plugin)), "")
+	if err != nil {
+		return remotePlugin{}, errors.Annotate(err, "checking remote plugin")
+	}
 	if result.Code != 0 {
-		return "", errors.Errorf("getting md5: %q, %q", result.Stdout, result.Stderr)
+		return remotePlugin{}, errors.Errorf("checking remote plugin: %q, %q", result.Stdout, result.Stderr)
+	}
+	return parseRemotePlugin(result.Stdout)
+}
+
+// localArch returns the architecture the running plugin was built for,
+// named as in pluginArches.
+func localArch() string {
+	if runtime.GOARCH == "ppc64le" {
+		return "ppc64el"
+	}
+	return runtime.GOARCH
+}
+
+// pluginForArch returns the local plugin binary to install on a state
+// server with the given architecture: the one in the bundle if there
+// is one, or the running plugin if it was built for that architecture.
+func pluginForArch(plugin, arch string) (string, error) {
+	bundle := os.Getenv(pluginBundleEnvKey)
+	if bundle == "" {
+		bundle = filepath.Join(filepath.Dir(plugin), "1.25-upgrade-bundle")
 	}
+	bundled := filepath.Join(bundle, filepath.Base(plugin)+"-"+arch)
+	if _, err := os.Stat(bundled); err == nil {
+		return bundled, nil
+	} else if !os.IsNotExist(err) {
+		return "", errors.Trace(err)
+	}
+	if runtime.GOOS == "linux" && localArch() == arch {
+		return plugin, nil
+	}
+	return "", errors.NotFoundf("plugin for %s in %s", arch, bundle)
+}
 
+// remoteSHA256Sum returns the SHA256 sum of the file with the given
+// path on the state server, or "" if there isn't one.
+func remoteSHA256Sum(address, path string) (string, error) {
+	script := fmt.Sprintf("[ ! -f %[1]s ] || sha256sum %[1]s | cut -f 1 -d ' '", utils.ShQuote(path))
+	result, err := runViaSSH(address, script, "")
+	if err != nil {
+		return "", errors.Annotatef(err, "checking %s", path)
+	}
+	if result.Code != 0 {
+		return "", errors.Errorf("checking %s: %q", path, result.Stderr)
+	}
 	return strings.TrimSpace(result.Stdout), nil
 }
 
-func localMD5Sum(plugin string) (string, error) {
-	content, err := ioutil.ReadFile(plugin)
+func localSHA256Sum(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return "", errors.Annotate(err, "opening plugin")
 	}
-	bytes := md5.Sum(content)
-	return fmt.Sprintf("%x", bytes), nil
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", errors.Annotate(err, "reading plugin")
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
-func updateRemotePlugin(plugin, address string) error {
-	scp := exec.Command("scp", plugin, fmt.Sprintf("ubuntu@%s:~", address))
-	copyResult, err := scp.CombinedOutput()
+// installRemoteFile copies the local file to the state server and
+// installs it, owned by root, at remotePath in remotePluginDir. The
+// change is recorded in the audit log.
+func installRemoteFile(address, local, remotePath string, mode os.FileMode) (err error) {
+	start := time.Now()
+	defer func() {
+		auditFileChange(start, address, "", remotePath, err)
+	}()
+	upload := ".1.25-upgrade-upload-" + path.Base(remotePath)
+	scp := exec.Command("scp", local, fmt.Sprintf("ubuntu@%s:%s", address, upload))
+	if out, err := scp.CombinedOutput(); err != nil {
+		return errors.Annotatef(err, "copying %s to the state server: %s", local, out)
+	}
+	script := fmt.Sprintf(`
+set -e
+mkdir -p %[1]s
+chown root:root %[1]s
+chmod 0700 %[1]s
+install -o root -g root -m %[2]o ~ubuntu/%[3]s %[4]s
+rm -f ~ubuntu/%[3]s
+`, remotePluginDir, uint32(mode.Perm()), upload, utils.ShQuote(remotePath))
+	result, err := runViaSSH(address, script, "")
 	if err != nil {
-		return errors.Annotate(err, "copying command to environment")
+		return errors.Annotatef(err, "installing %s", remotePath)
+	}
+	if result.Code != 0 {
+		return errors.Errorf("installing %s: %q", remotePath, result.Stderr)
 	}
-	logger.Debugf("scp: %s", copyResult)
 	return nil
 }
 
+// checkUpdatePlugin makes sure the state server has the plugin for its
+// architecture installed, at this plugin's version. The plugin is
+// (re)installed if the version it reports or its SHA256 sum doesn't
+// match, and checked again afterwards.
 func checkUpdatePlugin(ctx *cmd.Context, plugin, address string) error {
 	ctx.Infof("checking remote plugin")
-	local, err := localMD5Sum(plugin)
+	remote, err := getRemotePlugin(plugin, address)
 	if err != nil {
-		return errors.Annotate(err, "generating local md5sum")
+		return errors.Trace(err)
 	}
-	ctx.Verbosef("local: %q", local)
-
-	remote, err := remoteMD5Sum(plugin, address)
+	local, err := pluginForArch(plugin, remote.arch)
 	if err != nil {
-		return errors.Annotate(err, "generating remote md5sum")
+		return errors.Trace(err)
+	}
+	sum, err := localSHA256Sum(local)
+	if err != nil {
+		return errors.Annotate(err, "generating local sha256sum")
+	}
+	version := upgraderVersion.String()
+	ctx.Verbosef("local: %s version %q sha256 %s", local, version, sum)
+	ctx.Verbosef("remote: %s version %q sha256 %s", remote.arch, remote.version, remote.sha256)
+	if remote.version == version && remote.sha256 == sum {
+		return nil
 	}
-	ctx.Verbosef("remote: %q", remote)
 
-	if local != remote {
-		ctx.Infof("updating remote plugin")
-		return updateRemotePlugin(plugin, address)
+	ctx.Infof("installing %s plugin on the state server", remote.arch)
+	if err := installRemoteFile(address, local, remotePluginPath(plugin), 0700); err != nil {
+		return errors.Trace(err)
+	}
+	remote, err = getRemotePlugin(plugin, address)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if remote.sha256 != sum {
+		return errors.Errorf("installed plugin sha256 %s doesn't match %s", remote.sha256, sum)
+	}
+	if remote.version != version {
+		return errors.Errorf("installed plugin reports version %q, expected %q", remote.version, version)
 	}
 	return nil
 }
@@ -0,0 +1,54 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type remoteSuite struct{}
+
+var _ = gc.Suite(&remoteSuite{})
+
+func (*remoteSuite) TestParseRemotePlugin(c *gc.C) {
+	plugin, err := parseRemotePlugin("ppc64le\n0.2.0\nabc123\n")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(plugin, jc.DeepEquals, remotePlugin{arch: "ppc64el", version: "0.2.0", sha256: "abc123"})
+}
+
+func (*remoteSuite) TestParseRemotePluginNotInstalled(c *gc.C) {
+	plugin, err := parseRemotePlugin("aarch64\n")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(plugin, jc.DeepEquals, remotePlugin{arch: "arm64"})
+}
+
+func (*remoteSuite) TestParseRemotePluginUnsupportedArch(c *gc.C) {
+	_, err := parseRemotePlugin("i686\n")
+	c.Assert(err, gc.ErrorMatches, `state server architecture "i686" not supported`)
+}
+
+func (*remoteSuite) TestPluginForArch(c *gc.C) {
+	bundle := c.MkDir()
+	defer os.Setenv(pluginBundleEnvKey, os.Getenv(pluginBundleEnvKey))
+	os.Setenv(pluginBundleEnvKey, bundle)
+
+	bundled := filepath.Join(bundle, "juju-1.25-upgrade-s390x")
+	err := ioutil.WriteFile(bundled, []byte("plugin"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	path, err := pluginForArch("/usr/bin/juju-1.25-upgrade", "s390x")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(path, gc.Equals, bundled)
+
+	arch := "arm64"
+	if localArch() == arch {
+		arch = "amd64"
+	}
+	_, err = pluginForArch("/usr/bin/juju-1.25-upgrade", arch)
+	c.Assert(err, gc.ErrorMatches, "plugin for "+arch+" in .* not found")
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/retag"
+)
+
+var retagInstancesDoc = `
+The purpose of the retag-instances command is to tag the cloud resources of
+the migrated environment for the 2.x model and controller. 1.25 tags
+instances and volumes with juju-env-uuid; the 2.x instance poller, firewaller
+and destroy-model look for juju-model-uuid and juju-controller-uuid instead,
+and without them destroying the model on 2.x will miss, and leak, the
+environment's instances.
+
+The resources are found, and tagged, from the 1.25 state server by the 2.x
+provider, as when a model is migrated between 2.x controllers, using the
+credentials in the environment config. The 1.25 tags are left in place. The
+command fails if the resources couldn't all be tagged, and can be run again.
+
+Supported clouds are ec2, openstack and gce. Environments on azure used the
+classic API in 1.25, which 2.x can't manage at all.
+
+`
+
+func newRetagInstancesCommand() cmd.Command {
+	return &retagInstancesCommand{
+		baseClientCommand: baseClientCommand{
+			needsController: true,
+			remoteCommand:   "retag-instances-impl",
+		},
+	}
+}
+
+type retagInstancesCommand struct {
+	baseClientCommand
+}
+
+func (c *retagInstancesCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "retag-instances",
+		Args:    "<environment name> <controller name>",
+		Purpose: "tag the environment's instances and volumes for the 2.x controller",
+		Doc:     retagInstancesDoc,
+	}
+}
+
+func (c *retagInstancesCommand) Init(args []string) error {
+	args, err := c.baseClientCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The impl only needs the controller's UUID, not its API info.
+	details, err := c.controller.ClientStore().ControllerByName(c.controller.ControllerName())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.remoteArgs = details.ControllerUUID
+	c.passController = false
+	return cmd.CheckEmpty(args)
+}
+
+func (c *retagInstancesCommand) Run(ctx *cmd.Context) error {
+	if err := c.baseClientCommand.Run(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	return appendRunLog(c.name, "retag-instances: resources tagged for controller %s", c.remoteArgs)
+}
+
+var retagInstancesImplDoc = `
+
+retag-instances-impl must be executed on an API server machine of a 1.25
+environment.
+
+The command will read the environment config, and tag the environment's
+resources in the cloud for the controller with the UUID given.
+
+`
+
+func newRetagInstancesImplCommand() cmd.Command {
+	return &retagInstancesImplCommand{}
+}
+
+type retagInstancesImplCommand struct {
+	baseRemoteCommand
+
+	controllerUUID string
+}
+
+func (c *retagInstancesImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "retag-instances-impl",
+		Args:    "<controller uuid>",
+		Purpose: "controller aspect of retag-instances",
+		Doc:     retagInstancesImplDoc,
+	}
+}
+
+func (c *retagInstancesImplCommand) Init(args []string) error {
+	args, err := c.baseRemoteCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(args) == 0 {
+		return errors.New("no controller UUID specified")
+	}
+	c.controllerUUID, args = args[0], args[1:]
+	return cmd.CheckEmpty(args)
+}
+
+func (c *retagInstancesImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	defer st.Close()
+
+	cfg, err := st.EnvironConfig()
+	if err != nil {
+		return errors.Annotate(err, "getting environment config")
+	}
+	if err := retag.Retag(cfg, c.controllerUUID); err != nil {
+		return errors.Annotate(err, "tagging resources")
+	}
+	ctx.Infof("resources tagged for controller %s", c.controllerUUID)
+	return nil
+}
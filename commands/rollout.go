@@ -0,0 +1,284 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"github.com/juju/utils/set"
+)
+
+// rolloutOptions control how the agent commands work through the
+// machines of an environment. With neither a canary nor a batch size,
+// every machine is processed in one go.
+type rolloutOptions struct {
+	canary      []string
+	batchSize   int
+	maxFailures int
+}
+
+func (o *rolloutOptions) setFlags(f *gnuflag.FlagSet) {
+	f.Var(cmd.NewStringsValue(nil, &o.canary), "canary", "machines to process and verify before any others")
+	f.IntVar(&o.batchSize, "batch", 0, "process the remaining machines in batches of this size")
+	f.IntVar(&o.maxFailures, "max-failures", 0, "pause once more than this many machines have failed")
+}
+
+func (o *rolloutOptions) enabled() bool {
+	return len(o.canary) > 0 || o.batchSize > 0
+}
+
+func (o *rolloutOptions) validate() error {
+	if o.batchSize < 0 {
+		return errors.Errorf("--batch must not be negative")
+	}
+	if o.maxFailures < 0 {
+		return errors.Errorf("--max-failures must not be negative")
+	}
+	return nil
+}
+
+// planBatches splits machines into the batches to process: the canary
+// machines first, then the rest in groups of batchSize (or all
+// together if batchSize is zero).
+func planBatches(machines, canary []string, batchSize int) ([][]string, error) {
+	known := set.NewStrings(machines...)
+	for _, id := range canary {
+		if !known.Contains(id) {
+			return nil, errors.Errorf("canary machine %q not found", id)
+		}
+	}
+	var batches [][]string
+	if len(canary) > 0 {
+		batches = append(batches, canary)
+	}
+	canaries := set.NewStrings(canary...)
+	var rest []string
+	for _, id := range machines {
+		if !canaries.Contains(id) {
+			rest = append(rest, id)
+		}
+	}
+	if batchSize == 0 {
+		batchSize = len(rest)
+	}
+	for len(rest) > 0 {
+		n := batchSize
+		if n > len(rest) {
+			n = len(rest)
+		}
+		batches = append(batches, rest[:n])
+		rest = rest[n:]
+	}
+	return batches, nil
+}
+
+// rolloutProgress records which machines a command has completed, so an
+// interrupted or paused rollout carries on where it left off.
+type rolloutProgress struct {
+	Command string
+	Done    []string
+	Failed  []string
+}
+
+func rolloutProgressPath(envName, command string) string {
+	return filepath.Join(localStateDir(envName), command+"-progress.json")
+}
+
+func loadRolloutProgress(envName, command string) (*rolloutProgress, error) {
+	progress := &rolloutProgress{Command: command}
+	bytes, err := ioutil.ReadFile(rolloutProgressPath(envName, command))
+	if os.IsNotExist(err) {
+		return progress, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := json.Unmarshal(bytes, progress); err != nil {
+		return nil, errors.Annotate(err, "reading rollout progress")
+	}
+	return progress, nil
+}
+
+func (p *rolloutProgress) record(batch, failed []string) {
+	failures := set.NewStrings(failed...)
+	done := set.NewStrings(p.Done...)
+	outstanding := set.NewStrings(p.Failed...)
+	for _, id := range batch {
+		if failures.Contains(id) {
+			outstanding.Add(id)
+		} else {
+			done.Add(id)
+			outstanding.Remove(id)
+		}
+	}
+	p.Done = done.SortedValues()
+	p.Failed = outstanding.SortedValues()
+}
+
+func (p *rolloutProgress) save(envName string) error {
+	bytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(localStateDir(envName), 0700); err != nil {
+		return errors.Trace(err)
+	}
+	path := rolloutProgressPath(envName, p.Command)
+	return errors.Trace(ioutil.WriteFile(path, bytes, 0600))
+}
+
+// verifyBatchFunc checks a batch that the remote command reported as
+// successful, and returns the machines that failed verification.
+type verifyBatchFunc func(ctx *cmd.Context, batch []string) ([]string, error)
+
+// runRollout runs the remote command over the machines of the
+// environment as directed by opts. Each batch is verified with verify
+// (if not nil) before moving on, and progress is saved after every
+// batch. The rollout pauses if a canary fails or if more than
+// opts.maxFailures machines fail.
+func (c *baseClientCommand) runRollout(ctx *cmd.Context, opts rolloutOptions, verify verifyBatchFunc) error {
+	machines, err := c.selectedMachines(ctx)
+	if err != nil {
+		return errors.Annotate(err, "selecting machines")
+	}
+	progress, err := loadRolloutProgress(c.name, c.remoteCommand)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	done := set.NewStrings(progress.Done...)
+	var remaining []string
+	for _, m := range machines {
+		if !done.Contains(m.ID) {
+			remaining = append(remaining, m.ID)
+		}
+	}
+	if len(remaining) == 0 {
+		ctx.Infof("all machines already done; remove %s to start again",
+			rolloutProgressPath(c.name, c.remoteCommand))
+		return nil
+	}
+	var canary []string
+	for _, id := range opts.canary {
+		if !done.Contains(id) {
+			canary = append(canary, id)
+		}
+	}
+	batches, err := planBatches(remaining, canary, opts.batchSize)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	failures := 0
+	for i, batch := range batches {
+		ctx.Infof("batch %d of %d: machines %s", i+1, len(batches), strings.Join(batch, ", "))
+		stdin, err := c.remoteControllerInfo()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		result, err := runRemoteCommand(c, ctx, stdin, c.remoteCommand, "--machines="+strings.Join(batch, ","), c.remoteArgs)
+		if err != nil {
+			return errors.Annotatef(err, "running %s via SSH", c.remoteCommand)
+		}
+		fmt.Fprintf(ctx.Stdout, result.Stdout)
+		fmt.Fprintf(ctx.Stderr, result.Stderr)
+
+		failed := batch
+		if result.Code == 0 {
+			failed = nil
+			if verify != nil {
+				if failed, err = verify(ctx, batch); err != nil {
+					return errors.Annotate(err, "verifying batch")
+				}
+			}
+		}
+		progress.record(batch, failed)
+		if err := progress.save(c.name); err != nil {
+			return errors.Annotate(err, "saving rollout progress")
+		}
+		if err := appendRunLog(c.name, "%s: batch %s, failed %s", c.remoteCommand,
+			strings.Join(batch, ","), strings.Join(failed, ",")); err != nil {
+			return errors.Trace(err)
+		}
+
+		failures += len(failed)
+		if i == 0 && len(canary) > 0 && len(failed) > 0 {
+			return errors.Errorf("canary machines failed: %s; fix them and run again to continue",
+				strings.Join(failed, ", "))
+		}
+		if failures > opts.maxFailures {
+			return errors.Errorf("pausing rollout after %d failed machines (%s); run again to continue",
+				failures, strings.Join(progress.Failed, ", "))
+		}
+	}
+	return nil
+}
+
+// listMachines asks the state server for the machines in the
+// environment.
+func (c *baseClientCommand) listMachines(ctx *cmd.Context) ([]FlatMachine, error) {
+	result, err := c.runRemote(ctx, "list-machines-impl")
+	if err != nil {
+		return nil, errors.Annotate(err, "running list-machines-impl via SSH")
+	}
+	if result.Code != 0 {
+		return nil, errors.Errorf("listing machines failed: %s", result.Stderr)
+	}
+	var machines []FlatMachine
+	if err := json.Unmarshal([]byte(result.Stdout), &machines); err != nil {
+		return nil, errors.Annotate(err, "unmarshalling machines")
+	}
+	return machines, nil
+}
+
+var listMachinesImplDoc = `
+
+list-machines-impl must be executed on an API server machine of a 1.25
+environment.
+
+The command writes the machines of the environment, with their addresses and
+tools versions, as JSON.
+
+`
+
+func newListMachinesImplCommand() cmd.Command {
+	return &listMachinesImplCommand{}
+}
+
+type listMachinesImplCommand struct {
+	baseRemoteCommand
+}
+
+func (c *listMachinesImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "list-machines-impl",
+		Purpose: "list the machines of the environment",
+		Doc:     listMachinesImplDoc,
+	}
+}
+
+func (c *listMachinesImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	defer st.Close()
+
+	machines, err := getMachines(st)
+	if err != nil {
+		return errors.Annotate(err, "unable to get addresses for machines")
+	}
+	bytes, err := json.Marshal(machines)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = ctx.GetStdout().Write(bytes)
+	return errors.Annotate(err, "writing machines")
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/juju/cmd"
+	"github.com/juju/cmd/cmdtesting"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/juju2/api"
+	"github.com/juju/1.25-upgrade/juju2/juju/osenv"
+)
+
+type rolloutSuite struct{}
+
+var _ = gc.Suite(&rolloutSuite{})
+
+func (*rolloutSuite) TestPlanBatchesAllAtOnce(c *gc.C) {
+	batches, err := planBatches([]string{"0", "1", "2"}, nil, 0)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(batches, jc.DeepEquals, [][]string{{"0", "1", "2"}})
+}
+
+func (*rolloutSuite) TestPlanBatchesCanaryFirst(c *gc.C) {
+	batches, err := planBatches([]string{"0", "1", "2", "3", "4", "5"}, []string{"4"}, 2)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(batches, jc.DeepEquals, [][]string{{"4"}, {"0", "1"}, {"2", "3"}, {"5"}})
+}
+
+func (*rolloutSuite) TestPlanBatchesUnknownCanary(c *gc.C) {
+	_, err := planBatches([]string{"0", "1"}, []string{"7"}, 1)
+	c.Assert(err, gc.ErrorMatches, `canary machine "7" not found`)
+}
+
+func (*rolloutSuite) TestProgressRecord(c *gc.C) {
+	progress := &rolloutProgress{Command: "start-agents-impl"}
+	progress.record([]string{"0", "1"}, []string{"1"})
+	c.Assert(progress.Done, jc.DeepEquals, []string{"0"})
+	c.Assert(progress.Failed, jc.DeepEquals, []string{"1"})
+
+	progress.record([]string{"1", "2"}, nil)
+	c.Assert(progress.Done, jc.DeepEquals, []string{"0", "1", "2"})
+	c.Assert(progress.Failed, gc.HasLen, 0)
+}
+
+func (*rolloutSuite) TestRolloutGetsControllerInfoForEachBatch(c *gc.C) {
+	defer osenv.SetJujuXDGDataHome(osenv.SetJujuXDGDataHome(c.MkDir()))
+
+	fetched := 0
+	defer func(old func(*baseClientCommand) (*api.Info, error)) {
+		getMigrationControllerInfo = old
+	}(getMigrationControllerInfo)
+	getMigrationControllerInfo = func(*baseClientCommand) (*api.Info, error) {
+		fetched++
+		return &api.Info{
+			Addrs: []string{fmt.Sprintf("10.0.0.%d:17070", fetched)},
+			Tag:   names.NewUserTag("admin"),
+		}, nil
+	}
+
+	var addrs []string
+	defer func(old func(*baseClientCommand, *cmd.Context, []byte, string, ...string) (RunResult, error)) {
+		runRemoteCommand = old
+	}(runRemoteCommand)
+	runRemoteCommand = func(_ *baseClientCommand, _ *cmd.Context, stdin []byte, command string, _ ...string) (RunResult, error) {
+		if command == "list-machines-impl" {
+			c.Check(stdin, gc.IsNil)
+			out, err := json.Marshal([]FlatMachine{
+				{ID: "0", Reachability: reachable},
+				{ID: "1", Reachability: reachable},
+			})
+			c.Assert(err, jc.ErrorIsNil)
+			return RunResult{Stdout: string(out)}, nil
+		}
+		info, err := readControllerInfo(bytes.NewReader(stdin))
+		c.Assert(err, jc.ErrorIsNil)
+		addrs = append(addrs, info.Addrs...)
+		return RunResult{}, nil
+	}
+
+	command := &baseClientCommand{
+		name:           "test-env",
+		remoteCommand:  "upgrade-agents-impl",
+		checkReachable: true,
+		passController: true,
+	}
+	err := command.runRollout(cmdtesting.Context(c), rolloutOptions{batchSize: 1}, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(fetched, gc.Equals, 2)
+	c.Check(addrs, jc.DeepEquals, []string{"10.0.0.1:17070", "10.0.0.2:17070"})
+}
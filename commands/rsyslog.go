@@ -0,0 +1,199 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju2/cmd/output"
+)
+
+var cleanupRsyslogDoc = `
+The purpose of the cleanup-rsyslog command is to stop the machines of a
+migrated environment forwarding their logs to the 1.25 state server. The 2.x
+agents send their logs to the controller over the API instead.
+
+On every machine, the rsyslog configuration written by the 1.25 agents
+(/etc/rsyslog.d/25-juju*.conf and 26-juju-*.conf) and the rsyslog
+certificates and logrotate files that go with it (under /etc/juju*/rsyslog,
+and in older environments /var/log/juju* or /var/lib/juju) are moved under
+/var/lib/juju/1.25-upgrade/rsyslog, and rsyslog is restarted. Everything moved
+is listed.
+
+`
+
+func newCleanupRsyslogCommand() cmd.Command {
+	command := &cleanupRsyslogCommand{}
+	command.remoteCommand = "cleanup-rsyslog-impl"
+	return command
+}
+
+type cleanupRsyslogCommand struct {
+	baseClientCommand
+}
+
+func (c *cleanupRsyslogCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "cleanup-rsyslog",
+		Args:    "<environment name>",
+		Purpose: "remove the 1.25 rsyslog forwarding from every machine",
+		Doc:     cleanupRsyslogDoc,
+	}
+}
+
+func (c *cleanupRsyslogCommand) Init(args []string) error {
+	args, err := c.baseClientCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cmd.CheckEmpty(args)
+}
+
+var cleanupRsyslogImplDoc = `
+
+cleanup-rsyslog-impl must be executed on an API server machine of a 1.25
+environment.
+
+The command will get a list of all the machines, and their addresses, and then
+ssh to all the machines to remove the 1.25 rsyslog configuration.
+
+`
+
+func newCleanupRsyslogImplCommand() cmd.Command {
+	return &cleanupRsyslogImplCommand{}
+}
+
+type cleanupRsyslogImplCommand struct {
+	baseRemoteCommand
+}
+
+func (c *cleanupRsyslogImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "cleanup-rsyslog-impl",
+		Purpose: "controller aspect of cleanup-rsyslog",
+		Doc:     cleanupRsyslogImplDoc,
+	}
+}
+
+func (c *cleanupRsyslogImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	defer st.Close()
+
+	machines, err := getMachines(st)
+	if err != nil {
+		return errors.Annotate(err, "unable to get addresses for machines")
+	}
+
+	start := time.Now()
+	cleanups := parseRsyslogCleanups(parallelCall(machines, cleanupRsyslogScript))
+	auditRsyslogCleanups(start, machines, cleanups)
+	writer := output.TabWriter(ctx.Stdout)
+	wrapper := output.Wrapper{writer}
+	wrapper.Println("MACHINE", "RESULT")
+	var failed []string
+	for _, cleanup := range cleanups {
+		wrapper.Println(cleanup.machine, cleanup.result)
+		if cleanup.failed {
+			failed = append(failed, cleanup.machine)
+		}
+	}
+	writer.Flush()
+	if len(failed) > 0 {
+		return errors.Errorf("rsyslog cleanup failed on machines: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// cleanupRsyslogScript moves the files written for rsyslog forwarding
+// by the 1.25 rsyslog worker and cloudconfig, and by older versions
+// before upgrade steps moved them, keeping their paths under the
+// backup directory. It prints a line for each file moved, and restarts
+// rsyslog if there were any.
+const cleanupRsyslogScript = `
+set -u
+backup=/var/lib/juju/1.25-upgrade/rsyslog
+removed=0
+for f in /etc/rsyslog.d/25-juju*.conf /etc/rsyslog.d/26-juju-*.conf \
+	/etc/juju*/rsyslog/*.pem /etc/juju*/rsyslog/logrotate.* \
+	/var/log/juju*/ca-cert.pem /var/log/juju*/rsyslog-*.pem /var/log/juju*/logrotate.* \
+	/var/lib/juju/ca-cert.pem /var/lib/juju/rsyslog-*.pem /var/lib/juju/logrotate.*
+do
+	[ -e "$f" ] || continue
+	mkdir -p "$backup$(dirname $f)" || exit 1
+	mv "$f" "$backup$f" || exit 1
+	echo "removed $f"
+	removed=1
+done
+if [ $removed = 1 ]; then
+	service rsyslog restart >/dev/null || exit 1
+	echo "restarted rsyslog"
+fi
+`
+
+type rsyslogCleanup struct {
+	machine string
+	result  string
+	failed  bool
+}
+
+// parseRsyslogCleanups turns the output of cleanupRsyslogScript on each
+// machine into a result line per action, sorted by machine.
+func parseRsyslogCleanups(results []DistResult) []rsyslogCleanup {
+	var cleanups []rsyslogCleanup
+	for _, r := range results {
+		lines := strings.Split(strings.TrimSpace(r.Stdout), "\n")
+		for _, line := range lines {
+			if line != "" {
+				cleanups = append(cleanups, rsyslogCleanup{machine: r.MachineID, result: line})
+			}
+		}
+		switch {
+		case r.Error != nil:
+			cleanups = append(cleanups, rsyslogCleanup{
+				machine: r.MachineID,
+				result:  "failed: " + r.Error.Error(),
+				failed:  true,
+			})
+		case r.Code != 0:
+			cleanups = append(cleanups, rsyslogCleanup{
+				machine: r.MachineID,
+				result:  "failed: " + strings.TrimSpace(r.Stderr),
+				failed:  true,
+			})
+		case strings.TrimSpace(r.Stdout) == "":
+			cleanups = append(cleanups, rsyslogCleanup{machine: r.MachineID, result: "nothing to remove"})
+		}
+	}
+	sort.Stable(rsyslogCleanupList(cleanups))
+	return cleanups
+}
+
+// auditRsyslogCleanups records the files moved by the cleanups in the
+// audit log.
+func auditRsyslogCleanups(start time.Time, machines []FlatMachine, cleanups []rsyslogCleanup) {
+	addresses := make(map[string]string)
+	for _, m := range machines {
+		addresses[m.ID] = m.Address
+	}
+	for _, cleanup := range cleanups {
+		if strings.HasPrefix(cleanup.result, "removed ") {
+			path := strings.TrimPrefix(cleanup.result, "removed ")
+			auditFileChange(start, addresses[cleanup.machine], cleanup.machine, path, nil)
+		}
+	}
+}
+
+type rsyslogCleanupList []rsyslogCleanup
+
+func (l rsyslogCleanupList) Len() int           { return len(l) }
+func (l rsyslogCleanupList) Less(i, j int) bool { return l[i].machine < l[j].machine }
+func (l rsyslogCleanupList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,40 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type rsyslogSuite struct{}
+
+var _ = gc.Suite(&rsyslogSuite{})
+
+func (*rsyslogSuite) TestParseRsyslogCleanups(c *gc.C) {
+	cleanups := parseRsyslogCleanups([]DistResult{{
+		MachineID: "1",
+		Stdout:    "removed /etc/rsyslog.d/25-juju.conf\nremoved /etc/juju/rsyslog/ca-cert.pem\nrestarted rsyslog\n",
+	}, {
+		MachineID: "0",
+	}, {
+		MachineID: "3",
+		Stdout:    "removed /etc/rsyslog.d/25-juju.conf\n",
+		Code:      1,
+		Stderr:    "rsyslog: unrecognized service\n",
+	}, {
+		MachineID: "2",
+		Error:     errors.New("connection refused"),
+	}})
+	c.Assert(cleanups, jc.DeepEquals, []rsyslogCleanup{
+		{machine: "0", result: "nothing to remove"},
+		{machine: "1", result: "removed /etc/rsyslog.d/25-juju.conf"},
+		{machine: "1", result: "removed /etc/juju/rsyslog/ca-cert.pem"},
+		{machine: "1", result: "restarted rsyslog"},
+		{machine: "2", result: "failed: connection refused", failed: true},
+		{machine: "3", result: "removed /etc/rsyslog.d/25-juju.conf"},
+		{machine: "3", result: "failed: rsyslog: unrecognized service", failed: true},
+	})
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju2/juju/osenv"
+)
+
+const runLogFile = "run.log"
+
+// localStateDir returns the directory on the local machine used to hold
+// everything the upgrade tool records about the named environment.
+func localStateDir(envName string) string {
+	return filepath.Join(osenv.JujuXDGDataHomeDir(), "1.25-upgrade", envName)
+}
+
+// appendRunLog adds a timestamped line to the run log for the named
+// environment.
+func appendRunLog(envName, format string, args ...interface{}) error {
+	dir := localStateDir(envName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, runLogFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Annotate(err, "opening run log")
+	}
+	defer f.Close()
+	line := fmt.Sprintf(format, args...)
+	_, err = fmt.Fprintf(f, "%s %s\n", time.Now().UTC().Format(time.RFC3339), line)
+	return errors.Annotate(err, "writing run log")
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju2/cmd/output"
+	"github.com/juju/1.25-upgrade/retag"
+)
+
+var migrateSecurityGroupsDoc = `
+The purpose of the migrate-security-groups command is to move the security
+groups of the migrated environment to the names the 2.x firewaller expects.
+1.25 names them after the environment (juju-<envname>, juju-<envname>-global
+and juju-<envname>-<machine id>); 2.x names them after the model (and, on
+openstack, controller) UUID, and without them creates duplicate groups or
+fails to open ports.
+
+On openstack the groups are renamed in place, so their rules and the
+instances they're attached to are unchanged.
+
+On ec2 groups can't be renamed, so a group with the 2.x name is created for
+each 1.25 group, with the same rules, and the 1.25 groups are left attached.
+Each instance still missing one of the 2.x groups fails, naming the groups it
+needs, so the command fails until they are attached alongside the 1.25 groups
+(aws ec2 modify-instance-attribute --groups); run the command again to check.
+The ports opened in the 1.25 groups stay open throughout.
+
+`
+
+func newMigrateSecurityGroupsCommand() cmd.Command {
+	return &migrateSecurityGroupsCommand{
+		baseClientCommand: baseClientCommand{
+			needsController: true,
+			remoteCommand:   "migrate-security-groups-impl",
+		},
+	}
+}
+
+type migrateSecurityGroupsCommand struct {
+	baseClientCommand
+}
+
+func (c *migrateSecurityGroupsCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "migrate-security-groups",
+		Args:    "<environment name> <controller name>",
+		Purpose: "move the environment's security groups to their 2.x names",
+		Doc:     migrateSecurityGroupsDoc,
+	}
+}
+
+func (c *migrateSecurityGroupsCommand) Init(args []string) error {
+	args, err := c.baseClientCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// The impl only needs the controller's UUID, not its API info.
+	details, err := c.controller.ClientStore().ControllerByName(c.controller.ControllerName())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.remoteArgs = details.ControllerUUID
+	c.passController = false
+	return cmd.CheckEmpty(args)
+}
+
+func (c *migrateSecurityGroupsCommand) Run(ctx *cmd.Context) error {
+	if err := c.baseClientCommand.Run(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	return appendRunLog(c.name, "migrate-security-groups: groups migrated for controller %s", c.remoteArgs)
+}
+
+var migrateSecurityGroupsImplDoc = `
+
+migrate-security-groups-impl must be executed on an API server machine of a
+1.25 environment.
+
+The command will read the environment config, and move the environment's
+security groups to the names used by the model for the controller with the
+UUID given.
+
+`
+
+func newMigrateSecurityGroupsImplCommand() cmd.Command {
+	return &migrateSecurityGroupsImplCommand{}
+}
+
+type migrateSecurityGroupsImplCommand struct {
+	baseRemoteCommand
+
+	controllerUUID string
+}
+
+func (c *migrateSecurityGroupsImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "migrate-security-groups-impl",
+		Args:    "<controller uuid>",
+		Purpose: "controller aspect of migrate-security-groups",
+		Doc:     migrateSecurityGroupsImplDoc,
+	}
+}
+
+func (c *migrateSecurityGroupsImplCommand) Init(args []string) error {
+	args, err := c.baseRemoteCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(args) == 0 {
+		return errors.New("no controller UUID specified")
+	}
+	c.controllerUUID, args = args[0], args[1:]
+	return cmd.CheckEmpty(args)
+}
+
+func (c *migrateSecurityGroupsImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	defer st.Close()
+
+	cfg, err := st.EnvironConfig()
+	if err != nil {
+		return errors.Annotate(err, "getting environment config")
+	}
+	migrator, err := retag.NewGroupMigrator(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	results, err := migrator.MigrateGroups(cfg.Name(), st.EnvironUUID(), c.controllerUUID)
+	if err != nil {
+		return errors.Annotate(err, "migrating security groups")
+	}
+
+	writer := output.TabWriter(ctx.Stdout)
+	wrapper := output.Wrapper{writer}
+	wrapper.Println("KIND", "ID", "RESULT")
+	for _, result := range results {
+		status := "migrated"
+		if result.Error != nil {
+			status = "failed: " + result.Error.Error()
+		}
+		wrapper.Println(result.Kind, result.ID, status)
+	}
+	writer.Flush()
+	if failed := retag.Failed(results); len(failed) > 0 {
+		return errors.Errorf("%d of %d resources not migrated", len(failed), len(results))
+	}
+	return nil
+}
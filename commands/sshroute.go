@@ -0,0 +1,395 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// sshConfigFile is the name of the SSH config file in the
+	// environment's local state directory.
+	sshConfigFile = "ssh.yaml"
+
+	// remoteSSHConfigFile is the name the SSH config is copied to on
+	// the state server, in remotePluginDir.
+	remoteSSHConfigFile = "1.25-upgrade-ssh.yaml"
+
+	defaultSSHUser = "ubuntu"
+)
+
+// sshConfig holds the options for the SSH connections made from the
+// state server to the environment's machines. Connections to the state
+// servers themselves are made with the operator's own SSH config.
+//
+// For example:
+//
+//	user: ubuntu
+//	port: 2222
+//	options: ["ConnectTimeout=10", "IdentityFile=/root/.ssh/bastion"]
+//	bastion: jump@bastion.example.com
+//	machine-bastions:
+//	  "0": ""
+//	  "4": jump@other-bastion.example.com:2200
+//	preferred-space: admin
+//	preferred-cidrs: ["10.20.0.0/16"]
+type sshConfig struct {
+	// User is the user to log in to the machines as.
+	User string `yaml:"user,omitempty"`
+
+	// Port is the port sshd listens on, on the machines.
+	Port int `yaml:"port,omitempty"`
+
+	// Options holds extra ssh options, as passed to -o.
+	Options []string `yaml:"options,omitempty"`
+
+	// Bastion is the host ([user@]host[:port]) that the connections to
+	// machines not in MachineBastions go through.
+	Bastion string `yaml:"bastion,omitempty"`
+
+	// MachineBastions holds the bastion for each of the given
+	// machines, overriding Bastion; "" connects directly.
+	MachineBastions map[string]string `yaml:"machine-bastions,omitempty"`
+
+	// DirectContainers connects to containers directly rather than
+	// through their host machine.
+	DirectContainers bool `yaml:"direct-containers,omitempty"`
+
+	// PreferredSpace is the space whose addresses are preferred when
+	// picking the address to reach a machine on.
+	PreferredSpace string `yaml:"preferred-space,omitempty"`
+
+	// PreferredCIDRs holds the networks whose addresses are preferred
+	// when picking the address to reach a machine on, before those in
+	// PreferredSpace.
+	PreferredCIDRs []string `yaml:"preferred-cidrs,omitempty"`
+}
+
+// Validate returns an error if the config isn't valid.
+func (cfg sshConfig) Validate() error {
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return errors.NotValidf("port %d", cfg.Port)
+	}
+	for _, opt := range cfg.Options {
+		fields := strings.FieldsFunc(opt, func(r rune) bool {
+			return r == '=' || r == ' '
+		})
+		if len(fields) == 0 {
+			return errors.NotValidf("empty option")
+		}
+		switch strings.ToLower(fields[0]) {
+		case "proxycommand", "proxyjump":
+			return errors.Errorf("option %q not allowed, use bastion instead", opt)
+		}
+	}
+	if cfg.Bastion != "" {
+		if _, err := parseSSHHop(cfg.Bastion); err != nil {
+			return errors.Annotate(err, "bastion")
+		}
+	}
+	for _, cidr := range cfg.PreferredCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.NotValidf("preferred CIDR %q", cidr)
+		}
+	}
+	for id, bastion := range cfg.MachineBastions {
+		if bastion == "" {
+			continue
+		}
+		if _, err := parseSSHHop(bastion); err != nil {
+			return errors.Annotatef(err, "bastion for machine %s", id)
+		}
+	}
+	return nil
+}
+
+// user returns the user to log in to the machines as.
+func (cfg sshConfig) user() string {
+	if cfg.User != "" {
+		return cfg.User
+	}
+	return defaultSSHUser
+}
+
+// bastionFor returns the bastion for the machine with the given id.
+func (cfg sshConfig) bastionFor(id string) string {
+	if bastion, ok := cfg.MachineBastions[id]; ok {
+		return bastion
+	}
+	return cfg.Bastion
+}
+
+// reachedDirectly returns whether the state server connects to the
+// machine directly, rather than through a bastion or its host.
+func (cfg sshConfig) reachedDirectly(id, parentID string) bool {
+	if parentID != "" && !cfg.DirectContainers {
+		return false
+	}
+	return cfg.bastionFor(id) == ""
+}
+
+// readSSHConfig reads and validates the SSH config in the given file.
+// A missing file is an empty config.
+func readSSHConfig(path string) (sshConfig, error) {
+	var cfg sshConfig
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, errors.Trace(err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, errors.Annotatef(err, "parsing %s", path)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, errors.Annotatef(err, "validating %s", path)
+	}
+	return cfg, nil
+}
+
+var (
+	machineSSHConfigOnce   sync.Once
+	cachedMachineSSHConfig sshConfig
+	machineSSHConfigErr    error
+)
+
+// machineSSHConfig returns the SSH config copied to the state server
+// by the client.
+func machineSSHConfig() (sshConfig, error) {
+	machineSSHConfigOnce.Do(func() {
+		cachedMachineSSHConfig, machineSSHConfigErr = readSSHConfig(remoteSSHConfigFile)
+	})
+	return cachedMachineSSHConfig, machineSSHConfigErr
+}
+
+// installSSHConfig copies the environment's SSH config, if it has one,
+// to the state server with the given address, or removes a copy left
+// by an earlier run if it doesn't.
+func (c *baseClientCommand) installSSHConfig(ctx *cmd.Context, address string) error {
+	localPath := filepath.Join(localStateDir(c.name), sshConfigFile)
+	if _, err := readSSHConfig(localPath); err != nil {
+		return errors.Trace(err)
+	}
+	remotePath := path.Join(remotePluginDir, remoteSSHConfigFile)
+	if _, err := os.Stat(localPath); os.IsNotExist(err) {
+		_, err := runViaSSH(address, "rm -f "+remotePath, "")
+		return errors.Annotate(err, "removing SSH config")
+	}
+	ctx.Verbosef("copying %s to the state server", localPath)
+	err := installRemoteFile(address, localPath, remotePath, 0600)
+	return errors.Annotate(err, "copying SSH config")
+}
+
+// sshHop is a host that SSH connections to a machine go through.
+type sshHop struct {
+	User string
+	Host string
+	Port int `json:",omitempty"`
+}
+
+// String returns the hop as [user@]host[:port].
+func (h sshHop) String() string {
+	s := h.Host
+	if h.Port != 0 {
+		s = net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+	}
+	if h.User != "" {
+		s = h.User + "@" + s
+	}
+	return s
+}
+
+// userHost returns the hop as [user@]host, as ssh takes it.
+func (h sshHop) userHost() string {
+	if h.User == "" {
+		return h.Host
+	}
+	return h.User + "@" + h.Host
+}
+
+// parseSSHHop parses a [user@]host[:port] string; IPv6 addresses with
+// a port must be in brackets.
+func parseSSHHop(s string) (sshHop, error) {
+	var hop sshHop
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		hop.User, s = s[:i], s[i+1:]
+	}
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return sshHop{}, errors.NotValidf("port %q", port)
+		}
+		hop.Host, hop.Port = host, p
+	} else {
+		hop.Host = strings.Trim(s, "[]")
+	}
+	if hop.Host == "" {
+		return sshHop{}, errors.NotValidf("empty host")
+	}
+	return hop, nil
+}
+
+// setSSHRoutes sets the route of each machine: a container goes
+// through its host, unless DirectContainers is set, and other machines
+// through their bastion, if they have one. A container whose host
+// can't be reached can't be reached either.
+func setSSHRoutes(machines []FlatMachine, cfg sshConfig) error {
+	byID := make(map[string]*FlatMachine)
+	for i := range machines {
+		byID[machines[i].ID] = &machines[i]
+	}
+	var route func(m *FlatMachine) ([]sshHop, error)
+	route = func(m *FlatMachine) ([]sshHop, error) {
+		if m.ParentID != "" && !cfg.DirectContainers {
+			parent, ok := byID[m.ParentID]
+			if !ok {
+				return nil, errors.NotFoundf("host machine %q of %q", m.ParentID, m.ID)
+			}
+			parentRoute, err := route(parent)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if parent.Reachability != reachable && m.Reachability == reachable {
+				m.Reachability = unreachable
+				m.AddressReason = fmt.Sprintf("host machine %s %s", parent.ID, parent.Reachability)
+			}
+			hop := sshHop{User: cfg.user(), Host: parent.Address, Port: cfg.Port}
+			return append(parentRoute, hop), nil
+		}
+		bastion := cfg.bastionFor(m.ID)
+		if bastion == "" {
+			return nil, nil
+		}
+		hop, err := parseSSHHop(bastion)
+		if err != nil {
+			return nil, errors.Annotatef(err, "bastion for machine %s", m.ID)
+		}
+		return []sshHop{hop}, nil
+	}
+	for i := range machines {
+		r, err := route(&machines[i])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		machines[i].Route = r
+	}
+	return nil
+}
+
+// sshArgs returns the arguments to ssh to connect to the target
+// through the given route.
+func sshArgs(cfg sshConfig, identity string, route []sshHop, target sshHop) []string {
+	args := sshCommonArgs(cfg, identity, target)
+	if len(route) > 0 {
+		args = append(args, "-o", "ProxyCommand="+sshProxyCommand(cfg, identity, route))
+	}
+	return append(args, target.userHost())
+}
+
+func sshCommonArgs(cfg sshConfig, identity string, target sshHop) []string {
+	args := []string{
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "PasswordAuthentication=no",
+		// Keep long running scripts' connections alive.
+		"-o", "ServerAliveInterval=30",
+	}
+	if identity != "" {
+		args = append(args, "-i", identity)
+	}
+	for _, opt := range cfg.Options {
+		args = append(args, "-o", opt)
+	}
+	if target.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(target.Port))
+	}
+	return args
+}
+
+// sshProxyCommand returns the ProxyCommand that connects through the
+// last hop of the route, itself reached through the rest of the route.
+// OpenSSH on trusty and xenial predates ProxyJump, so the hops are
+// nested ProxyCommands; ssh expands the %h and %p of each level, and
+// turns %% into %, so the inner levels' are escaped.
+func sshProxyCommand(cfg sshConfig, identity string, route []sshHop) string {
+	last := route[len(route)-1]
+	args := sshCommonArgs(cfg, identity, last)
+	if len(route) > 1 {
+		inner := sshProxyCommand(cfg, identity, route[:len(route)-1])
+		args = append(args, "-o", "ProxyCommand="+strings.Replace(inner, "%", "%%", -1))
+	}
+	args = append(args, "-W", "%h:%p", last.userHost())
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = utils.ShQuote(arg)
+	}
+	return "ssh " + strings.Join(quoted, " ")
+}
+
+// sshFailureCode is the exit code of ssh when it fails, rather than the
+// remote command.
+const sshFailureCode = 255
+
+// runOnMachine runs script as root on the machine, through its route,
+// using the state server's system identity, and records it in the audit
+// log.
+func runOnMachine(machine FlatMachine, script string) (RunResult, error) {
+	return runOnMachineWithStdin(machine, script, nil)
+}
+
+// runOnMachineWithStdin is like runOnMachine, with stdin as the
+// script's standard input.
+func runOnMachineWithStdin(machine FlatMachine, script string, stdin io.Reader) (RunResult, error) {
+	start := time.Now()
+	result, err := execOnMachine(machine, script, stdin)
+	auditRun(start, machine.Address, machine.ID, script, result, err)
+	return result, err
+}
+
+func execOnMachine(machine FlatMachine, script string, stdin io.Reader) (RunResult, error) {
+	cfg, err := machineSSHConfig()
+	if err != nil {
+		return RunResult{}, errors.Trace(err)
+	}
+	target := sshHop{User: cfg.user(), Host: machine.Address, Port: cfg.Port}
+	if len(machine.Route) > 0 {
+		logger.Debugf("connecting to machine %s through %v", machine.ID, machine.Route)
+	}
+	args := sshArgs(cfg, systemIdentity, machine.Route, target)
+	args = append(args, "sudo", "-n", "bash", "-c "+utils.ShQuote(script))
+	command := exec.Command("ssh", args...)
+	command.Stdin = stdin
+	var stdoutBuf, stderrBuf bytes.Buffer
+	command.Stdout = &stdoutBuf
+	command.Stderr = &stderrBuf
+	err = command.Run()
+	result := RunResult{
+		Stdout: stdoutBuf.String(),
+		Stderr: stderrBuf.String(),
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			result.Code = status.ExitStatus()
+			return result, nil
+		}
+	}
+	return result, errors.Trace(err)
+}
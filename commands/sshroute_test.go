@@ -0,0 +1,131 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type sshRouteSuite struct{}
+
+var _ = gc.Suite(&sshRouteSuite{})
+
+func (*sshRouteSuite) TestParseSSHHop(c *gc.C) {
+	for _, test := range []struct {
+		in     string
+		expect sshHop
+	}{
+		{"bastion", sshHop{Host: "bastion"}},
+		{"jump@bastion", sshHop{User: "jump", Host: "bastion"}},
+		{"jump@bastion:2200", sshHop{User: "jump", Host: "bastion", Port: 2200}},
+		{"[fd00::1]:22", sshHop{Host: "fd00::1", Port: 22}},
+		{"fd00::1", sshHop{Host: "fd00::1"}},
+	} {
+		hop, err := parseSSHHop(test.in)
+		c.Check(err, jc.ErrorIsNil)
+		c.Check(hop, jc.DeepEquals, test.expect)
+	}
+	_, err := parseSSHHop("jump@")
+	c.Check(err, gc.ErrorMatches, "empty host not valid")
+	_, err = parseSSHHop("bastion:0")
+	c.Check(err, gc.ErrorMatches, `port "0" not valid`)
+}
+
+func (*sshRouteSuite) TestValidateRejectsProxyOptions(c *gc.C) {
+	cfg := sshConfig{Options: []string{"ConnectTimeout=10", "ProxyCommand nc %h %p"}}
+	c.Assert(cfg.Validate(), gc.ErrorMatches, `option "ProxyCommand nc %h %p" not allowed, use bastion instead`)
+	cfg = sshConfig{Options: []string{"proxyjump=bastion"}}
+	c.Assert(cfg.Validate(), gc.ErrorMatches, `option "proxyjump=bastion" not allowed, use bastion instead`)
+}
+
+func (*sshRouteSuite) TestValidateBastion(c *gc.C) {
+	cfg := sshConfig{MachineBastions: map[string]string{"1": "jump@"}}
+	c.Assert(cfg.Validate(), gc.ErrorMatches, "bastion for machine 1: empty host not valid")
+}
+
+func (*sshRouteSuite) TestSetSSHRoutes(c *gc.C) {
+	machines := []FlatMachine{
+		{ID: "0", Address: "10.0.0.1"},
+		{ID: "0/lxc/0", Address: "10.0.3.1", ParentID: "0"},
+		{ID: "0/lxc/0/kvm/1", Address: "10.0.4.1", ParentID: "0/lxc/0"},
+		{ID: "1", Address: "10.0.0.2"},
+	}
+	cfg := sshConfig{
+		Port:            2222,
+		Bastion:         "jump@bastion",
+		MachineBastions: map[string]string{"1": ""},
+	}
+	err := setSSHRoutes(machines, cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	bastion := sshHop{User: "jump", Host: "bastion"}
+	c.Check(machines[0].Route, jc.DeepEquals, []sshHop{bastion})
+	c.Check(machines[1].Route, jc.DeepEquals, []sshHop{
+		bastion,
+		{User: "ubuntu", Host: "10.0.0.1", Port: 2222},
+	})
+	c.Check(machines[2].Route, jc.DeepEquals, []sshHop{
+		bastion,
+		{User: "ubuntu", Host: "10.0.0.1", Port: 2222},
+		{User: "ubuntu", Host: "10.0.3.1", Port: 2222},
+	})
+	c.Check(machines[3].Route, gc.HasLen, 0)
+}
+
+func (*sshRouteSuite) TestSetSSHRoutesDirectContainers(c *gc.C) {
+	machines := []FlatMachine{
+		{ID: "0", Address: "10.0.0.1"},
+		{ID: "0/lxc/0", Address: "10.0.3.1", ParentID: "0"},
+	}
+	err := setSSHRoutes(machines, sshConfig{DirectContainers: true})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(machines[0].Route, gc.HasLen, 0)
+	c.Check(machines[1].Route, gc.HasLen, 0)
+}
+
+func (*sshRouteSuite) TestSetSSHRoutesMissingHost(c *gc.C) {
+	machines := []FlatMachine{{ID: "0/lxc/0", Address: "10.0.3.1", ParentID: "0"}}
+	err := setSSHRoutes(machines, sshConfig{})
+	c.Assert(err, gc.ErrorMatches, `host machine "0" of "0/lxc/0" not found`)
+}
+
+func (*sshRouteSuite) TestSSHArgsDirect(c *gc.C) {
+	cfg := sshConfig{Options: []string{"ConnectTimeout=10"}}
+	args := sshArgs(cfg, "/id", nil, sshHop{User: "ubuntu", Host: "10.0.0.1", Port: 2222})
+	c.Assert(args, jc.DeepEquals, []string{
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "PasswordAuthentication=no",
+		"-o", "ServerAliveInterval=30",
+		"-i", "/id",
+		"-o", "ConnectTimeout=10",
+		"-p", "2222",
+		"ubuntu@10.0.0.1",
+	})
+}
+
+func (*sshRouteSuite) TestSSHProxyCommandNested(c *gc.C) {
+	route := []sshHop{
+		{User: "jump", Host: "bastion"},
+		{User: "ubuntu", Host: "10.0.0.1"},
+	}
+	command := sshProxyCommand(sshConfig{}, "", route)
+	c.Assert(command, gc.Equals, "ssh "+
+		"'-o' 'StrictHostKeyChecking=no' '-o' 'PasswordAuthentication=no' '-o' 'ServerAliveInterval=30' "+
+		"'-o' 'ProxyCommand=ssh '\"'\"'-o'\"'\"' '\"'\"'StrictHostKeyChecking=no'\"'\"' "+
+		"'\"'\"'-o'\"'\"' '\"'\"'PasswordAuthentication=no'\"'\"' "+
+		"'\"'\"'-o'\"'\"' '\"'\"'ServerAliveInterval=30'\"'\"' "+
+		"'\"'\"'-W'\"'\"' '\"'\"'%%h:%%p'\"'\"' '\"'\"'jump@bastion'\"'\"'' "+
+		"'-W' '%h:%p' 'ubuntu@10.0.0.1'")
+}
+
+func (*sshRouteSuite) TestSetSSHRoutesUnreachableHost(c *gc.C) {
+	machines := []FlatMachine{
+		{ID: "0", Reachability: unreachable, AddressReason: "no address reachable"},
+		{ID: "0/lxc/0", Address: "10.0.3.1", ParentID: "0", Reachability: reachable},
+	}
+	err := setSSHRoutes(machines, sshConfig{})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(machines[1].Reachability, gc.Equals, unreachable)
+	c.Check(machines[1].AddressReason, gc.Equals, "host machine 0 unreachable")
+}
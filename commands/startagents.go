@@ -4,42 +4,140 @@
 package commands
 
 import (
+	"time"
+
 	"github.com/juju/cmd"
 	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"github.com/juju/utils/set"
 )
 
 var startAgentsDoc = ` 
 The purpose of the start-agents command is to start all the agents of a 1.25
 environment. The agents may be running the 1.25 binary, or a 2.x binary.
+
+With --wait, the controller name must also be given. Once the agents are
+started, the command watches the migrated model on that controller until
+every machine agent is started and every unit agent is idle, all running the
+controller's version. If that hasn't happened within --timeout, the agents
+still outstanding are listed along with their last status.
+
+The agents can be started a few machines at a time: --canary names machines
+to start and verify first, and --batch sets how many of the remaining
+machines are started together. Each batch is verified as for --wait before
+moving on, so these options also need the controller name. The rollout
+pauses if a canary fails, or once more than --max-failures machines have
+failed. Progress is saved locally, so running the command again carries on
+from where it stopped.
+
+--machines, --applications and --exclude restrict the command to some of the
+machines; see agent-status. Machines that can't be reached are handled as for
+stop-agents, with --allow-unreachable.
 `
 
 func newStartAgentsCommand() cmd.Command {
 	command := &startAgentsCommand{}
 	command.remoteCommand = "start-agents-impl"
+	command.checkReachable = true
 	return command
 }
 
 type startAgentsCommand struct {
 	baseClientCommand
+
+	wait    bool
+	timeout time.Duration
+	rollout rolloutOptions
 }
 
 func (c *startAgentsCommand) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "start-agents",
-		Args:    "<environment name>",
+		Args:    "<environment name> [<controller name>]",
 		Purpose: "start all the agents for the specified environment",
 		Doc:     startAgentsDoc,
 	}
 }
 
+func (c *startAgentsCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.BoolVar(&c.wait, "wait", false, "wait for the agents to connect to the controller")
+	f.DurationVar(&c.timeout, "timeout", 10*time.Minute, "how long to wait for the agents")
+	c.rollout.setFlags(f)
+	c.setFilterFlags(f)
+	c.setUnreachableFlags(f)
+}
+
 func (c *startAgentsCommand) Init(args []string) error {
+	if err := c.rollout.validate(); err != nil {
+		return errors.Trace(err)
+	}
+	// Waiting needs to talk to the controller the model was imported into.
+	c.needsController = c.wait || c.rollout.enabled()
 	args, err := c.baseClientCommand.init(args)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// start-agents-impl doesn't use the controller details itself.
+	c.passController = false
 	return cmd.CheckEmpty(args)
 }
 
+func (c *startAgentsCommand) Run(ctx *cmd.Context) error {
+	if c.rollout.enabled() {
+		return errors.Trace(c.runRollout(ctx, c.rollout, c.verifyBatch))
+	}
+	if err := c.baseClientCommand.Run(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	if !c.wait {
+		return nil
+	}
+	pending, err := c.waitForAgents(ctx, c.timeout, c.waitMachines())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(pending) > 0 {
+		writePendingAgents(ctx, pending)
+		return errors.Errorf("%d agents not started after %s", len(pending), c.timeout)
+	}
+	return nil
+}
+
+// waitMachines returns the machines --wait waits for: those the agents
+// were started on, leaving out any that were filtered out or skipped as
+// unreachable, or nil for all of them if none were selected.
+func (c *startAgentsCommand) waitMachines() set.Strings {
+	if c.selected == nil {
+		return nil
+	}
+	return set.NewStrings(c.selected...)
+}
+
+// verifyBatch waits for the agents on the batch's machines to connect,
+// and returns the machines with agents that didn't.
+func (c *startAgentsCommand) verifyBatch(ctx *cmd.Context, batch []string) ([]string, error) {
+	return c.verifyAgents(ctx, c.timeout, batch)
+}
+
+// verifyAgents waits up to timeout for the agents on the machines to
+// connect to the controller, and returns the machines with agents that
+// didn't.
+func (c *baseClientCommand) verifyAgents(ctx *cmd.Context, timeout time.Duration, machines []string) ([]string, error) {
+	pending, err := c.waitForAgents(ctx, timeout, set.NewStrings(machines...))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	writePendingAgents(ctx, pending)
+	failed := set.NewStrings()
+	for _, agent := range pending {
+		failed.Add(agent.machine)
+	}
+	return failed.SortedValues(), nil
+}
+
 var startAgentsImplDoc = `
 
 start-agents-impl must be executed on an API server machine of a 1.25
@@ -58,6 +156,10 @@ type startAgentsImplCommand struct {
 	baseRemoteCommand
 }
 
+func (c *startAgentsImplCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.setMachineFlags(f)
+}
+
 func (c *startAgentsImplCommand) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "start-agents-impl",
@@ -76,7 +178,7 @@ func (c *startAgentsImplCommand) Run(ctx *cmd.Context) error {
 	// Here we always use the 1.25 environment to get all of the machine
 	// addresses. We then use those to ssh into every one of those machine
 	// and run the service status script against all the agents.
-	machines, err := getMachines(st)
+	machines, err := c.selectedMachines(st)
 	if err != nil {
 		return errors.Annotate(err, "unable to get addresses for machines")
 	}
@@ -0,0 +1,24 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type startAgentsSuite struct{}
+
+var _ = gc.Suite(&startAgentsSuite{})
+
+func (*startAgentsSuite) TestWaitMachines(c *gc.C) {
+	command := &startAgentsCommand{}
+	c.Assert(command.waitMachines(), gc.IsNil)
+
+	// Machines skipped as unreachable, with no filter given, aren't
+	// waited for.
+	command.allowUnreachable = true
+	command.selected = []string{"0", "2"}
+	c.Assert(command.waitMachines().SortedValues(), jc.DeepEquals, []string{"0", "2"})
+}
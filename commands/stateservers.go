@@ -0,0 +1,193 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju1/mongo"
+)
+
+// stateServer is a 1.25 state server machine, as reported by
+// state-servers-impl.
+type stateServer struct {
+	ID        string   `json:"id"`
+	Addresses []string `json:"addresses"`
+	Primary   bool     `json:"primary"`
+}
+
+// stateServerHosts returns the hosts of the given API addresses, in
+// order and without duplicates.
+func stateServerHosts(addresses []string) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, addr := range addresses {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// selectStateServer picks the state server that remote commands run
+// on. In an HA environment that's the one hosting the mongo primary,
+// so that writes don't depend on the replica set's links. Each API
+// address is tried in turn until one answers; if the primary can't be
+// reached, the commands run on the state server that answered, as
+// state connections follow the primary anyway.
+func (c *baseClientCommand) selectStateServer(ctx *cmd.Context) error {
+	var failures []string
+	for _, addr := range c.addresses {
+		if err := checkUpdatePlugin(ctx, c.plugin, addr); err != nil {
+			ctx.Infof("state server %s unavailable: %v", addr, err)
+			failures = append(failures, addr+": "+err.Error())
+			continue
+		}
+		servers, err := c.listStateServers(ctx, addr)
+		if err != nil {
+			ctx.Infof("state server %s unavailable: %v", addr, err)
+			failures = append(failures, addr+": "+err.Error())
+			continue
+		}
+		for _, candidate := range primaryAddresses(servers, c.addresses) {
+			if candidate != addr {
+				if err := checkUpdatePlugin(ctx, c.plugin, candidate); err != nil {
+					ctx.Verbosef("mongo primary address %s unavailable: %v", candidate, err)
+					continue
+				}
+			}
+			ctx.Verbosef("using state server %s (mongo primary)", candidate)
+			c.address = candidate
+			return nil
+		}
+		ctx.Infof("mongo primary unavailable, using state server %s", addr)
+		c.address = addr
+		return nil
+	}
+	if len(failures) == 0 {
+		return errors.New("no state server addresses")
+	}
+	return errors.Errorf("no state server available: %s", strings.Join(failures, "; "))
+}
+
+// listStateServers runs state-servers-impl on the state server with
+// the given address.
+func (c *baseClientCommand) listStateServers(ctx *cmd.Context, address string) ([]stateServer, error) {
+	result, err := c.runPlugin(address, nil, "state-servers-impl")
+	if err != nil {
+		return nil, errors.Annotate(err, "running state-servers-impl via SSH")
+	}
+	if result.Code != 0 {
+		return nil, errors.Errorf("listing state servers failed: %s", result.Stderr)
+	}
+	var servers []stateServer
+	if err := json.Unmarshal([]byte(result.Stdout), &servers); err != nil {
+		return nil, errors.Annotate(err, "unmarshalling state servers")
+	}
+	return servers, nil
+}
+
+// primaryAddresses returns the addresses of the state server hosting
+// the mongo primary, those among the known API addresses first as
+// they're the most likely to be reachable from here.
+func primaryAddresses(servers []stateServer, known []string) []string {
+	var primary *stateServer
+	for i := range servers {
+		if servers[i].Primary {
+			primary = &servers[i]
+			break
+		}
+	}
+	if primary == nil {
+		return nil
+	}
+	has := make(map[string]bool)
+	for _, addr := range primary.Addresses {
+		has[addr] = true
+	}
+	var result []string
+	for _, addr := range known {
+		if has[addr] {
+			result = append(result, addr)
+			delete(has, addr)
+		}
+	}
+	for _, addr := range primary.Addresses {
+		if has[addr] {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+var stateServersImplDoc = `
+
+state-servers-impl must be executed on an API server machine of a 1.25
+environment.
+
+The command writes the state server machines of the environment as JSON, with
+their addresses and whether they host the mongo primary.
+
+`
+
+func newStateServersImplCommand() cmd.Command {
+	return &stateServersImplCommand{}
+}
+
+type stateServersImplCommand struct {
+	baseRemoteCommand
+}
+
+func (c *stateServersImplCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "state-servers-impl",
+		Purpose: "list the state servers of the environment",
+		Doc:     stateServersImplDoc,
+	}
+}
+
+func (c *stateServersImplCommand) Run(ctx *cmd.Context) error {
+	st, err := c.getState(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting state")
+	}
+	defer st.Close()
+
+	info, err := st.StateServerInfo()
+	if err != nil {
+		return errors.Annotate(err, "getting state server info")
+	}
+	var servers []stateServer
+	for _, id := range info.MachineIds {
+		m, err := st.Machine(id)
+		if err != nil {
+			return errors.Annotatef(err, "getting machine %s", id)
+		}
+		primary, err := mongo.IsMaster(st.MongoSession(), m)
+		if err != nil {
+			return errors.Annotatef(err, "checking whether machine %s is the mongo primary", id)
+		}
+		server := stateServer{ID: id, Primary: primary}
+		for _, addr := range m.Addresses() {
+			server.Addresses = append(server.Addresses, addr.Value)
+		}
+		servers = append(servers, server)
+	}
+	bytes, err := json.Marshal(servers)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = ctx.GetStdout().Write(bytes)
+	return errors.Annotate(err, "writing state servers")
+}
@@ -0,0 +1,35 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type stateServersSuite struct{}
+
+var _ = gc.Suite(&stateServersSuite{})
+
+func (*stateServersSuite) TestStateServerHosts(c *gc.C) {
+	hosts := stateServerHosts([]string{"10.0.0.1:17070", "[fd00::1]:17070", "10.0.0.1:17070", "10.0.0.2"})
+	c.Assert(hosts, jc.DeepEquals, []string{"10.0.0.1", "fd00::1", "10.0.0.2"})
+}
+
+func (*stateServersSuite) TestPrimaryAddressesKnownFirst(c *gc.C) {
+	servers := []stateServer{
+		{ID: "0", Addresses: []string{"10.0.0.1", "54.0.0.1"}},
+		{ID: "1", Addresses: []string{"10.0.0.2", "54.0.0.2"}, Primary: true},
+		{ID: "2", Addresses: []string{"10.0.0.3", "54.0.0.3"}},
+	}
+	addrs := primaryAddresses(servers, []string{"54.0.0.1", "54.0.0.2", "54.0.0.3"})
+	c.Assert(addrs, jc.DeepEquals, []string{"54.0.0.2", "10.0.0.2"})
+}
+
+func (*stateServersSuite) TestPrimaryAddressesNoPrimary(c *gc.C) {
+	servers := []stateServer{
+		{ID: "0", Addresses: []string{"10.0.0.1"}},
+	}
+	c.Assert(primaryAddresses(servers, []string{"10.0.0.1"}), gc.HasLen, 0)
+}
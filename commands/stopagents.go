@@ -4,23 +4,56 @@
 package commands
 
 import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
 	"github.com/juju/cmd"
 	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+
+	"github.com/juju/1.25-upgrade/uniterstate"
 )
 
 var stopAgentsDoc = ` 
 The purpose of the stop-agents command is to stop all the agents of a 1.25
 environment. The agents may be running the 1.25 binary, or a 2.x binary.
+
+Before any agent is stopped, a backup of the 1.25 state server is taken and
+downloaded into --backup-dir, and its checksum verified against the backup
+metadata. The location of the backup is recorded in the local run log. Use
+--skip-backup only if a verified restore point already exists.
+
+Once the agents are stopped, the uniter state of every unit is checked. If
+any unit is part way through an operation (running or failing a hook, running
+an action or upgrading its charm) the units are listed and the command fails,
+so that the operations can be finished or resolved with the 1.25 agents
+before the upgrade goes on: start the agents, deal with the units and stop
+the agents again. With --force the units are only listed, and upgrade-agents
+--force carries each operation over to the 2.x agent.
+
+--machines, --applications and --exclude restrict the command to some of the
+machines; see agent-status.
+
+If any machine can't be reached, the command stops before acting on any of
+them. With --allow-unreachable those machines are skipped instead, and
+recorded so that retry can stop their agents once they're back.
 `
 
 func newStopAgentsCommand() cmd.Command {
 	command := &stopAgentsCommand{}
 	command.remoteCommand = "stop-agents-impl"
+	command.checkReachable = true
 	return command
 }
 
 type stopAgentsCommand struct {
 	baseClientCommand
+
+	skipBackup bool
+	backupDir  string
+	force      bool
 }
 
 func (c *stopAgentsCommand) Info() *cmd.Info {
@@ -32,21 +65,53 @@ func (c *stopAgentsCommand) Info() *cmd.Info {
 	}
 }
 
+func (c *stopAgentsCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.BoolVar(&c.skipBackup, "skip-backup", false, "do not back up the 1.25 state server first")
+	f.StringVar(&c.backupDir, "backup-dir", "", "local directory for the backup archive")
+	f.BoolVar(&c.force, "force", false, "don't fail if units are part way through an operation")
+	c.setFilterFlags(f)
+	c.setUnreachableFlags(f)
+}
+
 func (c *stopAgentsCommand) Init(args []string) error {
 	args, err := c.baseClientCommand.init(args)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if c.backupDir == "" {
+		c.backupDir = filepath.Join(localStateDir(c.name), "backups")
+	}
+	if c.force {
+		c.remoteArgs = "--force"
+	}
 	return cmd.CheckEmpty(args)
 }
 
+func (c *stopAgentsCommand) Run(ctx *cmd.Context) error {
+	if c.skipBackup {
+		ctx.Infof("skipping backup of the 1.25 state server")
+		if err := appendRunLog(c.name, "stop-agents: backup skipped"); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		path, err := c.createBackup(ctx, c.backupDir)
+		if err != nil {
+			return errors.Annotate(err, "backing up 1.25 state server")
+		}
+		ctx.Infof("backup saved to %s", path)
+	}
+	return c.baseClientCommand.Run(ctx)
+}
+
 var stopAgentsImplDoc = `
 
 stop-agents-impl must be executed on an API server machine of a 1.25
 environment.
 
 The command will get a list of all the machines, and their addresses, and then
-ssh to all the machines to stop the various agents on those machines.
+ssh to all the machines to stop the various agents on those machines. It then
+reads the uniter state of the units on those machines, and fails if any are
+part way through an operation, unless --force is given.
 
 `
 
@@ -56,6 +121,13 @@ func newStopAgentsImplCommand() cmd.Command {
 
 type stopAgentsImplCommand struct {
 	baseRemoteCommand
+
+	force bool
+}
+
+func (c *stopAgentsImplCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.setMachineFlags(f)
+	f.BoolVar(&c.force, "force", false, "don't fail if units are part way through an operation")
 }
 
 func (c *stopAgentsImplCommand) Info() *cmd.Info {
@@ -76,7 +148,7 @@ func (c *stopAgentsImplCommand) Run(ctx *cmd.Context) error {
 	// Here we always use the 1.25 environment to get all of the machine
 	// addresses. We then use those to ssh into every one of those machine
 	// and run the service status script against all the agents.
-	machines, err := getMachines(st)
+	machines, err := c.selectedMachines(st)
 	if err != nil {
 		return errors.Annotate(err, "unable to get addresses for machines")
 	}
@@ -87,9 +159,104 @@ func (c *stopAgentsImplCommand) Run(ctx *cmd.Context) error {
 	// the data is passed back to the caller.
 	serviceStatus(ctx, machines)
 
+	// Now that the agents are stopped, their units' state won't change
+	// under us.
+	busy := busyUnits(parallelCall(machines, unitStateScript))
+	for _, unit := range busy {
+		fmt.Fprintf(ctx.Stdout, "%s: %s\n", unit.unit, unit.operation)
+	}
+	if len(busy) > 0 && !c.force {
+		var units []string
+		for _, unit := range busy {
+			units = append(units, unit.unit)
+		}
+		return errors.Errorf("units part way through an operation: %s; "+
+			"start the agents to finish or resolve them and stop the agents again, or use --force",
+			strings.Join(units, ", "))
+	}
 	return nil
 }
 
+// unitStateScript prints the 1.25 operation state file of each unit on
+// a machine, after a line naming the unit. The copy kept by
+// agent-config-convert-impl is printed if the unit has been converted.
+const unitStateScript = `
+set -u
+cd /var/lib/juju/agents || exit 0
+for agent in unit-*
+do
+	state=$agent/state/uniter
+	[ -f $state.1.25 ] && state=$state.1.25
+	[ -f $state ] || continue
+	echo "-- unit-state $agent --"
+	cat $state
+done
+`
+
+const unitStatePrefix = "-- unit-state "
+
+type busyUnit struct {
+	unit      string
+	operation string
+}
+
+// busyUnits returns the units that unitStateScript reported to be part
+// way through an operation, sorted by name. A unit whose state can't
+// be read, or a machine that couldn't be checked, counts as busy.
+func busyUnits(results []DistResult) []busyUnit {
+	var busy []busyUnit
+	for _, r := range results {
+		if r.Error != nil || r.Code != 0 {
+			busy = append(busy, busyUnit{
+				unit:      "machine-" + r.MachineID,
+				operation: fmt.Sprintf("unit state not checked: %s", resultError(r)),
+			})
+			continue
+		}
+		for unit, data := range splitUnitStates(r.Stdout) {
+			st, err := uniterstate.ParseState([]byte(data))
+			if err != nil {
+				busy = append(busy, busyUnit{unit, err.Error()})
+			} else if !uniterstate.Idle(st) {
+				busy = append(busy, busyUnit{unit, uniterstate.Describe(st)})
+			}
+		}
+	}
+	sort.Sort(busyUnitList(busy))
+	return busy
+}
+
+type busyUnitList []busyUnit
+
+func (l busyUnitList) Len() int           { return len(l) }
+func (l busyUnitList) Less(i, j int) bool { return l[i].unit < l[j].unit }
+func (l busyUnitList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// splitUnitStates returns the state file of each unit in the output of
+// unitStateScript, by unit agent name.
+func splitUnitStates(stdout string) map[string]string {
+	states := make(map[string]string)
+	var unit string
+	for _, line := range strings.SplitAfter(stdout, "\n") {
+		if strings.HasPrefix(line, unitStatePrefix) {
+			unit = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(line), unitStatePrefix), " --")
+			states[unit] = ""
+			continue
+		}
+		if unit != "" {
+			states[unit] += line
+		}
+	}
+	return states
+}
+
+func resultError(r DistResult) string {
+	if r.Error != nil {
+		return r.Error.Error()
+	}
+	return fmt.Sprintf("exit code %d: %s", r.Code, strings.TrimSpace(r.Stderr))
+}
+
 func serviceCommand(ctx *cmd.Context, machines []FlatMachine, verb string) {
 	results := serviceCall(machines, verb)
 
@@ -0,0 +1,44 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type stopAgentsSuite struct{}
+
+var _ = gc.Suite(&stopAgentsSuite{})
+
+func (*stopAgentsSuite) TestBusyUnits(c *gc.C) {
+	busy := busyUnits([]DistResult{{
+		MachineID: "1",
+		Stdout: `-- unit-state unit-wordpress-0 --
+started: true
+op: continue
+opstep: pending
+-- unit-state unit-mysql-0 --
+op: run-hook
+opstep: pending
+hook:
+  kind: config-changed
+`,
+	}, {
+		MachineID: "0",
+	}, {
+		MachineID: "2",
+		Stdout:    "-- unit-state unit-nagios-0 --\nop: [\n",
+	}, {
+		MachineID: "3",
+		Error:     errors.New("connection refused"),
+	}})
+	c.Assert(busy, jc.DeepEquals, []busyUnit{
+		{"machine-3", "unit state not checked: connection refused"},
+		{"unit-mysql-0", "run-hook config-changed (pending)"},
+		{"unit-nagios-0", busy[2].operation},
+	})
+	c.Assert(busy[2].operation, gc.Matches, "parsing 1.25 uniter state: .*")
+}
@@ -0,0 +1,292 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/description"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"github.com/juju/utils/set"
+)
+
+// skippedMachinesFile is the file in the environment's local state
+// directory recording the machines each agent command skipped because
+// they couldn't be reached, keyed by remote command.
+const skippedMachinesFile = "skipped-machines.json"
+
+func skippedMachinesPath(envName string) string {
+	return filepath.Join(localStateDir(envName), skippedMachinesFile)
+}
+
+func loadSkippedMachines(envName string) (map[string][]string, error) {
+	skipped := make(map[string][]string)
+	bytes, err := ioutil.ReadFile(skippedMachinesPath(envName))
+	if os.IsNotExist(err) {
+		return skipped, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := json.Unmarshal(bytes, &skipped); err != nil {
+		return nil, errors.Annotate(err, "reading skipped machines")
+	}
+	return skipped, nil
+}
+
+func saveSkippedMachines(envName string, skipped map[string][]string) error {
+	for command, ids := range skipped {
+		if len(ids) == 0 {
+			delete(skipped, command)
+		}
+	}
+	bytes, err := json.MarshalIndent(skipped, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(localStateDir(envName), 0700); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(skippedMachinesPath(envName), bytes, 0600))
+}
+
+// recordSkipped updates the machines recorded as skipped by command:
+// the newly skipped ones are added, and the ones being acted on now
+// removed.
+func recordSkipped(skipped map[string][]string, command string, newlySkipped, acted []string) {
+	ids := set.NewStrings(skipped[command]...).Union(set.NewStrings(newlySkipped...))
+	skipped[command] = ids.Difference(set.NewStrings(acted...)).SortedValues()
+}
+
+// splitReachable splits machines into those that can be reached and
+// those that can't.
+func splitReachable(machines []FlatMachine) (ok, skipped []FlatMachine) {
+	for _, m := range machines {
+		if m.Reachability == reachable {
+			ok = append(ok, m)
+		} else {
+			skipped = append(skipped, m)
+		}
+	}
+	return ok, skipped
+}
+
+// setUnreachableFlags adds --allow-unreachable to an agent command.
+func (c *baseClientCommand) setUnreachableFlags(f *gnuflag.FlagSet) {
+	f.BoolVar(&c.allowUnreachable, "allow-unreachable", false, "skip machines that can't be reached, to be completed with retry")
+}
+
+// skipUnreachable returns the machines that can be reached, listing
+// the others. Unless --allow-unreachable was given, any machine that
+// can't be reached is an error; otherwise they're recorded so that
+// retry can complete them later.
+func (c *baseClientCommand) skipUnreachable(ctx *cmd.Context, machines []FlatMachine) ([]FlatMachine, error) {
+	ok, skipped := splitReachable(machines)
+	var skippedIDs []string
+	for _, m := range skipped {
+		ctx.Infof("machine %s %s: %s", m.ID, m.Reachability, m.AddressReason)
+		skippedIDs = append(skippedIDs, m.ID)
+	}
+	if len(skipped) > 0 && !c.allowUnreachable {
+		return nil, errors.Errorf("machines %s can't be reached; pass --allow-unreachable to continue without them",
+			strings.Join(skippedIDs, ", "))
+	}
+	if len(ok) == 0 {
+		return nil, errors.New("no machines can be reached")
+	}
+	var ids []string
+	for _, m := range ok {
+		ids = append(ids, m.ID)
+	}
+	record, err := loadSkippedMachines(c.name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	recordSkipped(record, c.remoteCommand, skippedIDs, ids)
+	if err := saveSkippedMachines(c.name, record); err != nil {
+		return nil, errors.Annotate(err, "recording skipped machines")
+	}
+	if len(skipped) > 0 {
+		if err := appendRunLog(c.name, "%s: skipped unreachable machines %s", c.remoteCommand,
+			strings.Join(skippedIDs, ",")); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return ok, nil
+}
+
+// flagUnreachableMachines sets the status of the exported machines
+// that the state server couldn't reach, so that they stand out in the
+// imported model until their agents are upgraded.
+func flagUnreachableMachines(model description.Model, machines []FlatMachine) {
+	byID := make(map[string]FlatMachine)
+	for _, m := range machines {
+		byID[m.ID] = m
+	}
+	var flag func([]description.Machine)
+	flag = func(exported []description.Machine) {
+		for _, m := range exported {
+			if fm, ok := byID[m.Id()]; ok && fm.Reachability != reachable {
+				m.SetStatus(description.StatusArgs{
+					Value:   "down",
+					Message: fmt.Sprintf("%s during upgrade: %s", fm.Reachability, fm.AddressReason),
+					Updated: time.Now(),
+				})
+			}
+			flag(m.Containers())
+		}
+	}
+	flag(model.Machines())
+}
+
+var retryDoc = `
+The purpose of the retry command is to complete the agent commands on the
+machines they skipped because they couldn't be reached (see
+--allow-unreachable), once those machines are back.
+
+For each of stop-agents, upgrade-agents and start-agents, in that order, the
+skipped machines that can now be reached are processed, and those that still
+can't are listed and kept for the next retry. The controller name is needed
+if upgrade-agents skipped any machines. If stop-agents or upgrade-agents was
+run with --force, pass --force here too, so that units part way through an
+operation don't stop the retry.
+
+`
+
+// retryCommands are the remote commands retry completes, in the order
+// they're run.
+var retryCommands = []string{"stop-agents-impl", "upgrade-agents-impl", "start-agents-impl"}
+
+func newRetryCommand() cmd.Command {
+	return &retryCommand{}
+}
+
+type retryCommand struct {
+	baseClientCommand
+
+	force bool
+}
+
+func (c *retryCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "retry",
+		Args:    "<environment name> [<controller name>]",
+		Purpose: "complete the agent commands on machines that were unreachable",
+		Doc:     retryDoc,
+	}
+}
+
+func (c *retryCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.BoolVar(&c.force, "force", false, "stop and upgrade units that are part way through an operation")
+}
+
+func (c *retryCommand) Init(args []string) error {
+	// Only upgrade-agents-impl needs the controller.
+	c.needsController = len(args) > 1
+	args, err := c.baseClientCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cmd.CheckEmpty(args)
+}
+
+func (c *retryCommand) Run(ctx *cmd.Context) error {
+	skipped, err := loadSkippedMachines(c.name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(skipped) == 0 {
+		ctx.Infof("no skipped machines to retry")
+		return nil
+	}
+	if len(skipped["upgrade-agents-impl"]) > 0 && !c.needsController {
+		return errors.New("upgrade-agents skipped machines; the controller name must be given")
+	}
+	machines, err := c.listMachines(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	byID := make(map[string]FlatMachine)
+	for _, m := range machines {
+		byID[m.ID] = m
+	}
+
+	outstanding := set.NewStrings()
+	for _, command := range retryCommands {
+		var ready, still []string
+		for _, id := range skipped[command] {
+			m, found := byID[id]
+			switch {
+			case !found:
+				ctx.Infof("machine %s no longer exists", id)
+			case m.Reachability != reachable:
+				ctx.Infof("%s: machine %s still %s: %s", command, id, m.Reachability, m.AddressReason)
+				still = append(still, id)
+			default:
+				ready = append(ready, id)
+			}
+		}
+		outstanding = outstanding.Union(set.NewStrings(still...))
+		if len(ready) == 0 {
+			skipped[command] = still
+			continue
+		}
+		var stdin []byte
+		if command == "upgrade-agents-impl" {
+			var machines []FlatMachine
+			for _, id := range ready {
+				machines = append(machines, byID[id])
+			}
+			if err := c.installMachinePlugins(ctx, machines); err != nil {
+				return errors.Trace(err)
+			}
+			if stdin, err = c.remoteControllerInfo(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		ctx.Infof("%s: retrying machines %s", command, strings.Join(ready, ", "))
+		result, err := runRemoteCommand(c, ctx, stdin, command, c.retryArgs(command, ready)...)
+		if err != nil {
+			return errors.Annotatef(err, "running %s via SSH", command)
+		}
+		fmt.Fprintf(ctx.Stdout, result.Stdout)
+		fmt.Fprintf(ctx.Stderr, result.Stderr)
+		if result.Code != 0 {
+			return errors.Errorf("%s failed on machines %s", command, strings.Join(ready, ", "))
+		}
+		skipped[command] = still
+		if err := saveSkippedMachines(c.name, skipped); err != nil {
+			return errors.Annotate(err, "recording skipped machines")
+		}
+		if err := appendRunLog(c.name, "retry: %s completed on machines %s", command,
+			strings.Join(ready, ",")); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := saveSkippedMachines(c.name, skipped); err != nil {
+		return errors.Annotate(err, "recording skipped machines")
+	}
+	if !outstanding.IsEmpty() {
+		return errors.Errorf("machines %s still can't be reached; run retry again once they're back",
+			strings.Join(outstanding.SortedValues(), ", "))
+	}
+	return nil
+}
+
+// retryArgs returns the arguments to run command with on the machines
+// that are ready. --force is only passed to the commands that take it.
+func (c *retryCommand) retryArgs(command string, ready []string) []string {
+	args := []string{"--machines=" + strings.Join(ready, ",")}
+	if c.force && command != "start-agents-impl" {
+		args = append(args, "--force")
+	}
+	return args
+}
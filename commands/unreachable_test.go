@@ -0,0 +1,66 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type unreachableSuite struct{}
+
+var _ = gc.Suite(&unreachableSuite{})
+
+func (*unreachableSuite) TestSplitReachable(c *gc.C) {
+	ok, skipped := splitReachable([]FlatMachine{
+		{ID: "0", Reachability: reachable},
+		{ID: "1", Reachability: unreachable},
+		{ID: "2", Reachability: noAddress},
+		{ID: "3", Reachability: reachable},
+	})
+	c.Assert(ok, jc.DeepEquals, []FlatMachine{
+		{ID: "0", Reachability: reachable},
+		{ID: "3", Reachability: reachable},
+	})
+	c.Assert(skipped, jc.DeepEquals, []FlatMachine{
+		{ID: "1", Reachability: unreachable},
+		{ID: "2", Reachability: noAddress},
+	})
+}
+
+func (*unreachableSuite) TestRecordSkipped(c *gc.C) {
+	skipped := map[string][]string{"stop-agents-impl": {"1", "4"}}
+	recordSkipped(skipped, "stop-agents-impl", []string{"2"}, []string{"0", "4"})
+	c.Assert(skipped, jc.DeepEquals, map[string][]string{
+		"stop-agents-impl": {"1", "2"},
+	})
+	recordSkipped(skipped, "start-agents-impl", []string{"1"}, nil)
+	c.Assert(skipped["start-agents-impl"], jc.DeepEquals, []string{"1"})
+}
+
+func (*unreachableSuite) TestParseStatusUnreachable(c *gc.C) {
+	results := parseStatus([]DistResult{{
+		MachineID: "0/lxc/1",
+		Error:     errors.New("machine 0/lxc/1 no-address: no usable address"),
+	}, {
+		MachineID: "2",
+		Code:      sshFailureCode,
+		Stderr:    "ssh: connect to host 10.0.0.2 port 22: No route to host\n",
+	}})
+	c.Assert(results, jc.DeepEquals, []statusResult{
+		{agent: "machine-0-lxc-1", status: "unreachable"},
+		{agent: "machine-2", status: "unreachable"},
+	})
+}
+
+func (*unreachableSuite) TestRetryArgs(c *gc.C) {
+	command := &retryCommand{}
+	c.Assert(command.retryArgs("upgrade-agents-impl", []string{"1", "3"}), jc.DeepEquals, []string{"--machines=1,3"})
+
+	command.force = true
+	c.Assert(command.retryArgs("stop-agents-impl", []string{"1"}), jc.DeepEquals, []string{"--machines=1", "--force"})
+	c.Assert(command.retryArgs("upgrade-agents-impl", []string{"1"}), jc.DeepEquals, []string{"--machines=1", "--force"})
+	c.Assert(command.retryArgs("start-agents-impl", []string{"1"}), jc.DeepEquals, []string{"--machines=1"})
+}
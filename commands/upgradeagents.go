@@ -5,6 +5,7 @@ package commands
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
@@ -13,13 +14,17 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/juju/cmd"
 	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
 	"github.com/juju/utils"
 	"github.com/juju/utils/set"
 	"github.com/juju/version"
+	"github.com/kardianos/osext"
 
 	coretools "github.com/juju/1.25-upgrade/juju2/tools"
 )
@@ -31,7 +36,25 @@ environment to the version used by the controller.
 
 This command updates the tools symlinks for the agents, and updates their
 agent config files to specify the correct version, along with the CA Cert and
-addersses of the controller.
+addersses of the controller. Machines with a different architecture from the
+state server need the plugin built for it, which is installed on the state
+server from the bundle first, as the state server's own copy is.
+
+Units that are part way through an operation (running a hook or an action,
+or upgrading their charm) stop the upgrade of their machine; --force upgrades
+them anyway.
+
+The agents can be upgraded a few machines at a time: --canary names machines
+to upgrade first, and --batch sets how many of the remaining machines are
+upgraded together. Each batch's agents are then started, and the rollout
+waits up to --timeout for them to connect to the controller before moving on.
+The rollout pauses if a canary fails, or once more than --max-failures
+machines have failed. Progress is saved locally, so running the command again
+carries on from where it stopped.
+
+--machines, --applications and --exclude restrict the command to some of the
+machines; see agent-status. Machines that can't be reached are handled as for
+stop-agents, with --allow-unreachable.
 
 `
 
@@ -40,12 +63,25 @@ func newUpgradeAgentsCommand() cmd.Command {
 		baseClientCommand{
 			needsController: true,
 			remoteCommand:   "upgrade-agents-impl",
+			checkReachable:  true,
 		},
 	}
 }
 
 type upgradeAgentsCommand struct {
 	baseClientCommand
+
+	force   bool
+	timeout time.Duration
+	rollout rolloutOptions
+}
+
+func (c *upgradeAgentsCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.BoolVar(&c.force, "force", false, "upgrade units that are part way through an operation")
+	f.DurationVar(&c.timeout, "timeout", 10*time.Minute, "how long to wait for each batch's agents")
+	c.rollout.setFlags(f)
+	c.setFilterFlags(f)
+	c.setUnreachableFlags(f)
 }
 
 func (c *upgradeAgentsCommand) Info() *cmd.Info {
@@ -58,20 +94,65 @@ func (c *upgradeAgentsCommand) Info() *cmd.Info {
 }
 
 func (c *upgradeAgentsCommand) Init(args []string) error {
+	if err := c.rollout.validate(); err != nil {
+		return errors.Trace(err)
+	}
 	args, err := c.baseClientCommand.init(args)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if c.force {
+		c.remoteArgs = "--force"
+	}
 	return cmd.CheckEmpty(args)
 }
 
+func (c *upgradeAgentsCommand) Run(ctx *cmd.Context) error {
+	machines, err := c.listMachines(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if c.filter.enabled() {
+		if machines, err = c.filter.apply(machines); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := c.installMachinePlugins(ctx, machines); err != nil {
+		return errors.Trace(err)
+	}
+	if c.rollout.enabled() {
+		return errors.Trace(c.runRollout(ctx, c.rollout, c.verifyBatch))
+	}
+	return c.baseClientCommand.Run(ctx)
+}
+
+// verifyBatch starts the agents on the batch's machines, so that the
+// upgrade is known to work before moving on, and waits for them to
+// connect to the controller. It returns the machines with agents that
+// didn't.
+func (c *upgradeAgentsCommand) verifyBatch(ctx *cmd.Context, batch []string) ([]string, error) {
+	result, err := c.runRemote(ctx, "start-agents-impl", "--machines="+strings.Join(batch, ","))
+	if err != nil {
+		return nil, errors.Annotate(err, "running start-agents-impl via SSH")
+	}
+	fmt.Fprintf(ctx.Stdout, result.Stdout)
+	fmt.Fprintf(ctx.Stderr, result.Stderr)
+	if result.Code != 0 {
+		return batch, nil
+	}
+	return c.verifyAgents(ctx, c.timeout, batch)
+}
+
 var upgradeAgentsImplDoc = `
 
 upgrade-agents-impl must be executed on an API server machine of a 1.25
 environment.
 
-The command will get a list of all the machines, and their addresses, and then
-ssh to all the machines to upgrade the various agents on those machines.
+The command will get a list of all the machines, and their addresses, and
+download the controller's tools for each series and architecture they use. It
+then copies the tools and the plugin to each machine over ssh, and runs
+agent-config-convert-impl there to convert its agents. With --force, units
+part way through an operation are converted too.
 
 `
 
@@ -83,6 +164,8 @@ func newUpgradeAgentsImplCommand() cmd.Command {
 
 type upgradeAgentsImplCommand struct {
 	baseRemoteCommand
+
+	force bool
 }
 
 func (c *upgradeAgentsImplCommand) Init(args []string) error {
@@ -94,6 +177,11 @@ func (c *upgradeAgentsImplCommand) Init(args []string) error {
 	return cmd.CheckEmpty(args)
 }
 
+func (c *upgradeAgentsImplCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.setMachineFlags(f)
+	f.BoolVar(&c.force, "force", false, "convert units that are part way through an operation")
+}
+
 func (c *upgradeAgentsImplCommand) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "upgrade-agents-impl",
@@ -111,14 +199,12 @@ func (c *upgradeAgentsImplCommand) Run(ctx *cmd.Context) error {
 
 	// Here we always use the 1.25 environment to get all of the machine
 	// addresses. We then use those to ssh into every one of those machine
-	// and run the service status script against all the agents.
-	machines, err := getMachines(st)
+	// and upgrade the agents on them.
+	machines, err := c.selectedMachines(st)
 	if err != nil {
 		return errors.Annotate(err, "unable to get addresses for machines")
 	}
 
-	_ = machines
-
 	conn, err := c.getControllerConnection()
 	if err != nil {
 		return errors.Annotate(err, "getting controller connection")
@@ -135,30 +221,293 @@ func (c *upgradeAgentsImplCommand) Run(ctx *cmd.Context) error {
 		return errors.Trace(err)
 	}
 
-	// Get a list of all the architectures and series for the machines?
-	toolsNeeded := set.NewStrings()
+	// Work out the tools each machine needs, from the series and
+	// architecture of the tools it has. A machine whose tools aren't
+	// known can't be upgraded.
+	failed := set.NewStrings()
+	toolsNeeded := make(map[string]version.Binary)
 	for _, m := range machines {
-		binary := version.MustParseBinary(m.Tools)
-		toolsNeeded.Add(fmt.Sprintf("%s-%s", binary.Series, binary.Arch))
+		current, err := version.ParseBinary(m.Tools)
+		if err != nil {
+			fmt.Fprintf(ctx.Stdout, "machine %s: agent tools %q not valid: %v\n", m.ID, m.Tools, err)
+			failed.Add(m.ID)
+			continue
+		}
+		toolsNeeded[m.ID] = version.Binary{Number: ver, Series: current.Series, Arch: current.Arch}
 	}
 
-	// Get the tools from the controller.
+	// Get the tools from the controller, and put them in an archive
+	// with the plugin for each series and architecture.
+	plugin, err := osext.Executable()
+	if err != nil {
+		return errors.Annotate(err, "finding plugin location")
+	}
 	client := utils.GetNonValidatingHTTPClient()
 	toolsURLPrefix := fmt.Sprintf("https://%s/tools/%s-", conn.Addr(), ver)
-	for _, seriesArch := range toolsNeeded.SortedValues() {
-		if err := c.getTools(ctx, client, ver, toolsURLPrefix, seriesArch); err != nil {
-			return errors.Annotatef(err, "downloading tools %s-%s", ver, seriesArch)
+	archives := make(map[version.Binary][]byte)
+	for _, tools := range toolsNeeded {
+		if _, ok := archives[tools]; ok {
+			continue
+		}
+		if err := c.getTools(ctx, client, tools, toolsURLPrefix); err != nil {
+			return errors.Annotatef(err, "downloading tools %s", tools)
+		}
+		archive, err := machineArchive(tools, plugin)
+		if err != nil {
+			return errors.Annotatef(err, "packing tools %s", tools)
+		}
+		archives[tools] = archive
+	}
+
+	// Install the tools and the plugin on each machine, then convert
+	// its agents.
+	var upgradable []FlatMachine
+	for _, m := range machines {
+		if !failed.Contains(m.ID) {
+			upgradable = append(upgradable, m)
+		}
+	}
+	start := time.Now()
+	installed := c.report(ctx, failed, upgradable, parallelCallWithStdin(upgradable, installArchiveScript,
+		func(m FlatMachine) []byte {
+			return archives[toolsNeeded[m.ID]]
+		},
+	))
+	for _, m := range installed {
+		auditFileChange(start, m.Address, m.ID, path.Join(dataDir, "tools", toolsNeeded[m.ID].String()), nil)
+		auditFileChange(start, m.Address, m.ID, path.Join(remotePluginDir, filepath.Base(plugin)), nil)
+	}
+
+	info, err := encodeControllerInfo(c.controllerInfo)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	convertScript := fmt.Sprintf("cd %s && ./%s agent-config-convert-impl %s",
+		remotePluginDir, filepath.Base(plugin), c.forceArg())
+	start = time.Now()
+	results := parallelCallWithStdin(installed, convertScript,
+		func(FlatMachine) []byte {
+			return info
+		},
+	)
+	auditConvertChanges(start, installed, results)
+	if err := collectMachineAudit(installed); err != nil {
+		logger.Warningf("%v", err)
+	}
+	c.report(ctx, failed, installed, results)
+
+	if !failed.IsEmpty() {
+		return errors.Errorf("upgrade failed on machines: %s", strings.Join(failed.SortedValues(), ", "))
+	}
+	return nil
+}
+
+func (c *upgradeAgentsImplCommand) forceArg() string {
+	if c.force {
+		return "--force"
+	}
+	return ""
+}
+
+// report writes the output of each result, and adds the machines that
+// failed to failed. It returns the machines that succeeded.
+func (c *upgradeAgentsImplCommand) report(ctx *cmd.Context, failed set.Strings, machines []FlatMachine, results []DistResult) []FlatMachine {
+	succeeded := set.NewStrings()
+	for _, r := range results {
+		for _, line := range strings.Split(strings.TrimSpace(r.Stdout), "\n") {
+			if line != "" {
+				fmt.Fprintf(ctx.Stdout, "machine %s: %s\n", r.MachineID, line)
+			}
+		}
+		switch {
+		case r.Error != nil:
+			fmt.Fprintf(ctx.Stdout, "machine %s: failed: %v\n", r.MachineID, r.Error)
+			failed.Add(r.MachineID)
+		case r.Code != 0:
+			fmt.Fprintf(ctx.Stdout, "machine %s: failed: %s\n", r.MachineID, strings.TrimSpace(r.Stderr))
+			failed.Add(r.MachineID)
+		default:
+			succeeded.Add(r.MachineID)
+		}
+	}
+	var result []FlatMachine
+	for _, m := range machines {
+		if succeeded.Contains(m.ID) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// auditConvertChanges records the files that agent-config-convert-impl
+// reports changing on each machine, whether or not it then failed.
+func auditConvertChanges(start time.Time, machines []FlatMachine, results []DistResult) {
+	addresses := make(map[string]string)
+	for _, m := range machines {
+		addresses[m.ID] = m.Address
+	}
+	for _, r := range results {
+		for _, changed := range convertChanges(r.Stdout) {
+			auditFileChange(start, addresses[r.MachineID], r.MachineID, changed, nil)
+		}
+	}
+}
+
+// installArchiveScript unpacks the archive made by machineArchive,
+// read from stdin.
+const installArchiveScript = `
+set -eu
+tar -C / --no-same-owner -xzf -
+`
+
+// machineArchive returns a gzipped tar archive to unpack in / on a
+// machine. It holds the downloaded tools, which go under
+// /var/lib/juju/tools, and the plugin built for the tools'
+// architecture, which goes in remotePluginDir so that
+// agent-config-convert-impl can be run from there.
+func machineArchive(tools version.Binary, plugin string) ([]byte, error) {
+	machinePlugin, err := pluginForMachines(plugin, tools.Arch)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+
+	source := path.Join(toolsDir, tools.String())
+	target := path.Join("var/lib/juju/tools", tools.String())
+	if err := addTarDir(tw, target, 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	infos, err := ioutil.ReadDir(source)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, info := range infos {
+		name := info.Name()
+		err := addTarFile(tw, path.Join(source, name), path.Join(target, name), info.Mode().Perm())
+		if err != nil {
+			return nil, errors.Trace(err)
 		}
 	}
 
-	//
+	pluginDir := strings.TrimPrefix(remotePluginDir, "/")
+	if err := addTarDir(tw, pluginDir, 0700); err != nil {
+		return nil, errors.Trace(err)
+	}
+	err = addTarFile(tw, machinePlugin, path.Join(pluginDir, filepath.Base(plugin)), 0700)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
 
-	return errors.Errorf("this command not yet finished")
+// installMachinePlugins installs the plugin built for each of the
+// machines' architectures that differs from the state server's, taken
+// from the bundle as for the state server, next to the state server's
+// copy of the plugin; see pluginForMachines. A copy that is already up
+// to date is left alone.
+func (c *baseClientCommand) installMachinePlugins(ctx *cmd.Context, machines []FlatMachine) error {
+	remote, err := getRemotePlugin(c.plugin, c.address)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	arches := set.NewStrings()
+	for _, m := range machines {
+		// Machines with tools that can't be parsed are reported by
+		// upgrade-agents-impl.
+		if tools, err := version.ParseBinary(m.Tools); err == nil && tools.Arch != remote.arch {
+			arches.Add(tools.Arch)
+		}
+	}
+	for _, arch := range arches.SortedValues() {
+		local, err := pluginForArch(c.plugin, arch)
+		if err != nil {
+			return errors.Annotatef(err, "finding plugin for %s machines", arch)
+		}
+		sum, err := localSHA256Sum(local)
+		if err != nil {
+			return errors.Annotate(err, "generating local sha256sum")
+		}
+		target := archPluginPath(remotePluginPath(c.plugin), arch)
+		remoteSum, err := remoteSHA256Sum(c.address, target)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if remoteSum == sum {
+			continue
+		}
+		ctx.Infof("installing the plugin for %s machines on the state server", arch)
+		if err := installRemoteFile(c.address, local, target, 0700); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// archPluginPath returns the path of the plugin built for machines with
+// the given architecture, installed alongside plugin.
+func archPluginPath(plugin, arch string) string {
+	return plugin + "-" + arch
+}
+
+// pluginForMachines returns the plugin to install on machines with the
+// given architecture: the running plugin if it was built for it, or
+// the one installMachinePlugins put alongside it.
+func pluginForMachines(plugin, arch string) (string, error) {
+	if arch == localArch() {
+		return plugin, nil
+	}
+	other := archPluginPath(plugin, arch)
+	if _, err := os.Stat(other); os.IsNotExist(err) {
+		return "", errors.NotFoundf("plugin for %s (%s)", arch, other)
+	} else if err != nil {
+		return "", errors.Trace(err)
+	}
+	return other, nil
+}
+
+func addTarDir(tw *tar.Writer, name string, mode os.FileMode) error {
+	return tw.WriteHeader(&tar.Header{
+		Name:     name + "/",
+		Mode:     int64(mode),
+		Typeflag: tar.TypeDir,
+		ModTime:  time.Now(),
+	})
+}
+
+func addTarFile(tw *tar.Writer, source, name string, mode os.FileMode) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     int64(mode),
+		Size:     info.Size(),
+		Typeflag: tar.TypeReg,
+		ModTime:  info.ModTime(),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = io.Copy(tw, f)
+	return errors.Trace(err)
 }
 
-func (c *upgradeAgentsImplCommand) getTools(ctx *cmd.Context, client *http.Client, ver version.Number, toolsURLPrefix, seriesArch string) error {
-	toolsUrl := toolsURLPrefix + seriesArch
-	toolsVersion := version.MustParseBinary(ver.String() + "-" + seriesArch)
+func (c *upgradeAgentsImplCommand) getTools(ctx *cmd.Context, client *http.Client, toolsVersion version.Binary, toolsURLPrefix string) error {
+	toolsUrl := toolsURLPrefix + toolsVersion.Series + "-" + toolsVersion.Arch
 
 	// Look to see if the directory is already there, if it is, assume
 	// that it is good.
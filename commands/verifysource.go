@@ -7,12 +7,19 @@ import (
 	"github.com/juju/cmd"
 	"github.com/juju/description"
 	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/juju1/state"
 )
 
 var verifySourceDoc = `
 The purpose of the verify-source command is to check connectivity, status, and
 viability of a 1.25 juju environment for migration into a Juju 2.x controller.
 
+The environment is written out in the 2.x model description format. The model
+keeps the environment's name unless --model-name gives it a new one.
+
 `
 
 func newVerifySourceCommand() cmd.Command {
@@ -23,6 +30,12 @@ func newVerifySourceCommand() cmd.Command {
 
 type verifySourceCommand struct {
 	baseClientCommand
+
+	modelName string
+}
+
+func (c *verifySourceCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.StringVar(&c.modelName, "model-name", "", "the name for the migrated model")
 }
 
 func (c *verifySourceCommand) Info() *cmd.Info {
@@ -35,10 +48,16 @@ func (c *verifySourceCommand) Info() *cmd.Info {
 }
 
 func (c *verifySourceCommand) Init(args []string) error {
+	if err := validateModelName(c.modelName); err != nil {
+		return errors.Trace(err)
+	}
 	args, err := c.baseClientCommand.init(args)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if c.modelName != "" {
+		c.remoteArgs = "--model-name=" + c.modelName
+	}
 	return cmd.CheckEmpty(args)
 }
 
@@ -48,7 +67,8 @@ verify-source-impl must be executed on an API server machine of a 1.25
 environment.
 
 The command will check the export of the environment into the 2.0 model
-format.
+format. Machines that can't be reached from the state server are marked down
+in the export.
 
 `
 
@@ -58,6 +78,12 @@ func newVerifySourceImplCommand() cmd.Command {
 
 type verifySourceImplCommand struct {
 	baseRemoteCommand
+
+	modelName string
+}
+
+func (c *verifySourceImplCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.StringVar(&c.modelName, "model-name", "", "the name for the migrated model")
 }
 
 func (c *verifySourceImplCommand) Info() *cmd.Info {
@@ -75,10 +101,27 @@ func (c *verifySourceImplCommand) Run(ctx *cmd.Context) error {
 	}
 	defer st.Close()
 
+	machines, err := getMachines(st)
+	if err != nil {
+		return errors.Annotate(err, "unable to get addresses for machines")
+	}
+	return errors.Trace(writeModel(ctx, st, machines, c.modelName))
+}
+
+// writeModel exports the 1.25 environment in st into the 2.x model
+// description format and writes it to stdout. Any of the machines
+// given that can't be reached are flagged in the export, and the model
+// is given modelName, if set.
+func writeModel(ctx *cmd.Context, st *state.State, machines []FlatMachine, modelName string) error {
+	if err := checkProvider(st); err != nil {
+		return errors.Trace(err)
+	}
 	model, err := st.Export()
 	if err != nil {
 		return errors.Annotate(err, "exporting model representation")
 	}
+	flagUnreachableMachines(model, machines)
+	renameModel(model, modelName)
 
 	// Check for LXC containers
 	bytes, err := description.Serialize(model)
@@ -93,3 +136,37 @@ func (c *verifySourceImplCommand) Run(ctx *cmd.Context) error {
 
 	return nil
 }
+
+// validateModelName returns an error if name isn't empty and isn't a
+// valid 2.x model name.
+func validateModelName(name string) error {
+	if name != "" && !names.IsValidModelName(name) {
+		return errors.NotValidf("model name %q", name)
+	}
+	return nil
+}
+
+// renameModel gives the model a new name, unless name is empty. The
+// model keeps its UUID, so it is still the same model.
+func renameModel(model description.Model, name string) {
+	if name != "" {
+		model.UpdateConfig(map[string]interface{}{"name": name})
+	}
+}
+
+// checkProvider returns an error if the environment's provider can't
+// adopt its resources once migrated. 1.25 azure environments were made
+// with the classic (service management) API, which the 2.x provider
+// can't manage: their instances (named <cloud service>-<role>) can't be
+// found through the resource manager API, even after Azure's own
+// classic to resource manager migration.
+func checkProvider(st *state.State) error {
+	cfg, err := st.EnvironConfig()
+	if err != nil {
+		return errors.Annotate(err, "getting environment config")
+	}
+	if cfg.Type() == "azure" {
+		return errors.NotSupportedf("migrating 1.25 azure environments")
+	}
+	return nil
+}
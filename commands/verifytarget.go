@@ -0,0 +1,145 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/juju/cmd"
+	"github.com/juju/description"
+	"github.com/juju/errors"
+	"github.com/juju/gnuflag"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/juju/1.25-upgrade/juju2/api/modelmanager"
+)
+
+var verifyTargetDoc = `
+The purpose of the verify-target command is to check that the model imported
+into the Juju 2.x controller matches the 1.25 environment it came from.
+
+The 1.25 environment is exported as for verify-source (or read from the file
+given with --source, such as the output of export-backup), and the imported
+model is dumped from the controller. The two are then compared: machines,
+applications, units, relations, settings, storage, constraints and
+annotations must all match. Ordering is ignored, as are fields that are
+expected to change during the migration, such as status, agent tools,
+addresses and passwords.
+
+If the model was given a new name with verify-source --model-name, pass the
+same --model-name here; the model is expected to have that name, rather than
+the environment's.
+
+Any differences are listed and the command fails.
+
+`
+
+func newVerifyTargetCommand() cmd.Command {
+	return &verifyTargetCommand{
+		baseClientCommand{
+			needsController: true,
+			remoteCommand:   "verify-source-impl",
+		},
+	}
+}
+
+type verifyTargetCommand struct {
+	baseClientCommand
+
+	sourceFile string
+	modelName  string
+}
+
+func (c *verifyTargetCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "verify-target",
+		Args:    "<environment name> <controller name>",
+		Purpose: "compare the imported model with the 1.25 environment",
+		Doc:     verifyTargetDoc,
+	}
+}
+
+func (c *verifyTargetCommand) SetFlags(f *gnuflag.FlagSet) {
+	f.StringVar(&c.sourceFile, "source", "", "read the 1.25 export from this file")
+	f.StringVar(&c.modelName, "model-name", "", "the name the model was given")
+}
+
+func (c *verifyTargetCommand) Init(args []string) error {
+	if err := validateModelName(c.modelName); err != nil {
+		return errors.Trace(err)
+	}
+	args, err := c.baseClientCommand.init(args)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cmd.CheckEmpty(args)
+}
+
+func (c *verifyTargetCommand) Run(ctx *cmd.Context) error {
+	source, err := c.sourceModel(ctx)
+	if err != nil {
+		return errors.Annotate(err, "getting source model")
+	}
+	renameModel(source, c.modelName)
+	target, err := c.targetModel(ctx, source.Tag())
+	if err != nil {
+		return errors.Annotate(err, "getting target model")
+	}
+
+	diffs := diffValues(modelValues(source), modelValues(target))
+	if len(diffs) == 0 {
+		fmt.Fprintf(ctx.Stdout, "model %s matches the source environment\n", source.Tag().Id())
+		return appendRunLog(c.name, "verify-target: model %s matches", source.Tag().Id())
+	}
+	for _, diff := range diffs {
+		fmt.Fprintln(ctx.Stdout, diff)
+	}
+	if err := appendRunLog(c.name, "verify-target: %d differences found", len(diffs)); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Errorf("%d differences found", len(diffs))
+}
+
+func (c *verifyTargetCommand) sourceModel(ctx *cmd.Context) (description.Model, error) {
+	if c.sourceFile != "" {
+		bytes, err := ioutil.ReadFile(c.sourceFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return description.Deserialize(bytes)
+	}
+
+	ctx.Infof("exporting 1.25 environment")
+	result, err := c.runRemote(ctx, c.remoteCommand)
+	if err != nil {
+		return nil, errors.Annotatef(err, "running %s via SSH", c.remoteCommand)
+	}
+	if result.Code != 0 {
+		return nil, errors.Errorf("exporting 1.25 environment failed: %s", result.Stderr)
+	}
+	return description.Deserialize([]byte(result.Stdout))
+}
+
+func (c *verifyTargetCommand) targetModel(ctx *cmd.Context, tag names.ModelTag) (description.Model, error) {
+	ctx.Infof("dumping model %s from controller %s", tag.Id(), c.controller.ControllerName())
+	root, err := c.newAPIRoot()
+	if err != nil {
+		return nil, errors.Annotate(err, "connecting to target controller")
+	}
+	defer root.Close()
+
+	dumped, err := modelmanager.NewClient(root).DumpModel(tag)
+	if err != nil {
+		return nil, errors.Annotate(err, "dumping model")
+	}
+	// The dump is the serialized model read back into a map, so
+	// serializing it again gets us back to the description format.
+	bytes, err := yaml.Marshal(dumped)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return description.Deserialize(bytes)
+}
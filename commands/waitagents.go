@@ -0,0 +1,162 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	"sort"
+	"time"
+
+	"github.com/juju/cmd"
+	"github.com/juju/errors"
+	"github.com/juju/utils/set"
+	"github.com/juju/version"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/1.25-upgrade/juju2/api"
+	"github.com/juju/1.25-upgrade/juju2/api/base"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/params"
+	"github.com/juju/1.25-upgrade/juju2/cmd/output"
+)
+
+const waitAgentsPollInterval = 10 * time.Second
+
+// openTargetModel connects to the migrated model on the target
+// controller. The model keeps the UUID of the 1.25 environment.
+func (c *baseClientCommand) openTargetModel() (api.Connection, error) {
+	info, err := c.GetControllerAPIInfo()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	info.ModelTag = names.NewModelTag(c.info.APIEndpoint().EnvironUUID)
+	conn, err := openAuditedAPI(func() (api.Connection, error) {
+		return api.Open(info, api.DefaultDialOpts())
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "connecting to target model")
+	}
+	return conn, nil
+}
+
+// modelStatus returns the status of the model conn is connected to. It
+// calls the facade through conn, rather than conn.Client(), so that the
+// call is recorded in the audit log.
+func modelStatus(conn api.Connection) (*params.FullStatus, error) {
+	var status params.FullStatus
+	err := base.NewFacadeCaller(conn, "Client").FacadeCall("FullStatus", params.StatusParams{}, &status)
+	if err != nil {
+		return nil, errors.Annotate(err, "getting model status")
+	}
+	return &status, nil
+}
+
+// waitForAgents polls the status of the migrated model until every
+// machine and unit agent has connected to the target controller running
+// the controller's version, or the timeout expires. If machines is not
+// nil, only the agents on those machines are considered. Any agents
+// still outstanding at the end are returned.
+func (c *baseClientCommand) waitForAgents(ctx *cmd.Context, timeout time.Duration, machines set.Strings) ([]pendingAgent, error) {
+	conn, err := c.openTargetModel()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer conn.Close()
+
+	ver, ok := conn.ServerVersion()
+	if !ok {
+		return nil, errors.New("target controller did not report its version")
+	}
+	ctx.Infof("waiting up to %s for agents to report version %s", timeout, ver)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := modelStatus(conn)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		pending := pendingAgents(status, ver, machines)
+		if len(pending) == 0 {
+			ctx.Infof("all agents started")
+			return nil, nil
+		}
+		if time.Now().After(deadline) {
+			return pending, nil
+		}
+		ctx.Verbosef("%d agents pending", len(pending))
+		time.Sleep(waitAgentsPollInterval)
+	}
+}
+
+func writePendingAgents(ctx *cmd.Context, pending []pendingAgent) {
+	writer := output.TabWriter(ctx.Stdout)
+	wrapper := output.Wrapper{writer}
+	wrapper.Println("AGENT", "MACHINE", "STATUS", "VERSION", "MESSAGE")
+	for _, agent := range pending {
+		wrapper.Println(agent.agent, agent.machine, agent.status, agent.version, agent.info)
+	}
+	writer.Flush()
+}
+
+type pendingAgent struct {
+	agent   string
+	machine string
+	status  string
+	version string
+	info    string
+}
+
+// pendingAgents returns the machine and unit agents in status that are
+// not yet started (machines) or idle (units) on version ver. If machines
+// is not nil, agents on other machines are ignored.
+func pendingAgents(status *params.FullStatus, ver version.Number, machines set.Strings) []pendingAgent {
+	var pending []pendingAgent
+	check := func(tag names.Tag, machine string, agentStatus params.DetailedStatus, ready string) {
+		if machines != nil && !machines.Contains(machine) {
+			return
+		}
+		if agentStatus.Status == ready && agentStatus.Version == ver.String() {
+			return
+		}
+		pending = append(pending, pendingAgent{
+			agent:   tag.String(),
+			machine: machine,
+			status:  agentStatus.Status,
+			version: agentStatus.Version,
+			info:    agentStatus.Info,
+		})
+	}
+
+	var checkMachine func(id string, machine params.MachineStatus)
+	checkMachine = func(id string, machine params.MachineStatus) {
+		check(names.NewMachineTag(id), id, machine.AgentStatus, "started")
+		for id, container := range machine.Containers {
+			checkMachine(id, container)
+		}
+	}
+	for id, machine := range status.Machines {
+		checkMachine(id, machine)
+	}
+
+	// Subordinates run on their principal's machine.
+	var checkUnit func(name, machine string, unit params.UnitStatus)
+	checkUnit = func(name, machine string, unit params.UnitStatus) {
+		check(names.NewUnitTag(name), machine, unit.AgentStatus, "idle")
+		for name, sub := range unit.Subordinates {
+			checkUnit(name, machine, sub)
+		}
+	}
+	for _, app := range status.Applications {
+		for name, unit := range app.Units {
+			checkUnit(name, unit.Machine, unit)
+		}
+	}
+
+	sort.Sort(pendingAgentList(pending))
+	return pending
+}
+
+type pendingAgentList []pendingAgent
+
+func (l pendingAgentList) Len() int           { return len(l) }
+func (l pendingAgentList) Less(i, j int) bool { return l[i].agent < l[j].agent }
+func (l pendingAgentList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,56 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package commands
+
+import (
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/utils/set"
+	"github.com/juju/version"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/1.25-upgrade/juju2/apiserver/params"
+)
+
+type waitAgentsSuite struct{}
+
+var _ = gc.Suite(&waitAgentsSuite{})
+
+func (*waitAgentsSuite) TestPendingAgents(c *gc.C) {
+	ver := version.MustParse("2.1.2")
+	started := params.DetailedStatus{Status: "started", Version: "2.1.2"}
+	idle := params.DetailedStatus{Status: "idle", Version: "2.1.2"}
+	status := &params.FullStatus{
+		Machines: map[string]params.MachineStatus{
+			"0": {
+				AgentStatus: started,
+				Containers: map[string]params.MachineStatus{
+					"0/lxc/0": {AgentStatus: params.DetailedStatus{Status: "started", Version: "1.25.10"}},
+				},
+			},
+			"1": {AgentStatus: params.DetailedStatus{Status: "down", Version: "2.1.2", Info: "agent lost"}},
+		},
+		Applications: map[string]params.ApplicationStatus{
+			"mysql": {
+				Units: map[string]params.UnitStatus{
+					"mysql/0": {
+						AgentStatus: idle,
+						Machine:     "2",
+						Subordinates: map[string]params.UnitStatus{
+							"nrpe/0": {AgentStatus: params.DetailedStatus{Status: "executing", Version: "2.1.2"}},
+						},
+					},
+					"mysql/1": {AgentStatus: idle, Machine: "1"},
+				},
+			},
+		},
+	}
+	c.Assert(pendingAgents(status, ver, nil), jc.DeepEquals, []pendingAgent{
+		{agent: "machine-0-lxc-0", machine: "0/lxc/0", status: "started", version: "1.25.10"},
+		{agent: "machine-1", machine: "1", status: "down", version: "2.1.2", info: "agent lost"},
+		{agent: "unit-nrpe-0", machine: "2", status: "executing", version: "2.1.2"},
+	})
+	c.Assert(pendingAgents(status, ver, set.NewStrings("1")), jc.DeepEquals, []pendingAgent{
+		{agent: "machine-1", machine: "1", status: "down", version: "2.1.2", info: "agent lost"},
+	})
+}
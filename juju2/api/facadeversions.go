@@ -55,6 +55,7 @@ var facadeVersions = map[string]int{
 	"MetricsAdder":                 2,
 	"MetricsDebug":                 2,
 	"MetricsManager":               1,
+	"MigrationCredentials":         1,
 	"MigrationFlag":                1,
 	"MigrationMaster":              1,
 	"MigrationMinion":              1,
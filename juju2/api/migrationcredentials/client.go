@@ -0,0 +1,46 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package migrationcredentials defines the client side API facade used
+// to get credentials that only allow a model to be migrated into a
+// controller.
+package migrationcredentials
+
+import (
+	"github.com/juju/errors"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/juju/1.25-upgrade/juju2/api/base"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/params"
+)
+
+// NewClient returns a new Client based on an existing API connection.
+func NewClient(caller base.APICaller) *Client {
+	return &Client{base.NewFacadeCaller(caller, "MigrationCredentials")}
+}
+
+// Client is the client-side API for the MigrationCredentials facade.
+type Client struct {
+	caller base.FacadeCaller
+}
+
+// CreateMacaroon returns a macaroon that lets the logged in user
+// migrate the model with the given tag into the controller, and do
+// nothing else, for a short time. It must be used with the user's tag
+// and no password.
+func (c *Client) CreateMacaroon(model names.ModelTag) (*macaroon.Macaroon, error) {
+	args := params.Entities{Entities: []params.Entity{{Tag: model.String()}}}
+	var results params.MacaroonResults
+	if err := c.caller.FacadeCall("CreateMacaroons", args, &results); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(results.Results) != 1 {
+		return nil, errors.Errorf("expected 1 result, got %d", len(results.Results))
+	}
+	result := results.Results[0]
+	if result.Error != nil {
+		return nil, errors.Trace(result.Error)
+	}
+	return result.Result, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package migrationcredentials_test
+
+import (
+	jujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon.v1"
+
+	apitesting "github.com/juju/1.25-upgrade/juju2/api/base/testing"
+	"github.com/juju/1.25-upgrade/juju2/api/migrationcredentials"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/params"
+)
+
+type ClientSuite struct {
+	jujutesting.IsolationSuite
+}
+
+var _ = gc.Suite(&ClientSuite{})
+
+var modelTag = names.NewModelTag("deadbeef-0bad-400d-8000-4b1d0d06f00d")
+
+func (s *ClientSuite) TestCreateMacaroon(c *gc.C) {
+	m, err := macaroon.New([]byte("root-key"), "id", "juju model")
+	c.Assert(err, jc.ErrorIsNil)
+	var stub jujutesting.Stub
+	apiCaller := apitesting.APICallerFunc(func(objType string, version int, id, request string, arg, result interface{}) error {
+		stub.AddCall(objType+"."+request, id, arg)
+		*(result.(*params.MacaroonResults)) = params.MacaroonResults{
+			Results: []params.MacaroonResult{{Result: m}},
+		}
+		return nil
+	})
+	client := migrationcredentials.NewClient(apiCaller)
+	result, err := client.CreateMacaroon(modelTag)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(result, gc.Equals, m)
+	stub.CheckCalls(c, []jujutesting.StubCall{
+		{"MigrationCredentials.CreateMacaroons", []interface{}{"", params.Entities{
+			Entities: []params.Entity{{Tag: modelTag.String()}},
+		}}},
+	})
+}
+
+func (s *ClientSuite) TestCreateMacaroonError(c *gc.C) {
+	apiCaller := apitesting.APICallerFunc(func(objType string, version int, id, request string, arg, result interface{}) error {
+		*(result.(*params.MacaroonResults)) = params.MacaroonResults{
+			Results: []params.MacaroonResult{{Error: &params.Error{Message: "boom"}}},
+		}
+		return nil
+	})
+	client := migrationcredentials.NewClient(apiCaller)
+	_, err := client.CreateMacaroon(modelTag)
+	c.Assert(err, gc.ErrorMatches, "boom")
+}
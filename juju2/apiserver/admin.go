@@ -135,6 +135,20 @@ func (a *admin) login(req params.LoginRequest, loginVersion int) (params.LoginRe
 		// worker for the controller model.
 		controllerMachineLogin = true
 	}
+	if isUser && req.Credentials == "" {
+		// Macaroons may restrict the user to migrating a model
+		// into the controller, which is only done through the
+		// controller's API.
+		migrationModelUUID, err := authentication.MigrationModel(req.Macaroons)
+		if err != nil {
+			logger.Debugf("rejecting migration login: %v", err)
+			return fail, common.ErrPerm
+		}
+		if migrationModelUUID != "" && !controllerOnlyLogin {
+			return fail, common.ErrPerm
+		}
+		a.root.migrationModelUUID = migrationModelUUID
+	}
 	a.root.entity = entity
 	a.apiObserver.Login(entity.Tag(), a.root.state.ModelTag(), controllerMachineLogin, req.UserData)
 
@@ -199,6 +213,10 @@ func (a *admin) login(req params.LoginRequest, loginVersion int) (params.LoginRe
 	if controllerOnlyLogin {
 		loginResult.Facades = filterFacades(isControllerFacade)
 		apiRoot = restrictRoot(apiRoot, controllerFacadesOnly)
+		if a.root.migrationModelUUID != "" {
+			loginResult.Facades = filterFacades(isMigrationModelFacade)
+			apiRoot = restrictRoot(apiRoot, migrationModelFacadesOnly)
+		}
 	} else {
 		loginResult.ModelTag = model.Tag().String()
 		loginResult.Facades = filterFacades(isModelFacade)
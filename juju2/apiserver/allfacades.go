@@ -49,6 +49,7 @@ import (
 	_ "github.com/juju/1.25-upgrade/juju2/apiserver/metricsadder"
 	_ "github.com/juju/1.25-upgrade/juju2/apiserver/metricsdebug" // ModelUser Write
 	_ "github.com/juju/1.25-upgrade/juju2/apiserver/metricsmanager"
+	_ "github.com/juju/1.25-upgrade/juju2/apiserver/migrationcredentials"
 	_ "github.com/juju/1.25-upgrade/juju2/apiserver/migrationflag"
 	_ "github.com/juju/1.25-upgrade/juju2/apiserver/migrationmaster"
 	_ "github.com/juju/1.25-upgrade/juju2/apiserver/migrationminion"
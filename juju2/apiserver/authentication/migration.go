@@ -0,0 +1,88 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package authentication
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils"
+	"github.com/juju/utils/clock"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v1/bakery/checkers"
+	"gopkg.in/macaroon.v1"
+)
+
+const (
+	// migrationModelCaveat is the condition of the first party
+	// caveat restricting a macaroon to the migration of a model.
+	migrationModelCaveat = "juju-migration-model"
+
+	// MigrationMacaroonExpiryTime is how long a migration macaroon
+	// can be used for.
+	MigrationMacaroonExpiryTime = 1 * time.Hour
+)
+
+// CreateMigrationMacaroon creates a macaroon that lets the given user
+// log in without a password, but only to migrate the model with the
+// given UUID into the controller: a connection made with it can only
+// use the migration target facade, and upload binaries, for that
+// model. The service's storage should expire the macaroon's root key
+// after MigrationMacaroonExpiryTime.
+func CreateMigrationMacaroon(
+	service BakeryService,
+	tag names.UserTag,
+	modelUUID string,
+	clock clock.Clock,
+) (*macaroon.Macaroon, error) {
+	if !utils.IsValidUUIDString(modelUUID) {
+		return nil, errors.NotValidf("model UUID %q", modelUUID)
+	}
+	return service.NewMacaroon("", nil, []checkers.Caveat{
+		checkers.DeclaredCaveat(usernameKey, tag.Id()),
+		checkers.TimeBeforeCaveat(clock.Now().Add(MigrationMacaroonExpiryTime)),
+		{Condition: migrationModelCaveat + " " + modelUUID},
+	})
+}
+
+// migrationModelChecker accepts migration model caveats, so that
+// migration macaroons verify. The restriction they carry is applied by
+// the API server, using MigrationModel.
+var migrationModelChecker = checkers.CheckerFunc{
+	migrationModelCaveat,
+	func(cond, arg string) error {
+		if !utils.IsValidUUIDString(arg) {
+			return errors.NotValidf("model UUID %q", arg)
+		}
+		return nil
+	},
+}
+
+// MigrationModel returns the UUID of the model the given macaroons are
+// restricted to migrating, or "" if none of them are restricted. The
+// caveats of every macaroon presented are considered, verified or not,
+// as they can only narrow what the login may do; it's an error for
+// them to name more than one model.
+func MigrationModel(ms []macaroon.Slice) (string, error) {
+	var modelUUID string
+	for _, slice := range ms {
+		for _, m := range slice {
+			for _, caveat := range m.Caveats() {
+				if caveat.Location != "" {
+					// Third party caveats aren't ours.
+					continue
+				}
+				op, arg, err := checkers.ParseCaveat(caveat.Id)
+				if err != nil || op != migrationModelCaveat {
+					continue
+				}
+				if modelUUID != "" && arg != modelUUID {
+					return "", errors.New("macaroons restricted to more than one model")
+				}
+				modelUUID = arg
+			}
+		}
+	}
+	return modelUUID, nil
+}
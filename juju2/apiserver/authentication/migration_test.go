@@ -0,0 +1,82 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package authentication_test
+
+import (
+	"time"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v1/bakery/checkers"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/juju/1.25-upgrade/juju2/apiserver/authentication"
+	coretesting "github.com/juju/1.25-upgrade/juju2/testing"
+)
+
+const migrationModelUUID = "deadbeef-0bad-400d-8000-4b1d0d06f00d"
+
+type migrationMacaroonSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&migrationMacaroonSuite{})
+
+func (s *migrationMacaroonSuite) TestCreateMigrationMacaroon(c *gc.C) {
+	service := mockBakeryService{}
+	clock := testing.NewClock(time.Time{})
+	_, err := authentication.CreateMigrationMacaroon(
+		&service, names.NewUserTag("bobbrown"), migrationModelUUID, clock,
+	)
+	c.Assert(err, jc.ErrorIsNil)
+	service.CheckCallNames(c, "NewMacaroon")
+	service.CheckCall(c, 0, "NewMacaroon", "", []byte(nil), []checkers.Caveat{
+		checkers.DeclaredCaveat("username", "bobbrown"),
+		{Condition: "time-before 0001-01-01T01:00:00Z"},
+		{Condition: "juju-migration-model " + migrationModelUUID},
+	})
+}
+
+func (s *migrationMacaroonSuite) TestCreateMigrationMacaroonInvalidModel(c *gc.C) {
+	service := mockBakeryService{}
+	_, err := authentication.CreateMigrationMacaroon(
+		&service, names.NewUserTag("bobbrown"), "foo", testing.NewClock(time.Time{}),
+	)
+	c.Assert(err, gc.ErrorMatches, `model UUID "foo" not valid`)
+	service.CheckNoCalls(c)
+}
+
+func (s *migrationMacaroonSuite) TestMigrationModel(c *gc.C) {
+	m := s.newMacaroon(c, "time-before 0001-01-01T01:00:00Z", "juju-migration-model "+migrationModelUUID)
+	other := s.newMacaroon(c)
+	modelUUID, err := authentication.MigrationModel([]macaroon.Slice{{other}, {m}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(modelUUID, gc.Equals, migrationModelUUID)
+}
+
+func (s *migrationMacaroonSuite) TestMigrationModelUnrestricted(c *gc.C) {
+	m := s.newMacaroon(c, "time-before 0001-01-01T01:00:00Z")
+	modelUUID, err := authentication.MigrationModel([]macaroon.Slice{{m}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(modelUUID, gc.Equals, "")
+}
+
+func (s *migrationMacaroonSuite) TestMigrationModelConflicting(c *gc.C) {
+	m1 := s.newMacaroon(c, "juju-migration-model "+migrationModelUUID)
+	m2 := s.newMacaroon(c, "juju-migration-model "+coretesting.ModelTag.Id())
+	_, err := authentication.MigrationModel([]macaroon.Slice{{m1}, {m2}})
+	c.Assert(err, gc.ErrorMatches, "macaroons restricted to more than one model")
+}
+
+func (s *migrationMacaroonSuite) newMacaroon(c *gc.C, caveats ...string) *macaroon.Macaroon {
+	m, err := macaroon.New([]byte("root-key"), "id", "juju model")
+	c.Assert(err, jc.ErrorIsNil)
+	for _, caveat := range caveats {
+		err := m.AddFirstPartyCaveat(caveat)
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	return m
+}
@@ -153,7 +153,8 @@ func (u *UserAuthenticator) authenticateMacaroons(
 ) (state.Entity, error) {
 	// Check for a valid request macaroon.
 	assert := map[string]string{usernameKey: tag.Id()}
-	_, err := u.Service.CheckAny(req.Macaroons, assert, checkers.New(checkers.TimeBefore))
+	checker := checkers.New(checkers.TimeBefore, migrationModelChecker)
+	_, err := u.Service.CheckAny(req.Macaroons, assert, checker)
 	if err != nil {
 		cause := err
 		logger.Debugf("local-login macaroon authentication failed: %v", cause)
@@ -134,6 +134,13 @@ func TestingControllerOnlyRoot() rpc.Root {
 	return restrictRoot(r, controllerFacadesOnly)
 }
 
+// TestingMigrationModelOnlyRoot returns a restricted srvRoot as if
+// logged in with migration credentials.
+func TestingMigrationModelOnlyRoot() rpc.Root {
+	r := TestingAPIRoot(nil)
+	return restrictRoot(restrictRoot(r, controllerFacadesOnly), migrationModelFacadesOnly)
+}
+
 // TestingModelOnlyRoot returns a restricted srvRoot as if
 // logged in to a model.
 func TestingModelOnlyRoot() rpc.Root {
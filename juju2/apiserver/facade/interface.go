@@ -117,6 +117,11 @@ type Authorizer interface {
 	// ConnectedModel returns the UUID of the model to which the API
 	// connection was made.
 	ConnectedModel() string
+
+	// MigrationModel returns the UUID of the model that the entity
+	// logged in with credentials restricted to migrating, or "" if
+	// its credentials aren't restricted to a migration.
+	MigrationModel() string
 }
 
 // Resources allows you to store and retrieve Resource implementations.
@@ -15,6 +15,7 @@ import (
 	"gopkg.in/juju/names.v2"
 	"gopkg.in/macaroon-bakery.v1/httpbakery"
 
+	"github.com/juju/1.25-upgrade/juju2/apiserver/authentication"
 	"github.com/juju/1.25-upgrade/juju2/apiserver/common"
 	"github.com/juju/1.25-upgrade/juju2/apiserver/params"
 	"github.com/juju/1.25-upgrade/juju2/state"
@@ -83,13 +84,30 @@ func (ctxt *httpContext) stateForRequestUnauthenticated(r *http.Request) (*state
 
 // stateForRequestAuthenticated returns a state instance appropriate for
 // using for the model implicit in the given request.
-// It also returns the authenticated entity.
-func (ctxt *httpContext) stateForRequestAuthenticated(r *http.Request) (
-	resultSt *state.State, resultReleaser func(), resultEntity state.Entity, err error) {
-	st, releaser, err := ctxt.stateForRequestUnauthenticated(r)
+// It also returns the authenticated entity. Credentials restricted to
+// migrating a model are refused.
+func (ctxt *httpContext) stateForRequestAuthenticated(r *http.Request) (*state.State, func(), state.Entity, error) {
+	st, releaser, entity, migrationModelUUID, err := ctxt.stateAndMigrationModelForRequestAuthenticated(r)
 	if err != nil {
 		return nil, nil, nil, errors.Trace(err)
 	}
+	if migrationModelUUID != "" {
+		releaser()
+		return nil, nil, nil, errors.Unauthorizedf("credentials restricted to migrating model %s", migrationModelUUID)
+	}
+	return st, releaser, entity, nil
+}
+
+// stateAndMigrationModelForRequestAuthenticated is like
+// stateForRequestAuthenticated, but it accepts credentials restricted
+// to migrating a model, and returns the UUID of that model, or "" if
+// the credentials aren't restricted.
+func (ctxt *httpContext) stateAndMigrationModelForRequestAuthenticated(r *http.Request) (
+	resultSt *state.State, resultReleaser func(), resultEntity state.Entity, migrationModelUUID string, err error) {
+	st, releaser, err := ctxt.stateForRequestUnauthenticated(r)
+	if err != nil {
+		return nil, nil, nil, "", errors.Trace(err)
+	}
 	defer func() {
 		// Here err is the named return arg.
 		// Don't user the named releaser return arg, because it will be nil.
@@ -100,13 +118,13 @@ func (ctxt *httpContext) stateForRequestAuthenticated(r *http.Request) (
 
 	req, err := ctxt.loginRequest(r)
 	if err != nil {
-		return nil, nil, nil, errors.NewUnauthorized(err, "")
+		return nil, nil, nil, "", errors.NewUnauthorized(err, "")
 	}
 	authenticator := ctxt.srv.authCtxt.authenticator(r.Host)
 	entity, _, err := checkCreds(st, req, true, authenticator)
 	if err != nil {
 		if common.IsDischargeRequiredError(err) {
-			return nil, nil, nil, errors.Trace(err)
+			return nil, nil, nil, "", errors.Trace(err)
 		}
 
 		// Handle the special case of a worker on a controller machine
@@ -114,16 +132,22 @@ func (ctxt *httpContext) stateForRequestAuthenticated(r *http.Request) (
 		if isMachineTag(req.AuthTag) {
 			entity, err := checkControllerMachineCreds(ctxt.srv.state, req, authenticator)
 			if err != nil {
-				return nil, nil, nil, errors.NewUnauthorized(err, "")
+				return nil, nil, nil, "", errors.NewUnauthorized(err, "")
 			}
-			return st, releaser, entity, nil
+			return st, releaser, entity, "", nil
 		}
 
 		// Any other error at this point should be treated as
 		// "unauthorized".
-		return nil, nil, nil, errors.Trace(errors.NewUnauthorized(err, ""))
+		return nil, nil, nil, "", errors.Trace(errors.NewUnauthorized(err, ""))
 	}
-	return st, releaser, entity, nil
+	if req.Credentials == "" {
+		migrationModelUUID, err = authentication.MigrationModel(req.Macaroons)
+		if err != nil {
+			return nil, nil, nil, "", errors.NewUnauthorized(err, "")
+		}
+	}
+	return st, releaser, entity, migrationModelUUID, nil
 }
 
 func isMachineTag(tag string) bool {
@@ -147,14 +171,17 @@ func checkPermissions(tag names.Tag, acceptFunc common.GetAuthFunc) (bool, error
 // stateForMigration asserts that the incoming connection is from a user that
 // has admin permissions on the controller model. The method also gets the
 // model uuid for the model being migrated from a request header, and returns
-// the state instance for that model.
+// the state instance for that model. Credentials restricted to migrating
+// a model are accepted for that model.
 func (ctxt *httpContext) stateForMigration(r *http.Request, requiredMode state.MigrationMode) (st *state.State, returnReleaser func(), err error) {
-	var user state.Entity
-	st, releaser, user, err := ctxt.stateAndEntityForRequestAuthenticatedUser(r)
+	st, releaser, user, migrationModelUUID, err := ctxt.stateAndMigrationModelForRequestAuthenticated(r)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer releaser()
+	if ok, err := checkPermissions(user.Tag(), common.AuthFuncForTagKind(names.UserTagKind)); !ok {
+		return nil, nil, err
+	}
 
 	if !st.IsController() {
 		return nil, nil, errors.BadRequestf("model is not controller model")
@@ -175,6 +202,9 @@ func (ctxt *httpContext) stateForMigration(r *http.Request, requiredMode state.M
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
+	if migrationModelUUID != "" && migrationModelUUID != modelUUID {
+		return nil, nil, errors.Unauthorizedf("credentials restricted to migrating model %s", migrationModelUUID)
+	}
 	migrationSt, migrationReleaser, err := ctxt.srv.statePool.Get(modelUUID)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
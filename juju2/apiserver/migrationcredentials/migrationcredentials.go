@@ -0,0 +1,120 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package migrationcredentials defines the API facade used to get
+// credentials that only allow a model to be migrated into the
+// controller, so that the controller admin's own credentials needn't
+// be handed to the tools doing the migration.
+package migrationcredentials
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/clock"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v1/bakery"
+
+	"github.com/juju/1.25-upgrade/juju2/apiserver/authentication"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/common"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/facade"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/params"
+	"github.com/juju/1.25-upgrade/juju2/permission"
+	"github.com/juju/1.25-upgrade/juju2/state"
+)
+
+func init() {
+	common.RegisterStandardFacade("MigrationCredentials", 1, newAPI)
+}
+
+// NewBakeryServiceFunc returns a bakery service whose macaroons can be
+// verified by the controller's API server until the given time.
+type NewBakeryServiceFunc func(st *state.State, expiry time.Time) (authentication.BakeryService, error)
+
+// API implements the MigrationCredentials facade.
+type API struct {
+	state      *state.State
+	authorizer facade.Authorizer
+	newService NewBakeryServiceFunc
+	clock      clock.Clock
+}
+
+// NewAPI returns a new API. Only controller admins may use it.
+func NewAPI(ctx facade.Context, newService NewBakeryServiceFunc, clock clock.Clock) (*API, error) {
+	auth := ctx.Auth()
+	st := ctx.State()
+	if !auth.AuthClient() {
+		return nil, errors.Trace(common.ErrPerm)
+	}
+	if isAdmin, err := auth.HasPermission(permission.SuperuserAccess, st.ControllerTag()); err != nil {
+		return nil, errors.Trace(err)
+	} else if !isAdmin {
+		return nil, errors.Trace(common.ErrPerm)
+	}
+	return &API{
+		state:      st,
+		authorizer: auth,
+		newService: newService,
+		clock:      clock,
+	}, nil
+}
+
+func newAPI(ctx facade.Context) (*API, error) {
+	return NewAPI(ctx, newBakeryService, clock.WallClock)
+}
+
+// CreateMacaroons returns, for each of the given model tags, a
+// macaroon that lets the authenticated user migrate that model into
+// the controller, and do nothing else, for the next hour. The models
+// needn't exist yet.
+func (api *API) CreateMacaroons(args params.Entities) (params.MacaroonResults, error) {
+	expiry := api.clock.Now().Add(authentication.MigrationMacaroonExpiryTime)
+	service, err := api.newService(api.state, expiry)
+	if err != nil {
+		return params.MacaroonResults{}, errors.Trace(err)
+	}
+	user, ok := api.authorizer.GetAuthTag().(names.UserTag)
+	if !ok {
+		return params.MacaroonResults{}, errors.Trace(common.ErrPerm)
+	}
+	results := params.MacaroonResults{
+		Results: make([]params.MacaroonResult, len(args.Entities)),
+	}
+	for i, arg := range args.Entities {
+		tag, err := names.ParseModelTag(arg.Tag)
+		if err != nil {
+			results.Results[i].Error = common.ServerError(err)
+			continue
+		}
+		m, err := authentication.CreateMigrationMacaroon(service, user, tag.Id(), api.clock)
+		if err != nil {
+			results.Results[i].Error = common.ServerError(err)
+			continue
+		}
+		results.Results[i].Result = m
+	}
+	return results, nil
+}
+
+// newBakeryService returns a bakery service that stores the root keys
+// of its macaroons in the same place as the API server's local user
+// bakery service, so that the API server can verify them.
+func newBakeryService(st *state.State, expiry time.Time) (authentication.BakeryService, error) {
+	store, err := st.NewBakeryStorage()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	key, err := bakery.GenerateKey()
+	if err != nil {
+		return nil, errors.Annotate(err, "generating key for bakery service")
+	}
+	service, err := bakery.NewService(bakery.NewServiceParams{
+		Location: "juju model " + st.ModelUUID(),
+		Store:    store.ExpireAt(expiry),
+		Key:      key,
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return service, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package migrationcredentials_test
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v1/bakery/checkers"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/juju/1.25-upgrade/juju2/apiserver/authentication"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/common"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/facade/facadetest"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/migrationcredentials"
+	"github.com/juju/1.25-upgrade/juju2/apiserver/params"
+	apiservertesting "github.com/juju/1.25-upgrade/juju2/apiserver/testing"
+	"github.com/juju/1.25-upgrade/juju2/state"
+	statetesting "github.com/juju/1.25-upgrade/juju2/state/testing"
+)
+
+const modelUUID = "deadbeef-0bad-400d-8000-4b1d0d06f00d"
+
+type Suite struct {
+	statetesting.StateSuite
+	authorizer apiservertesting.FakeAuthorizer
+	clock      *testing.Clock
+	service    mockBakeryService
+}
+
+var _ = gc.Suite(&Suite{})
+
+func (s *Suite) SetUpTest(c *gc.C) {
+	s.StateSuite.SetUpTest(c)
+	s.authorizer = apiservertesting.FakeAuthorizer{
+		Tag:      s.Owner,
+		AdminTag: s.Owner,
+	}
+	s.clock = testing.NewClock(time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC))
+	s.service = mockBakeryService{}
+}
+
+func (s *Suite) TestFacadeRegistered(c *gc.C) {
+	factory, err := common.Facades.GetFactory("MigrationCredentials", 1)
+	c.Assert(err, jc.ErrorIsNil)
+
+	api, err := factory(&facadetest.Context{
+		State_: s.State,
+		Auth_:  s.authorizer,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(api, gc.FitsTypeOf, new(migrationcredentials.API))
+}
+
+func (s *Suite) TestNotUser(c *gc.C) {
+	s.authorizer.Tag = names.NewMachineTag("0")
+	_, err := s.newAPI()
+	c.Assert(errors.Cause(err), gc.Equals, common.ErrPerm)
+}
+
+func (s *Suite) TestNotControllerAdmin(c *gc.C) {
+	s.authorizer.Tag = names.NewUserTag("jrandomuser")
+	_, err := s.newAPI()
+	c.Assert(errors.Cause(err), gc.Equals, common.ErrPerm)
+}
+
+func (s *Suite) TestCreateMacaroons(c *gc.C) {
+	api, err := s.newAPI()
+	c.Assert(err, jc.ErrorIsNil)
+
+	results, err := api.CreateMacaroons(params.Entities{Entities: []params.Entity{
+		{Tag: names.NewModelTag(modelUUID).String()},
+		{Tag: "machine-0"},
+	}})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(results.Results, gc.HasLen, 2)
+	c.Check(results.Results[0].Error, gc.IsNil)
+	c.Check(results.Results[0].Result, gc.NotNil)
+	c.Check(results.Results[1].Error, gc.ErrorMatches, `"machine-0" is not a valid model tag`)
+
+	expiry := s.clock.Now().Add(authentication.MigrationMacaroonExpiryTime)
+	s.service.CheckCallNames(c, "NewService", "NewMacaroon")
+	s.service.CheckCall(c, 0, "NewService", s.State.ModelUUID(), expiry)
+	s.service.CheckCall(c, 1, "NewMacaroon", "", []byte(nil), []checkers.Caveat{
+		checkers.DeclaredCaveat("username", s.Owner.Id()),
+		checkers.TimeBeforeCaveat(expiry),
+		{Condition: "juju-migration-model " + modelUUID},
+	})
+}
+
+func (s *Suite) newAPI() (*migrationcredentials.API, error) {
+	ctx := facadetest.Context{
+		State_: s.State,
+		Auth_:  s.authorizer,
+	}
+	newService := func(st *state.State, expiry time.Time) (authentication.BakeryService, error) {
+		s.service.MethodCall(&s.service, "NewService", st.ModelUUID(), expiry)
+		return &s.service, s.service.NextErr()
+	}
+	return migrationcredentials.NewAPI(ctx, newService, s.clock)
+}
+
+type mockBakeryService struct {
+	testing.Stub
+}
+
+func (s *mockBakeryService) AddCaveat(m *macaroon.Macaroon, caveat checkers.Caveat) error {
+	s.MethodCall(s, "AddCaveat", m, caveat)
+	return s.NextErr()
+}
+
+func (s *mockBakeryService) CheckAny(ms []macaroon.Slice, assert map[string]string, checker checkers.Checker) (map[string]string, error) {
+	s.MethodCall(s, "CheckAny", ms, assert, checker)
+	return nil, s.NextErr()
+}
+
+func (s *mockBakeryService) NewMacaroon(id string, key []byte, caveats []checkers.Caveat) (*macaroon.Macaroon, error) {
+	s.MethodCall(s, "NewMacaroon", id, key, caveats)
+	return &macaroon.Macaroon{}, s.NextErr()
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/juju/1.25-upgrade/juju2/apiserver/common"
 	"github.com/juju/1.25-upgrade/juju2/apiserver/facade"
 	"github.com/juju/1.25-upgrade/juju2/apiserver/params"
+	"github.com/juju/1.25-upgrade/juju2/core/description"
 	coremigration "github.com/juju/1.25-upgrade/juju2/core/migration"
 	"github.com/juju/1.25-upgrade/juju2/environs"
 	"github.com/juju/1.25-upgrade/juju2/migration"
@@ -71,9 +72,23 @@ func checkAuth(authorizer facade.Authorizer, st *state.State) error {
 	return nil
 }
 
+// checkMigrationModel returns an error if the client's credentials
+// are restricted to migrating a model other than the one with the
+// given UUID.
+func (api *API) checkMigrationModel(modelUUID string) error {
+	migrationModelUUID := api.authorizer.MigrationModel()
+	if migrationModelUUID != "" && migrationModelUUID != modelUUID {
+		return errors.Trace(common.ErrPerm)
+	}
+	return nil
+}
+
 // Prechecks ensure that the target controller is ready to accept a
 // model migration.
 func (api *API) Prechecks(model params.MigrationModelInfo) error {
+	if err := api.checkMigrationModel(model.UUID); err != nil {
+		return errors.Trace(err)
+	}
 	ownerTag, err := names.ParseUserTag(model.OwnerTag)
 	if err != nil {
 		return errors.Trace(err)
@@ -97,6 +112,15 @@ func (api *API) Prechecks(model params.MigrationModelInfo) error {
 // Import takes a serialized Juju model, deserializes it, and
 // recreates it in the receiving controller.
 func (api *API) Import(serialized params.SerializedModel) error {
+	if api.authorizer.MigrationModel() != "" {
+		model, err := description.Deserialize(serialized.Bytes)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := api.checkMigrationModel(model.Tag().Id()); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	_, st, err := migration.ImportModel(api.state, serialized.Bytes)
 	if err != nil {
 		return err
@@ -113,6 +137,9 @@ func (api *API) getModel(modelTag string) (*state.Model, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if err := api.checkMigrationModel(tag.Id()); err != nil {
+		return nil, errors.Trace(err)
+	}
 	model, err := api.state.GetModel(tag)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -249,6 +249,37 @@ func (s *Suite) TestAdoptResources(c *gc.C) {
 	env.Stub.CheckCall(c, 0, "AdoptResources", st.ControllerUUID(), version.MustParse("3.2.1"))
 }
 
+func (s *Suite) TestMigrationModelRestricted(c *gc.C) {
+	uuid, bytes := s.makeExportedModel(c)
+	s.authorizer.MigrationModelUUID = uuid
+	api := s.mustNewAPI(c)
+
+	err := api.Import(params.SerializedModel{Bytes: bytes})
+	c.Assert(err, jc.ErrorIsNil)
+	err = api.Activate(params.ModelArgs{ModelTag: names.NewModelTag(uuid).String()})
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *Suite) TestMigrationModelRestrictedOtherModel(c *gc.C) {
+	s.authorizer.MigrationModelUUID = utils.MustNewUUID().String()
+	api := s.mustNewAPI(c)
+
+	err := api.Prechecks(params.MigrationModelInfo{UUID: s.State.ModelUUID()})
+	c.Check(errors.Cause(err), gc.Equals, common.ErrPerm)
+	_, bytes := s.makeExportedModel(c)
+	err = api.Import(params.SerializedModel{Bytes: bytes})
+	c.Check(errors.Cause(err), gc.Equals, common.ErrPerm)
+	args := params.ModelArgs{ModelTag: s.State.ModelTag().String()}
+	err = api.Abort(args)
+	c.Check(errors.Cause(err), gc.Equals, common.ErrPerm)
+	err = api.Activate(args)
+	c.Check(errors.Cause(err), gc.Equals, common.ErrPerm)
+	_, err = api.LatestLogTime(args)
+	c.Check(errors.Cause(err), gc.Equals, common.ErrPerm)
+	err = api.AdoptResources(params.AdoptResourcesArgs{ModelTag: args.ModelTag})
+	c.Check(errors.Cause(err), gc.Equals, common.ErrPerm)
+}
+
 func (s *Suite) newAPI(environFunc stateenvirons.NewEnvironFunc) (*migrationtarget.API, *facadetest.Context, error) {
 	ctx := facadetest.Context{
 		State_:     s.State,
@@ -17,6 +17,7 @@ var controllerFacadeNames = set.NewStrings(
 	"AllModelWatcher",
 	"Cloud",
 	"Controller",
+	"MigrationCredentials",
 	"MigrationTarget",
 	"ModelManager",
 	"UserManager",
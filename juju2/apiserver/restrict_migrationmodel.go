@@ -0,0 +1,32 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/set"
+)
+
+// migrationModelFacadeNames are the root names that can be accessed by
+// a login with credentials restricted to migrating a model into the
+// controller.
+var migrationModelFacadeNames = set.NewStrings(
+	"MigrationTarget",
+	"Pinger",
+)
+
+func migrationModelFacadesOnly(facadeName, _ string) error {
+	if !isMigrationModelFacade(facadeName) {
+		return errors.NewNotSupported(nil, fmt.Sprintf("facade %q not supported for migration credentials", facadeName))
+	}
+	return nil
+}
+
+// isMigrationModelFacade reports whether the given facade name can be
+// accessed with migration credentials.
+func isMigrationModelFacade(facadeName string) bool {
+	return migrationModelFacadeNames.Contains(facadeName)
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver_test
+
+import (
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/1.25-upgrade/juju2/apiserver"
+	"github.com/juju/1.25-upgrade/juju2/rpc"
+	"github.com/juju/1.25-upgrade/juju2/testing"
+)
+
+type restrictMigrationModelSuite struct {
+	testing.BaseSuite
+	root rpc.Root
+}
+
+var _ = gc.Suite(&restrictMigrationModelSuite{})
+
+func (s *restrictMigrationModelSuite) SetUpSuite(c *gc.C) {
+	s.BaseSuite.SetUpSuite(c)
+	s.root = apiserver.TestingMigrationModelOnlyRoot()
+}
+
+func (s *restrictMigrationModelSuite) TestAllowed(c *gc.C) {
+	s.assertMethod(c, "MigrationTarget", 1, "Prechecks")
+	s.assertMethod(c, "MigrationTarget", 1, "Import")
+	s.assertMethod(c, "MigrationTarget", 1, "Activate")
+	s.assertMethod(c, "Pinger", 1, "Ping")
+}
+
+func (s *restrictMigrationModelSuite) TestNotAllowed(c *gc.C) {
+	for _, facadeName := range []string{"ModelManager", "UserManager", "Controller", "MigrationCredentials"} {
+		caller, err := s.root.FindMethod(facadeName, 1, "Foo")
+		c.Check(err, gc.ErrorMatches, `facade "`+facadeName+`" not supported for migration credentials`)
+		c.Check(errors.IsNotSupported(err), jc.IsTrue)
+		c.Check(caller, gc.IsNil)
+	}
+}
+
+func (s *restrictMigrationModelSuite) assertMethod(c *gc.C, facadeName string, version int, method string) {
+	caller, err := s.root.FindMethod(facadeName, version, method)
+	c.Check(err, jc.ErrorIsNil)
+	c.Check(caller, gc.NotNil)
+}
@@ -56,6 +56,11 @@ type apiHandler struct {
 	// user manager and model manager api endpoints from here.
 	modelUUID string
 
+	// migrationModelUUID is the UUID of the model that the user's
+	// credentials are restricted to migrating into the controller, if
+	// any; such logins are limited to the MigrationTarget facade.
+	migrationModelUUID string
+
 	// serverHost is the host:port of the API server that the client
 	// connected to.
 	serverHost string
@@ -381,6 +386,12 @@ func (r *apiHandler) ConnectedModel() string {
 	return r.modelUUID
 }
 
+// MigrationModel returns the UUID of the model that the login was
+// restricted to migrating, or "" if it wasn't restricted.
+func (r *apiHandler) MigrationModel() string {
+	return r.migrationModelUUID
+}
+
 // GetAuthEntity returns the authenticated entity.
 func (r *apiHandler) GetAuthEntity() state.Entity {
 	return r.entity
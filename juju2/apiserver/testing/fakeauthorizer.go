@@ -18,6 +18,8 @@ type FakeAuthorizer struct {
 	ModelUUID   string
 	AdminTag    names.UserTag
 	HasWriteTag names.UserTag
+
+	MigrationModelUUID string
 }
 
 func (fa FakeAuthorizer) AuthOwner(tag names.Tag) bool {
@@ -119,6 +121,12 @@ func (fa FakeAuthorizer) ConnectedModel() string {
 	return fa.ModelUUID
 }
 
+// MigrationModel returns the UUID of the model the current client is
+// restricted to migrating, if any.
+func (fa FakeAuthorizer) MigrationModel() string {
+	return fa.MigrationModelUUID
+}
+
 // UserHasPermission returns true if the passed user is admin or has a name equal to
 // the pre-set admin tag.
 func (fa FakeAuthorizer) UserHasPermission(user names.UserTag, operation permission.Access, target names.Tag) (bool, error) {
@@ -0,0 +1,19 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package environs
+
+import "github.com/juju/version"
+
+// legacyEnvironmentVersion is the only 1.x version that environments
+// can be migrated from.
+var legacyEnvironmentVersion = version.MustParse("1.25.0")
+
+// IsLegacyEnvironment returns whether a model whose resources are being
+// adopted (see Environ.AdoptResources) was migrated from a Juju 1.25
+// environment, given the version of the source. Resources made by a
+// 1.25 environment follow its own naming and tagging conventions, and
+// aren't found the way the model's own resources are.
+func IsLegacyEnvironment(fromVersion version.Number) bool {
+	return fromVersion.Major == 1 && fromVersion.Compare(legacyEnvironmentVersion) >= 0
+}
@@ -0,0 +1,31 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package environs_test
+
+import (
+	"github.com/juju/version"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/1.25-upgrade/juju2/environs"
+)
+
+type legacySuite struct{}
+
+var _ = gc.Suite(&legacySuite{})
+
+func (s *legacySuite) TestIsLegacyEnvironment(c *gc.C) {
+	for _, test := range []struct {
+		version string
+		legacy  bool
+	}{
+		{"1.25.0", true},
+		{"1.25.10", true},
+		{"1.24.7", false},
+		{"2.0.0", false},
+		{"2.1.2", false},
+	} {
+		c.Logf("version %s", test.version)
+		c.Check(environs.IsLegacyEnvironment(version.MustParse(test.version)), gc.Equals, test.legacy)
+	}
+}
@@ -35,6 +35,11 @@ const (
 	// the service or unit that owns the Juju storage instance
 	// that an IaaS storage resource is assigned to.
 	JujuStorageOwner = JujuTagPrefix + "storage-owner"
+
+	// JujuEnv is the tag name used by Juju 1.x for identifying
+	// the environment a resource is part of. Models migrated
+	// from 1.x keep the environment's UUID.
+	JujuEnv = JujuTagPrefix + "env-uuid"
 )
 
 // ResourceTagger is an interface that can provide resource tags.
@@ -1094,6 +1094,12 @@ func (env *azureEnviron) instances(
 
 // AdoptResources is part of the Environ interface.
 func (env *azureEnviron) AdoptResources(controllerUUID string, fromVersion version.Number) error {
+	if environs.IsLegacyEnvironment(fromVersion) {
+		// 1.25 environments were made with the classic (service
+		// management) API, outside any resource group, and can't be
+		// managed through the resource manager API at all.
+		return errors.NotSupportedf("adopting resources of a 1.25 azure environment")
+	}
 	groupClient := resources.GroupsClient{env.resources}
 
 	err := env.updateGroupControllerTag(&groupClient, env.resourceGroup, controllerUUID)
@@ -1560,6 +1560,14 @@ func (s *environSuite) TestAdoptResourcesErrorGettingGroup(c *gc.C) {
 	c.Assert(s.requests, gc.HasLen, 1)
 }
 
+func (s *environSuite) TestAdoptResourcesFromLegacyEnvironment(c *gc.C) {
+	env := s.openEnviron(c)
+	s.requests = nil
+	err := env.AdoptResources("new-controller", version.MustParse("1.25.10"))
+	c.Assert(err, gc.ErrorMatches, "adopting resources of a 1.25 azure environment not supported")
+	c.Assert(s.requests, gc.HasLen, 0)
+}
+
 func (s *environSuite) TestAdoptResourcesErrorUpdatingGroup(c *gc.C) {
 	env := s.openEnviron(c)
 	errorSender := s.makeSender(".*/resourcegroups/juju-testenv-.*", nil)
@@ -951,6 +951,9 @@ func (e *environ) Subnets(instId instance.Id, subnetIds []network.Id) ([]network
 
 // AdoptResources is part of the Environ interface.
 func (e *environ) AdoptResources(controllerUUID string, fromVersion version.Number) error {
+	if environs.IsLegacyEnvironment(fromVersion) {
+		return errors.Trace(e.adoptLegacyResources(controllerUUID))
+	}
 	// Gather resource ids for instances, volumes and security groups tagged with this model.
 	instances, err := e.AllInstances()
 	if err != nil {
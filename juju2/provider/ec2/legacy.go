@@ -0,0 +1,68 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package ec2
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/set"
+	"gopkg.in/amz.v3/ec2"
+
+	"github.com/juju/1.25-upgrade/juju2/environs/tags"
+)
+
+// adoptLegacyResources tags the resources of a model migrated from a
+// Juju 1.x environment with the model and controller tags. 1.x tagged
+// instances and volumes with the environment UUID (which the model
+// keeps) under tags.JujuEnv, and left root disks untagged. It named
+// its security groups after the environment rather than the model
+// UUID, and didn't tag them, so they are found through the instances
+// they are attached to.
+func (e *environ) adoptLegacyResources(controllerUUID string) error {
+	filter := ec2.NewFilter()
+	filter.Add("tag:"+tags.JujuEnv, e.uuid())
+	filter.Add("instance-state-name", aliveInstanceStates...)
+	resp, err := e.ec2.Instances(nil, filter)
+	if err != nil {
+		return errors.Annotate(err, "listing 1.x instances")
+	}
+
+	legacyGroupName := "juju-" + e.Config().Name()
+	resourceIds := set.NewStrings()
+	for _, reservation := range resp.Reservations {
+		for _, inst := range reservation.Instances {
+			resourceIds.Add(inst.InstanceId)
+			for _, m := range inst.BlockDeviceMappings {
+				if m.DeviceName == inst.RootDeviceName && m.VolumeId != "" {
+					resourceIds.Add(m.VolumeId)
+				}
+			}
+			for _, group := range inst.SecurityGroups {
+				if group.Name == legacyGroupName || strings.HasPrefix(group.Name, legacyGroupName+"-") {
+					resourceIds.Add(group.Id)
+				}
+			}
+		}
+	}
+
+	filter = ec2.NewFilter()
+	filter.Add("tag:"+tags.JujuEnv, e.uuid())
+	volumeIds, err := listVolumes(e.ec2, filter, true)
+	if err != nil {
+		return errors.Annotate(err, "listing 1.x volumes")
+	}
+	for _, id := range volumeIds {
+		resourceIds.Add(id)
+	}
+	if resourceIds.IsEmpty() {
+		return nil
+	}
+
+	newTags := map[string]string{
+		tags.JujuModel:      e.uuid(),
+		tags.JujuController: controllerUUID,
+	}
+	return errors.Annotate(tagResources(e.ec2, newTags, resourceIds.SortedValues()...), "updating tags")
+}
@@ -1627,6 +1627,68 @@ func (s *localServerSuite) TestAdoptResources(c *gc.C) {
 	checkGroupTags(origController, controllerGroups...)
 }
 
+func (s *localServerSuite) TestAdoptResourcesFromLegacyEnvironment(c *gc.C) {
+	env := s.Prepare(c)
+	modelUUID := env.Config().UUID()
+	ec2conn := ec2.EnvironEC2(env)
+
+	// Make the resources as a 1.x environment named "sample" would have:
+	// tagged with the environment UUID, in unnamed groups.
+	s.srv.ec2srv.SetCreateRootDisks(true)
+	envGroup := createGroup(c, ec2conn, "juju-sample", "juju group")
+	machineGroup := createGroup(c, ec2conn, "juju-sample-0", "juju group")
+	otherGroup := createGroup(c, ec2conn, "other", "not juju")
+	instIds := s.srv.ec2srv.NewInstances(1, "m1.medium", imageId, ec2test.Running,
+		[]amzec2.SecurityGroup{envGroup, machineGroup, otherGroup})
+	otherIds := s.srv.ec2srv.NewInstances(1, "m1.medium", imageId, ec2test.Running, nil)
+	_, err := ec2conn.CreateTags(instIds, []amzec2.Tag{{tags.JujuEnv, modelUUID}})
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = ec2conn.CreateTags(otherIds, []amzec2.Tag{{tags.JujuEnv, "another-env"}})
+	c.Assert(err, jc.ErrorIsNil)
+
+	resp, err := ec2conn.Instances(instIds, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	inst := resp.Reservations[0].Instances[0]
+	var rootDisk string
+	for _, m := range inst.BlockDeviceMappings {
+		if m.DeviceName == inst.RootDeviceName {
+			rootDisk = m.VolumeId
+		}
+	}
+	c.Assert(rootDisk, gc.Not(gc.Equals), "")
+	vol, err := ec2conn.CreateVolume(amzec2.CreateVolume{AvailZone: inst.AvailZone, VolumeSize: 1})
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = ec2conn.CreateTags([]string{vol.Id}, []amzec2.Tag{{tags.JujuEnv, modelUUID}})
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = env.AdoptResources("new-controller", version.MustParse("1.25.10"))
+	c.Assert(err, jc.ErrorIsNil)
+
+	filter := makeFilter("tag:"+tags.JujuController, "new-controller")
+	filter.Add("tag:"+tags.JujuModel, modelUUID)
+	instResp, err := ec2conn.Instances(nil, filter)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(instResp.Reservations, gc.HasLen, 1)
+	c.Assert(instResp.Reservations[0].Instances, gc.HasLen, 1)
+	c.Check(instResp.Reservations[0].Instances[0].InstanceId, gc.Equals, instIds[0])
+
+	volResp, err := ec2conn.Volumes(nil, filter)
+	c.Assert(err, jc.ErrorIsNil)
+	volIds := set.NewStrings()
+	for _, v := range volResp.Volumes {
+		volIds.Add(v.Id)
+	}
+	c.Check(volIds, gc.DeepEquals, set.NewStrings(rootDisk, vol.Id))
+
+	groupResp, err := ec2conn.SecurityGroups(nil, filter)
+	c.Assert(err, jc.ErrorIsNil)
+	groupIds := set.NewStrings()
+	for _, g := range groupResp.Groups {
+		groupIds.Add(g.Id)
+	}
+	c.Check(groupIds, gc.DeepEquals, set.NewStrings(envGroup.Id, machineGroup.Id))
+}
+
 // localNonUSEastSuite is similar to localServerSuite but the S3 mock server
 // behaves as if it is not in the us-east region.
 type localNonUSEastSuite struct {
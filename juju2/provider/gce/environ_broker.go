@@ -5,6 +5,7 @@ package gce
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/juju/errors"
 	"github.com/juju/utils"
@@ -283,12 +284,22 @@ func (env *environ) AllInstances() ([]instance.Instance, error) {
 
 // StopInstances implements environs.InstanceBroker.
 func (env *environ) StopInstances(instances ...instance.Id) error {
-	var ids []string
+	var ids, legacyIds []string
 	for _, id := range instances {
-		ids = append(ids, string(id))
+		if strings.HasPrefix(string(id), env.legacyPrefix()) {
+			legacyIds = append(legacyIds, string(id))
+		} else {
+			ids = append(ids, string(id))
+		}
 	}
 
 	prefix := env.namespace.Prefix()
-	err := env.gce.RemoveInstances(prefix, ids...)
+	if err := env.gce.RemoveInstances(prefix, ids...); err != nil {
+		return errors.Trace(err)
+	}
+	if len(legacyIds) == 0 {
+		return nil
+	}
+	err := env.gce.RemoveInstances(env.legacyPrefix(), legacyIds...)
 	return errors.Trace(err)
 }
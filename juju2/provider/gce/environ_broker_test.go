@@ -224,3 +224,17 @@ func (s *environBrokerSuite) TestStopInstances(c *gc.C) {
 	c.Check(calls[0].Prefix, gc.Equals, s.Prefix())
 	c.Check(calls[0].IDs, jc.DeepEquals, []string{"spam"})
 }
+
+func (s *environBrokerSuite) TestStopInstancesLegacy(c *gc.C) {
+	legacyPrefix := "juju-" + s.Env.Config().UUID() + "-machine-"
+	err := s.Env.StopInstances(s.Instance.Id(), instance.Id(legacyPrefix+"1"))
+	c.Assert(err, jc.ErrorIsNil)
+
+	called, calls := s.FakeConn.WasCalled("RemoveInstances")
+	c.Check(called, gc.Equals, true)
+	c.Check(calls, gc.HasLen, 2)
+	c.Check(calls[0].Prefix, gc.Equals, s.Prefix())
+	c.Check(calls[0].IDs, jc.DeepEquals, []string{"spam"})
+	c.Check(calls[1].Prefix, gc.Equals, legacyPrefix)
+	c.Check(calls[1].IDs, jc.DeepEquals, []string{legacyPrefix + "1"})
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/juju/errors"
+	"github.com/juju/utils/set"
 	"github.com/juju/version"
 
 	"github.com/juju/1.25-upgrade/juju2/constraints"
@@ -72,6 +73,21 @@ var getInstances = func(env *environ) ([]instance.Instance, error) {
 func (env *environ) gceInstances() ([]google.Instance, error) {
 	prefix := env.namespace.Prefix()
 	instances, err := env.gce.Instances(prefix, instStatuses...)
+	if err != nil {
+		return instances, errors.Trace(err)
+	}
+	// The instances of a model migrated from 1.25 keep the names it
+	// gave them.
+	legacy, err := env.gce.Instances(env.legacyPrefix(), instStatuses...)
+	seen := set.NewStrings()
+	for _, inst := range instances {
+		seen.Add(inst.ID)
+	}
+	for _, inst := range legacy {
+		if !seen.Contains(inst.ID) {
+			instances = append(instances, inst)
+		}
+	}
 	return instances, errors.Trace(err)
 }
 
@@ -125,6 +141,9 @@ func (env *environ) ControllerInstances(controllerUUID string) ([]instance.Id, e
 
 // AdoptResources is part of the Environ interface.
 func (env *environ) AdoptResources(controllerUUID string, fromVersion version.Number) error {
+	if environs.IsLegacyEnvironment(fromVersion) {
+		return errors.Trace(env.adoptLegacyResources(controllerUUID))
+	}
 	instances, err := env.AllInstances()
 	if err != nil {
 		return errors.Annotate(err, "all instances")
@@ -95,10 +95,27 @@ func (s *environInstSuite) TestBasicInstancesAPI(c *gc.C) {
 	_, err := gce.GetInstances(s.Env)
 	c.Assert(err, jc.ErrorIsNil)
 
-	c.Check(s.FakeConn.Calls, gc.HasLen, 1)
+	c.Check(s.FakeConn.Calls, gc.HasLen, 2)
 	c.Check(s.FakeConn.Calls[0].FuncName, gc.Equals, "Instances")
 	c.Check(s.FakeConn.Calls[0].Prefix, gc.Equals, s.Prefix())
 	c.Check(s.FakeConn.Calls[0].Statuses, jc.DeepEquals, []string{google.StatusPending, google.StatusStaging, google.StatusRunning})
+	c.Check(s.FakeConn.Calls[1].FuncName, gc.Equals, "Instances")
+	c.Check(s.FakeConn.Calls[1].Prefix, gc.Equals, "juju-"+s.Env.Config().UUID()+"-machine-")
+}
+
+func (s *environInstSuite) TestAllInstancesIncludesLegacyInstances(c *gc.C) {
+	s.PatchValue(gce.GetInstancesHook, gce.GetInstances)
+	legacy := s.NewBaseInstance(c, "juju-"+s.Env.Config().UUID()+"-machine-0")
+	s.FakeConn.Insts = []google.Instance{*s.BaseInstance, *legacy}
+
+	insts, err := s.Env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+
+	var ids []instance.Id
+	for _, inst := range insts {
+		ids = append(ids, inst.Id())
+	}
+	c.Check(ids, jc.DeepEquals, []instance.Id{"spam", instance.Id(legacy.ID)})
 }
 
 func (s *environInstSuite) TestControllerInstances(c *gc.C) {
@@ -116,10 +133,12 @@ func (s *environInstSuite) TestControllerInstancesAPI(c *gc.C) {
 	_, err := s.Env.ControllerInstances(s.ControllerUUID)
 	c.Assert(err, jc.ErrorIsNil)
 
-	c.Check(s.FakeConn.Calls, gc.HasLen, 1)
+	c.Check(s.FakeConn.Calls, gc.HasLen, 2)
 	c.Check(s.FakeConn.Calls[0].FuncName, gc.Equals, "Instances")
 	c.Check(s.FakeConn.Calls[0].Prefix, gc.Equals, s.Prefix())
 	c.Check(s.FakeConn.Calls[0].Statuses, jc.DeepEquals, []string{google.StatusPending, google.StatusStaging, google.StatusRunning})
+	c.Check(s.FakeConn.Calls[1].FuncName, gc.Equals, "Instances")
+	c.Check(s.FakeConn.Calls[1].Prefix, gc.Equals, "juju-"+s.Env.Config().UUID()+"-machine-")
 }
 
 func (s *environInstSuite) TestControllerInstancesNotBootstrapped(c *gc.C) {
@@ -217,3 +236,25 @@ func (s *environInstSuite) TestAdoptResources(c *gc.C) {
 	c.Check(call.Key, gc.Equals, tags.JujuController)
 	c.Check(call.Value, gc.Equals, "other-uuid")
 }
+
+func (s *environInstSuite) TestAdoptResourcesFromLegacyEnvironment(c *gc.C) {
+	modelUUID := s.Env.Config().UUID()
+	name := "juju-" + modelUUID + "-machine-0"
+	s.FakeConn.Insts = []google.Instance{*s.NewBaseInstance(c, name)}
+
+	err := s.Env.AdoptResources("other-uuid", version.MustParse("1.25.10"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.FakeConn.Calls, gc.HasLen, 3)
+	c.Check(s.FakeConn.Calls[0].FuncName, gc.Equals, "Instances")
+	c.Check(s.FakeConn.Calls[0].Prefix, gc.Equals, "juju-"+modelUUID+"-machine-")
+	for i, expected := range []struct{ key, value string }{
+		{tags.JujuModel, modelUUID},
+		{tags.JujuController, "other-uuid"},
+	} {
+		call := s.FakeConn.Calls[i+1]
+		c.Check(call.FuncName, gc.Equals, "UpdateMetadata")
+		c.Check(call.IDs, gc.DeepEquals, []string{name})
+		c.Check(call.Key, gc.Equals, expected.key)
+		c.Check(call.Value, gc.Equals, expected.value)
+	}
+}
@@ -20,6 +20,7 @@ var (
 	UbuntuImageBasePath                               = ubuntuImageBasePath
 	UbuntuDailyImageBasePath                          = ubuntuDailyImageBasePath
 	WindowsImageBasePath                              = windowsImageBasePath
+	GetInstancesHook                                  = &getInstances
 )
 
 func ExposeInstBase(inst instance.Instance) *google.Instance {
@@ -0,0 +1,45 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package gce
+
+import (
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju2/environs/tags"
+)
+
+// legacyPrefix returns the prefix of the names of the instances of a
+// model migrated from a Juju 1.25 environment. 1.25 named them
+// juju-<environment uuid>-machine-<id>, and the model keeps the
+// environment's UUID, so instances named this way can only be the
+// model's. Instance names can't be changed, so gceInstances lists
+// these alongside those named with the namespace prefix.
+func (env *environ) legacyPrefix() string {
+	return "juju-" + env.Config().UUID() + "-machine-"
+}
+
+// adoptLegacyResources adds the model and controller metadata to the
+// instances of a model migrated from a Juju 1.25 environment. 1.25 set
+// tags.JujuEnv to whether the instance was a state server rather than
+// to the environment UUID, so the instances are found by the name 1.25
+// gave them. Disks need nothing: both versions record the UUID in the
+// disk's description.
+func (env *environ) adoptLegacyResources(controllerUUID string) error {
+	modelUUID := env.Config().UUID()
+	instances, err := env.gce.Instances(env.legacyPrefix(), instStatuses...)
+	if err != nil {
+		return errors.Annotate(err, "listing 1.x instances")
+	}
+	if len(instances) == 0 {
+		return nil
+	}
+	ids := make([]string, len(instances))
+	for i, inst := range instances {
+		ids[i] = inst.ID
+	}
+	if err := env.gce.UpdateMetadata(tags.JujuModel, modelUUID, ids...); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(env.gce.UpdateMetadata(tags.JujuController, controllerUUID, ids...))
+}
@@ -2393,8 +2393,14 @@ func (env *maasEnviron) releaseContainerAddresses2(macAddresses []string) error
 
 // AdoptResources updates all the instances to indicate they
 // are now associated with the specified controller.
+//
+// The instances of a model migrated from a 1.x environment are found by
+// the agent name they were acquired with, which 1.x set to the
+// environment UUID that the model keeps. 1.x set no owner data on them,
+// so on MAAS 2 they're given the model's as well as the controller's.
 func (env *maasEnviron) AdoptResources(controllerUUID string, fromVersion version.Number) error {
-	if !env.usingMAAS2() {
+	legacy := environs.IsLegacyEnvironment(fromVersion)
+	if !env.usingMAAS2() && !legacy {
 		// We don't track instance -> controller for MAAS1.
 		return nil
 	}
@@ -2403,6 +2409,20 @@ func (env *maasEnviron) AdoptResources(controllerUUID string, fromVersion versio
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if legacy && len(instances) == 0 {
+		// Environments created before 1.16 acquired their instances
+		// without an agent name, and the model can't find them at
+		// all. (There's always at least the 1.x state server
+		// machine.)
+		return errors.Errorf("no machines acquired with agent name %q; the 1.x environment may predate maas-agent-name", env.uuid)
+	}
+	if !env.usingMAAS2() {
+		return nil
+	}
+	ownerData := map[string]string{tags.JujuController: controllerUUID}
+	if legacy {
+		ownerData[tags.JujuModel] = env.uuid
+	}
 	var failed []instance.Id
 	for _, instance := range instances {
 		maas2Instance, ok := instance.(*maas2Instance)
@@ -2414,7 +2434,7 @@ func (env *maasEnviron) AdoptResources(controllerUUID string, fromVersion versio
 		// previous keys unless explicitly passed with an empty
 		// string." So not passing all of the keys here is fine.
 		// https://maas.ubuntu.com/docs2.0/api.html#machine
-		err := maas2Instance.machine.SetOwnerData(map[string]string{tags.JujuController: controllerUUID})
+		err := maas2Instance.machine.SetOwnerData(ownerData)
 		if err != nil {
 			logger.Errorf("error setting controller uuid tag for %q: %v", instance.Id(), err)
 			failed = append(failed, instance.Id())
@@ -114,6 +114,17 @@ func (suite *environSuite) TestAllInstancesReturnsEmptySliceIfNoInstance(c *gc.C
 	c.Check(instances, gc.HasLen, 0)
 }
 
+func (suite *environSuite) TestAdoptResourcesFromLegacyEnvironment(c *gc.C) {
+	suite.addNode(allocatedNode)
+	err := suite.makeEnviron().AdoptResources("some-controller", version.MustParse("1.25.10"))
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (suite *environSuite) TestAdoptResourcesFromLegacyEnvironmentNoAgentName(c *gc.C) {
+	err := suite.makeEnviron().AdoptResources("some-controller", version.MustParse("1.25.10"))
+	c.Assert(err, gc.ErrorMatches, `no machines acquired with agent name ".*"; the 1.x environment may predate maas-agent-name`)
+}
+
 func (suite *environSuite) TestInstancesReturnsErrorIfPartialInstances(c *gc.C) {
 	known := suite.addNode(allocatedNode)
 	suite.addNode(`{"system_id": "test2"}`)
@@ -2106,6 +2106,32 @@ func (suite *maas2EnvironSuite) TestAdoptResourcesError(c *gc.C) {
 	})
 }
 
+func (suite *maas2EnvironSuite) TestAdoptResourcesFromLegacyEnvironment(c *gc.C) {
+	machine := newFakeMachine("big-fig-wasp", "gaudi", "good")
+	controller := newFakeController()
+	controller.machines = append(controller.machines, machine)
+	env := suite.makeEnviron(c, controller)
+	controller.machinesArgsCheck = func(args gomaasapi.MachinesArgs) {
+		c.Check(args.AgentName, gc.Equals, env.Config().UUID())
+	}
+
+	err := env.AdoptResources("some-other-controller", version.MustParse("1.25.10"))
+	c.Assert(err, jc.ErrorIsNil)
+
+	machine.CheckCallNames(c, "SetOwnerData")
+	c.Assert(machine.Calls()[0].Args[0], gc.DeepEquals, map[string]string{
+		tags.JujuController: "some-other-controller",
+		tags.JujuModel:      env.Config().UUID(),
+	})
+}
+
+func (suite *maas2EnvironSuite) TestAdoptResourcesFromLegacyEnvironmentNoAgentName(c *gc.C) {
+	env := suite.makeEnviron(c, newFakeController())
+
+	err := env.AdoptResources("some-other-controller", version.MustParse("1.25.10"))
+	c.Assert(err, gc.ErrorMatches, `no machines acquired with agent name ".*"; the 1.x environment may predate maas-agent-name`)
+}
+
 func newFakeDevice(systemID, macAddress string) *fakeDevice {
 	return &fakeDevice{
 		Stub:     &testing.Stub{},
@@ -0,0 +1,65 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/cinder"
+
+	"github.com/juju/1.25-upgrade/juju2/environs/tags"
+)
+
+// adoptLegacyResources adds the model and controller tags to the
+// servers and volumes of a model migrated from a Juju 1.x environment,
+// which were only given tags.JujuEnv (the model keeps the environment's
+// UUID). 1.x security groups are named after the environment and can't
+// be tagged; they are left for the firewaller.
+func (e *Environ) adoptLegacyResources(controllerUUID string) error {
+	modelUUID := e.ecfg().UUID()
+	newTags := map[string]string{
+		tags.JujuModel:      modelUUID,
+		tags.JujuController: controllerUUID,
+	}
+
+	servers, err := e.nova().ListServersDetail(jujuMachineFilter())
+	if err != nil {
+		return errors.Annotate(err, "listing 1.x servers")
+	}
+	var failed []string
+	for _, server := range servers {
+		if server.Metadata[tags.JujuEnv] != modelUUID || !e.isAliveServer(server) {
+			continue
+		}
+		if err := e.nova().SetServerMetadata(server.Id, newTags); err != nil {
+			logger.Errorf("error updating tags for instance %s: %v", server.Id, err)
+			failed = append(failed, server.Id)
+		}
+	}
+
+	cinderProvider, err := e.cinderProvider()
+	if errors.IsNotSupported(err) {
+		logger.Debugf("volumes not supported, not updating volume tags")
+	} else if err != nil {
+		return errors.Trace(err)
+	} else {
+		volumes, err := listVolumes(cinderProvider.storageAdapter, func(v *cinder.Volume) bool {
+			return v.Metadata[tags.JujuEnv] == modelUUID
+		})
+		if err != nil {
+			return errors.Annotate(err, "listing 1.x volumes")
+		}
+		for _, volume := range volumes {
+			_, err := cinderProvider.storageAdapter.SetVolumeMetadata(volume.VolumeId, newTags)
+			if err != nil {
+				logger.Errorf("error updating tags for volume %s: %v", volume.VolumeId, err)
+				failed = append(failed, volume.VolumeId)
+			}
+		}
+	}
+
+	if len(failed) != 0 {
+		return errors.Errorf("error updating tags for some resources: %v", failed)
+	}
+	return nil
+}
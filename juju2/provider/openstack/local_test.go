@@ -1931,6 +1931,60 @@ func (s *localServerSuite) TestAdoptResources(c *gc.C) {
 	s.checkGroupController(c, env, newController)
 }
 
+func (s *localServerSuite) TestAdoptResourcesFromLegacyEnvironment(c *gc.C) {
+	modelUUID := s.env.Config().UUID()
+	novaClient := openstack.GetNovaClient(s.env)
+	runServer := func(name, envUUID string) string {
+		server, err := novaClient.RunServer(nova.RunServerOpts{
+			Name:     name,
+			FlavorId: "1", // test service has 1,2,3 for flavor ids
+			ImageId:  "1", // UseTestImageData sets up images 1 and 2
+			Metadata: map[string]string{tags.JujuEnv: envUUID},
+		})
+		c.Assert(err, jc.ErrorIsNil)
+		return server.Id
+	}
+	// Servers and volumes as a 1.x environment would have made them.
+	serverId := runServer("juju-sample-machine-0", modelUUID)
+	otherServerId := runServer("juju-other-machine-0", "another-env")
+	for _, args := range []struct{ name, envUUID string }{
+		{"volume-0", modelUUID},
+		{"volume-1", "another-env"},
+	} {
+		_, err := s.storageAdapter.CreateVolume(cinder.CreateVolumeVolumeParams{
+			Name:     args.name,
+			Metadata: map[string]string{tags.JujuEnv: args.envUUID},
+		})
+		c.Assert(err, jc.ErrorIsNil)
+	}
+
+	newController := "aaaaaaaa-bbbb-cccc-dddd-0123456789ab"
+	err := s.env.AdoptResources(newController, version.MustParse("1.25.10"))
+	c.Assert(err, jc.ErrorIsNil)
+
+	server, err := novaClient.GetServer(serverId)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(server.Metadata, jc.DeepEquals, map[string]string{
+		tags.JujuEnv:        modelUUID,
+		tags.JujuModel:      modelUUID,
+		tags.JujuController: newController,
+	})
+	server, err = novaClient.GetServer(otherServerId)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(server.Metadata, jc.DeepEquals, map[string]string{tags.JujuEnv: "another-env"})
+
+	volume, err := s.storageAdapter.GetVolume("volume-0")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(volume.Metadata, jc.DeepEquals, map[string]string{
+		tags.JujuEnv:        modelUUID,
+		tags.JujuModel:      modelUUID,
+		tags.JujuController: newController,
+	})
+	volume, err = s.storageAdapter.GetVolume("volume-1")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(volume.Metadata, jc.DeepEquals, map[string]string{tags.JujuEnv: "another-env"})
+}
+
 func addVolume(c *gc.C, env environs.Environ, controllerUUID, name string) {
 	storageAdapter, err := (*openstack.NewOpenstackStorage)(env.(*openstack.Environ))
 	c.Assert(err, jc.ErrorIsNil)
@@ -1263,6 +1263,9 @@ func (e *Environ) Instances(ids []instance.Id) ([]instance.Instance, error) {
 
 // AdoptResources is part of the Environ interface.
 func (e *Environ) AdoptResources(controllerUUID string, fromVersion version.Number) error {
+	if environs.IsLegacyEnvironment(fromVersion) {
+		return errors.Trace(e.adoptLegacyResources(controllerUUID))
+	}
 	var failed []string
 	controllerTag := map[string]string{tags.JujuController: controllerUUID}
 
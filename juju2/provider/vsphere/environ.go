@@ -124,6 +124,9 @@ var DestroyEnv = common.Destroy
 
 // AdoptResources is part of the Environ interface.
 func (env *environ) AdoptResources(controllerUUID string, fromVersion version.Number) error {
+	if environs.IsLegacyEnvironment(fromVersion) {
+		return errors.Trace(env.adoptLegacyResources())
+	}
 	// This provider doesn't track instance -> controller.
 	return nil
 }
@@ -56,19 +56,21 @@ func (env *environ) Instances(ids []instance.Id) ([]instance.Instance, error) {
 }
 
 // instances returns a list of all "alive" instances in the environment.
-// This means only instances where the IDs match
-// "juju-<env name>-machine-*". This is important because otherwise juju
-// will see they are not tracked in state, assume they're stale/rogue,
-// and shut them down.
+// This means only instances where the IDs match the namespace prefix,
+// or the names given by 1.25 (see legacyPrefix). This is important
+// because otherwise juju will see they are not tracked in state, assume
+// they're stale/rogue, and shut them down.
 func (env *environ) instances() ([]instance.Instance, error) {
-	prefix := env.namespace.Prefix()
-	instances, err := env.client.Instances(prefix)
+	instances, err := env.client.Instances("juju-")
 	err = errors.Trace(err)
 
 	// Turn mo.VirtualMachine values into *environInstance values,
 	// whether or not we got an error.
 	var results []instance.Instance
 	for _, base := range instances {
+		if !env.ownsVM(base.Name) {
+			continue
+		}
 		inst := newInstance(base, env)
 		results = append(results, inst)
 	}
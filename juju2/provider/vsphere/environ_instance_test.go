@@ -7,6 +7,7 @@ package vsphere_test
 
 import (
 	jc "github.com/juju/testing/checkers"
+	"github.com/juju/version"
 	gc "gopkg.in/check.v1"
 
 	"github.com/juju/1.25-upgrade/juju2/environs"
@@ -79,3 +80,51 @@ func (s *environInstanceSuite) TestInstancesReturnPartialInstances(c *gc.C) {
 
 	c.Assert(err, gc.Equals, environs.ErrPartialInstances)
 }
+
+func (s *environInstanceSuite) TestAdoptResourcesFromLegacyEnvironment(c *gc.C) {
+	client, closer, err := vsphere.ExposeEnvFakeClient(s.Env)
+	c.Assert(err, jc.ErrorIsNil)
+	defer closer()
+	s.FakeClient = client
+	client.SetPropertyProxyHandler("FakeDatacenter", vsphere.RetrieveDatacenterProperties)
+	legacyName := "juju-" + s.Env.Config().UUID() + "-machine-0"
+	s.FakeInstancesWithResourcePool(client, vsphere.InstRp{Inst: legacyName, Rp: "rp1"})
+
+	err = s.Env.AdoptResources("some-controller", version.MustParse("1.25.10"))
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *environInstanceSuite) TestInstancesIncludesLegacyInstances(c *gc.C) {
+	client, closer, err := vsphere.ExposeEnvFakeClient(s.Env)
+	c.Assert(err, jc.ErrorIsNil)
+	defer closer()
+	s.FakeClient = client
+	client.SetPropertyProxyHandler("FakeDatacenter", vsphere.RetrieveDatacenterProperties)
+	legacyName := "juju-" + s.Env.Config().UUID() + "-machine-0"
+	vmName := s.machineName(c, "1")
+	s.FakeInstancesWithResourcePool(client,
+		vsphere.InstRp{Inst: legacyName, Rp: "rp1"},
+		vsphere.InstRp{Inst: vmName, Rp: "rp1"},
+		vsphere.InstRp{Inst: "juju-other-machine-0", Rp: "rp1"},
+	)
+
+	instances, err := s.Env.AllInstances()
+	c.Assert(err, jc.ErrorIsNil)
+	var ids []instance.Id
+	for _, inst := range instances {
+		ids = append(ids, inst.Id())
+	}
+	c.Assert(ids, jc.SameContents, []instance.Id{instance.Id(legacyName), instance.Id(vmName)})
+}
+
+func (s *environInstanceSuite) TestAdoptResourcesFromLegacyEnvironmentNoInstances(c *gc.C) {
+	client, closer, err := vsphere.ExposeEnvFakeClient(s.Env)
+	c.Assert(err, jc.ErrorIsNil)
+	defer closer()
+	s.FakeClient = client
+	client.SetPropertyProxyHandler("FakeDatacenter", vsphere.RetrieveDatacenterProperties)
+	s.FakeInstancesWithResourcePool(client, vsphere.InstRp{Inst: s.machineName(c, "0"), Rp: "rp1"})
+
+	err = s.Env.AdoptResources("some-controller", version.MustParse("1.25.10"))
+	c.Assert(err, jc.ErrorIsNil)
+}
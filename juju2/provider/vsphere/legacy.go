@@ -0,0 +1,42 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build !gccgo
+
+package vsphere
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// legacyPrefix returns the prefix of the names of the VMs of a model
+// migrated from a Juju 1.25 environment. 1.25 named them
+// juju-<environment uuid>-machine-<id>, and the model keeps the
+// environment's UUID, so VMs named this way can only be the model's.
+func (env *environ) legacyPrefix() string {
+	return "juju-" + env.Config().UUID() + "-machine-"
+}
+
+// ownsVM returns whether the VM with the given name is one of the
+// model's: named with its namespace prefix, or by 1.25.
+func (env *environ) ownsVM(name string) bool {
+	return strings.HasPrefix(name, env.namespace.Prefix()) ||
+		strings.HasPrefix(name, env.legacyPrefix())
+}
+
+// adoptLegacyResources adopts the VMs of a model migrated from a Juju
+// 1.25 environment. The VMs can't be renamed, as their names are their
+// instance ids, so instances lists them alongside those named with the
+// namespace prefix. 1.25 recorded nothing else about their environment
+// or controller, and this provider doesn't track instance -> controller,
+// so there's nothing to update; they're only counted here.
+func (env *environ) adoptLegacyResources() error {
+	vms, err := env.client.Instances(env.legacyPrefix())
+	if err != nil {
+		return errors.Annotate(err, "listing 1.x instances")
+	}
+	logger.Infof("adopted %d instances named %q*", len(vms), env.legacyPrefix())
+	return nil
+}
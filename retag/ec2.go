@@ -0,0 +1,29 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retag
+
+import (
+	"github.com/juju/errors"
+	"gopkg.in/amz.v3/aws"
+	"gopkg.in/amz.v3/ec2"
+)
+
+// ec2InstanceStates are the states of instances whose groups are
+// migrated; terminated instances are gone as far as juju is concerned.
+var ec2InstanceStates = []string{"pending", "running", "stopping", "stopped"}
+
+// newEC2Client returns a client for the region of the 1.25 environment,
+// using the credentials in its config attributes.
+func newEC2Client(attrs map[string]interface{}) (*ec2.EC2, error) {
+	regionName := attrString(attrs, "region")
+	region, ok := aws.Regions[regionName]
+	if !ok {
+		return nil, errors.NotFoundf("ec2 region %q", regionName)
+	}
+	auth := aws.Auth{
+		AccessKey: attrString(attrs, "access-key"),
+		SecretKey: attrString(attrs, "secret-key"),
+	}
+	return ec2.New(auth, region, aws.SignV4Factory(region.Name, "ec2")), nil
+}
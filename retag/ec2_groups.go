@@ -0,0 +1,179 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retag
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/amz.v3/ec2"
+)
+
+// NewEC2GroupMigrator returns a GroupMigrator for EC2 security groups,
+// using the given client.
+func NewEC2GroupMigrator(client *ec2.EC2) GroupMigrator {
+	return &ec2GroupMigrator{client: client}
+}
+
+type ec2GroupMigrator struct {
+	client *ec2.EC2
+}
+
+// ec2Group is a 1.25 security group and its 2.x counterpart.
+type ec2Group struct {
+	legacy ec2.SecurityGroupInfo
+	group  ec2.SecurityGroup
+}
+
+// MigrateGroups is part of the GroupMigrator interface. EC2 groups
+// can't be renamed, so a group with the 2.x name (juju-<model uuid> and
+// so on) is created for each 1.25 group attached to the environment's
+// instances, tagged for the model and controller, with the same
+// permissions. Permissions granted to one of the 1.25 groups are
+// granted to its counterpart instead.
+//
+// The groups of a running instance can only be changed in a VPC, and
+// the client can't do it at all, so the 1.25 groups are left attached
+// and each instance still missing a new group fails, naming the groups
+// it needs. Attaching them alongside the 1.25 groups (with "aws ec2
+// modify-instance-attribute --groups", say) and running this again
+// clears the failures.
+func (m *ec2GroupMigrator) MigrateGroups(envName, envUUID, controllerUUID string) ([]Result, error) {
+	filter := ec2.NewFilter()
+	filter.Add("tag:"+envTag, envUUID)
+	filter.Add("instance-state-name", ec2InstanceStates...)
+	instances, err := m.client.Instances(nil, filter)
+	if err != nil {
+		return nil, errors.Annotate(err, "listing instances")
+	}
+	resp, err := m.client.SecurityGroups(nil, nil)
+	if err != nil {
+		return nil, errors.Annotate(err, "listing security groups")
+	}
+	attached := make(map[string]bool)
+	for _, reservation := range instances.Reservations {
+		for _, inst := range reservation.Instances {
+			for _, g := range inst.SecurityGroups {
+				attached[g.Id] = true
+			}
+		}
+	}
+
+	// Create the groups first, so that permissions granted to
+	// the 1.25 groups can be granted to their counterparts.
+	var results []Result
+	groups := make(map[string]*ec2Group)
+	for _, info := range resp.Groups {
+		suffix, ok := legacyGroupSuffix(info.Name, envName)
+		if !ok || !attached[info.Id] {
+			continue
+		}
+		name := "juju-" + envUUID + suffix
+		logger.Debugf("creating security group %s for %s", name, info.Name)
+		group, err := m.ensureGroup(name, envUUID, controllerUUID)
+		if err != nil {
+			results = append(results, Result{Kind: "security group", ID: name, Error: err})
+			continue
+		}
+		groups[info.Id] = &ec2Group{legacy: info, group: group}
+	}
+	for _, g := range groups {
+		err := m.authorize(g.group, g.legacy.IPPerms, groups)
+		results = append(results, Result{Kind: "security group", ID: g.group.Name, Error: err})
+	}
+
+	for _, reservation := range instances.Reservations {
+		for _, inst := range reservation.Instances {
+			if missing := missingGroups(inst, groups); len(missing) > 0 {
+				results = append(results, Result{
+					Kind: "instance",
+					ID:   inst.InstanceId,
+					Error: errors.Errorf("attach security groups %s alongside the 1.25 ones",
+						strings.Join(missing, ", ")),
+				})
+			}
+		}
+	}
+	return results, nil
+}
+
+// ensureGroup returns the group with the given name, creating it if it
+// doesn't exist, and tags it. 1.25 has no vpc-id setting, so its
+// groups, and their counterparts, are in EC2-classic or the default VPC.
+func (m *ec2GroupMigrator) ensureGroup(name, envUUID, controllerUUID string) (ec2.SecurityGroup, error) {
+	var group ec2.SecurityGroup
+	resp, err := m.client.CreateSecurityGroup("", name, "juju group")
+	switch {
+	case ec2ErrCode(err) == "InvalidGroup.Duplicate":
+		// Created by an earlier run, which may have failed to tag
+		// it, so it's tagged again below.
+		existing, err := m.client.SecurityGroups(ec2.SecurityGroupNames(name), nil)
+		if err != nil {
+			return ec2.SecurityGroup{}, errors.Annotatef(err, "fetching security group %q", name)
+		}
+		if len(existing.Groups) != 1 {
+			return ec2.SecurityGroup{}, errors.Errorf(
+				"expected one security group named %q, got %d", name, len(existing.Groups),
+			)
+		}
+		group = existing.Groups[0].SecurityGroup
+	case err != nil:
+		return ec2.SecurityGroup{}, errors.Annotatef(err, "creating security group %q", name)
+	default:
+		group = resp.SecurityGroup
+	}
+	var ec2Tags []ec2.Tag
+	for k, v := range Tags(envUUID, controllerUUID) {
+		ec2Tags = append(ec2Tags, ec2.Tag{k, v})
+	}
+	if _, err := m.client.CreateTags([]string{group.Id}, ec2Tags); err != nil {
+		return group, errors.Annotatef(err, "tagging security group %q", name)
+	}
+	return group, nil
+}
+
+// authorize grants the given permissions of a 1.25 group to its
+// counterpart, one at a time so that those granted by an earlier run
+// can be skipped.
+func (m *ec2GroupMigrator) authorize(group ec2.SecurityGroup, perms []ec2.IPPerm, groups map[string]*ec2Group) error {
+	for _, perm := range perms {
+		var sourceGroups []ec2.UserSecurityGroup
+		for _, source := range perm.SourceGroups {
+			if g, ok := groups[source.Id]; ok {
+				source = ec2.UserSecurityGroup{Id: g.group.Id}
+			}
+			sourceGroups = append(sourceGroups, source)
+		}
+		perm.SourceGroups = sourceGroups
+		_, err := m.client.AuthorizeSecurityGroup(group, []ec2.IPPerm{perm})
+		if err != nil && ec2ErrCode(err) != "InvalidPermission.Duplicate" {
+			return errors.Annotatef(err, "authorizing security group %q", group.Name)
+		}
+	}
+	return nil
+}
+
+// missingGroups returns the names of the 2.x groups that the instance
+// needs, but doesn't have.
+func missingGroups(inst ec2.Instance, groups map[string]*ec2Group) []string {
+	has := make(map[string]bool)
+	for _, g := range inst.SecurityGroups {
+		has[g.Id] = true
+	}
+	var missing []string
+	for _, g := range inst.SecurityGroups {
+		if counterpart, ok := groups[g.Id]; ok && !has[counterpart.group.Id] {
+			missing = append(missing, counterpart.group.Name)
+		}
+	}
+	return missing
+}
+
+// ec2ErrCode returns the error code of an EC2 error, or "".
+func ec2ErrCode(err error) string {
+	if err, ok := errors.Cause(err).(*ec2.Error); ok {
+		return err.Code
+	}
+	return ""
+}
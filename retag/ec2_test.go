@@ -0,0 +1,132 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retag_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	"gopkg.in/amz.v3/aws"
+	"gopkg.in/amz.v3/ec2"
+	"gopkg.in/amz.v3/ec2/ec2test"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/1.25-upgrade/retag"
+)
+
+const (
+	envUUID        = "deadbeef-0bad-400d-8000-4b1d0d06f00d"
+	otherEnvUUID   = "deadbeef-0bad-400d-8000-5b1d0d06f00d"
+	controllerUUID = "c0ffee00-0bad-400d-8000-4b1d0d06f00d"
+	imageId        = "ami-ccf405a5"
+)
+
+type ec2Suite struct {
+	srv    *ec2test.Server
+	client *ec2.EC2
+}
+
+var _ = gc.Suite(&ec2Suite{})
+
+func (s *ec2Suite) SetUpTest(c *gc.C) {
+	srv, err := ec2test.NewServer()
+	c.Assert(err, jc.ErrorIsNil)
+	s.srv = srv
+	region := aws.Region{Name: "test", EC2Endpoint: srv.URL()}
+	s.client = ec2.New(aws.Auth{}, region, aws.SignV4Factory(region.Name, "ec2"))
+}
+
+func (s *ec2Suite) TearDownTest(c *gc.C) {
+	s.srv.Quit()
+}
+
+func (s *ec2Suite) createGroup(c *gc.C, name string, perms ...ec2.IPPerm) ec2.SecurityGroup {
+	resp, err := s.client.CreateSecurityGroup("", name, "juju group")
+	c.Assert(err, jc.ErrorIsNil)
+	if len(perms) > 0 {
+		_, err = s.client.AuthorizeSecurityGroup(resp.SecurityGroup, perms)
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	return resp.SecurityGroup
+}
+
+func (s *ec2Suite) group(c *gc.C, name string) ec2.SecurityGroupInfo {
+	resp, err := s.client.SecurityGroups(ec2.SecurityGroupNames(name), nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(resp.Groups, gc.HasLen, 1)
+	return resp.Groups[0]
+}
+
+func (s *ec2Suite) TestMigrateGroups(c *gc.C) {
+	ssh := ec2.IPPerm{Protocol: "tcp", FromPort: 22, ToPort: 22, SourceIPs: []string{"0.0.0.0/0"}}
+	http := ec2.IPPerm{Protocol: "tcp", FromPort: 80, ToPort: 80, SourceIPs: []string{"0.0.0.0/0"}}
+	envGroup := s.createGroup(c, "juju-test", ssh)
+	_, err := s.client.AuthorizeSecurityGroup(envGroup, []ec2.IPPerm{{
+		Protocol:     "tcp",
+		FromPort:     0,
+		ToPort:       65535,
+		SourceGroups: []ec2.UserSecurityGroup{{Id: envGroup.Id}},
+	}})
+	c.Assert(err, jc.ErrorIsNil)
+	machineGroup := s.createGroup(c, "juju-test-0", http)
+	// The groups of a machine that's gone, and of another
+	// environment whose name starts the same way.
+	s.createGroup(c, "juju-test-1")
+	otherGroup := s.createGroup(c, "juju-test-other")
+	// A group left untagged by an earlier run.
+	s.createGroup(c, "juju-"+envUUID)
+
+	ids := s.srv.NewInstances(1, "m1.medium", imageId, ec2test.Running, []ec2.SecurityGroup{envGroup, machineGroup})
+	_, err = s.client.CreateTags(ids, []ec2.Tag{{"juju-env-uuid", envUUID}})
+	c.Assert(err, jc.ErrorIsNil)
+	otherIds := s.srv.NewInstances(1, "m1.medium", imageId, ec2test.Running, []ec2.SecurityGroup{otherGroup})
+	_, err = s.client.CreateTags(otherIds, []ec2.Tag{{"juju-env-uuid", otherEnvUUID}})
+	c.Assert(err, jc.ErrorIsNil)
+
+	migrator := retag.NewEC2GroupMigrator(s.client)
+	for i := 0; i < 2; i++ {
+		results, err := migrator.MigrateGroups("test", envUUID, controllerUUID)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(results, gc.HasLen, 3)
+		failed := retag.Failed(results)
+		c.Assert(failed, gc.HasLen, 1)
+		c.Check(failed[0].Kind, gc.Equals, "instance")
+		c.Check(failed[0].ID, gc.Equals, ids[0])
+		c.Check(failed[0].Error, gc.ErrorMatches, "attach security groups juju-"+envUUID+".* alongside the 1.25 ones")
+	}
+
+	newEnvGroup := s.group(c, "juju-"+envUUID)
+	newMachineGroup := s.group(c, "juju-"+envUUID+"-0")
+	filter := ec2.NewFilter()
+	filter.Add("tag:juju-model-uuid", envUUID)
+	filter.Add("tag:juju-controller-uuid", controllerUUID)
+	tagged, err := s.client.SecurityGroups(nil, filter)
+	c.Assert(err, jc.ErrorIsNil)
+	var taggedIds []string
+	for _, g := range tagged.Groups {
+		taggedIds = append(taggedIds, g.Id)
+	}
+	c.Check(taggedIds, jc.SameContents, []string{newEnvGroup.Id, newMachineGroup.Id})
+
+	c.Assert(newEnvGroup.IPPerms, gc.HasLen, 2)
+	for _, perm := range newEnvGroup.IPPerms {
+		if len(perm.SourceGroups) == 0 {
+			c.Check(perm.SourceIPs, jc.DeepEquals, ssh.SourceIPs)
+			continue
+		}
+		c.Assert(perm.SourceGroups, gc.HasLen, 1)
+		c.Check(perm.SourceGroups[0].Id, gc.Equals, newEnvGroup.Id)
+	}
+	c.Assert(newMachineGroup.IPPerms, gc.HasLen, 1)
+	c.Check(newMachineGroup.IPPerms[0].FromPort, gc.Equals, 80)
+
+	resp, err := s.client.SecurityGroups(nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	var names []string
+	for _, g := range resp.Groups {
+		names = append(names, g.Name)
+	}
+	c.Check(names, jc.SameContents, []string{
+		"default", "juju-test", "juju-test-0", "juju-test-1", "juju-test-other",
+		"juju-" + envUUID, "juju-" + envUUID + "-0",
+	})
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retag
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/1.25-upgrade/juju1/environs/config"
+)
+
+// GroupMigrator moves the security groups of a 1.25 environment to the
+// names the 2.x firewaller expects.
+//
+// 1.25 names the groups after the environment: juju-<env name> for the
+// rules common to all machines, juju-<env name>-global for the opened
+// ports in global firewall mode, and juju-<env name>-<machine id> for
+// each machine's opened ports in instance mode. The 2.x firewaller
+// looks the groups up by names derived from the model (and, on
+// openstack, controller) UUID instead, and without them it creates
+// empty groups or fails to open ports. The opened ports are kept, so
+// that there's no window in which they are closed.
+type GroupMigrator interface {
+	// MigrateGroups moves each of the environment's groups to its
+	// 2.x name, for the model with the environment's UUID, managed
+	// by the controller with the given UUID. It returns a result
+	// for every group, and for every instance that still needs
+	// attention; an error is only returned if the groups couldn't
+	// be listed.
+	MigrateGroups(envName, envUUID, controllerUUID string) ([]Result, error)
+}
+
+// NewGroupMigrator returns a GroupMigrator for the cloud of the 1.25
+// environment with the given config, using the credentials in it.
+func NewGroupMigrator(cfg *config.Config) (GroupMigrator, error) {
+	attrs := cfg.UnknownAttrs()
+	switch cfg.Type() {
+	case "ec2":
+		client, err := newEC2Client(attrs)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewEC2GroupMigrator(client), nil
+	case "openstack":
+		authClient, err := newOpenstackClient(attrs, cfg.SSLHostnameVerification())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewOpenstackGroupMigrator(authClient)
+	}
+	return nil, errors.NotSupportedf("migrating security groups of %q environments", cfg.Type())
+}
+
+// legacyGroupSuffix returns the part of the name of a 1.25 group of the
+// named environment that follows juju-<env name>: "", "-global" or
+// "-<machine id>". It returns false if the group isn't one of the
+// environment's; other environments' names may share a prefix with it.
+func legacyGroupSuffix(groupName, envName string) (string, bool) {
+	re := regexp.MustCompile(fmt.Sprintf(`^juju-%s(-global|-\d+)?$`, regexp.QuoteMeta(envName)))
+	match := re.FindStringSubmatch(groupName)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retag
+
+import (
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/client"
+	"gopkg.in/goose.v1/identity"
+)
+
+// newOpenstackClient returns an unauthenticated client for the cloud of
+// the 1.25 environment with the given config attributes.
+func newOpenstackClient(attrs map[string]interface{}, sslHostnameVerification bool) (client.AuthenticatingClient, error) {
+	cred := &identity.Credentials{
+		User:       attrString(attrs, "username"),
+		Secrets:    attrString(attrs, "password"),
+		Region:     attrString(attrs, "region"),
+		TenantName: attrString(attrs, "tenant-name"),
+		URL:        attrString(attrs, "auth-url"),
+	}
+	// As in the 1.25 provider's authClient.
+	var authMode identity.AuthMode
+	switch mode := attrString(attrs, "auth-mode"); mode {
+	case "legacy":
+		authMode = identity.AuthLegacy
+	case "userpass":
+		authMode = identity.AuthUserPass
+	case "keypair":
+		authMode = identity.AuthKeyPair
+		cred.User = attrString(attrs, "access-key")
+		cred.Secrets = attrString(attrs, "secret-key")
+	default:
+		return nil, errors.NotValidf("auth-mode %q", mode)
+	}
+	newClient := client.NewClient
+	if !sslHostnameVerification {
+		newClient = client.NewNonValidatingClient
+	}
+	authClient := newClient(cred, authMode, nil)
+	authClient.SetRequiredServiceTypes([]string{"compute"})
+	return authClient, nil
+}
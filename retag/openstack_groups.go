@@ -0,0 +1,64 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retag
+
+import (
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/client"
+	"gopkg.in/goose.v1/nova"
+)
+
+// NewOpenstackGroupMigrator returns a GroupMigrator for nova security
+// groups, using the given client.
+func NewOpenstackGroupMigrator(authClient client.AuthenticatingClient) (GroupMigrator, error) {
+	if err := authClient.Authenticate(); err != nil {
+		return nil, errors.Annotate(err, "authenticating")
+	}
+	return &openstackGroupMigrator{nova: nova.New(authClient)}, nil
+}
+
+type openstackGroupMigrator struct {
+	nova *nova.Client
+}
+
+// MigrateGroups is part of the GroupMigrator interface. The 1.25 groups
+// attached to the environment's servers are renamed in place to
+// juju-<controller uuid>-<model uuid> and so on, so their rules, and the
+// servers they're attached to, are untouched. Groups that were renamed
+// by an earlier run aren't the environment's any more, and are skipped.
+func (m *openstackGroupMigrator) MigrateGroups(envName, envUUID, controllerUUID string) ([]Result, error) {
+	servers, err := m.nova.ListServersDetail(nil)
+	if err != nil {
+		return nil, errors.Annotate(err, "listing servers")
+	}
+	groups := make(map[string]nova.SecurityGroup)
+	for _, server := range servers {
+		if server.Metadata[envTag] != envUUID || server.Status == nova.StatusDeleted {
+			continue
+		}
+		serverGroups, err := m.nova.GetServerSecurityGroups(server.Id)
+		if err != nil {
+			return nil, errors.Annotatef(err, "listing security groups of server %s", server.Id)
+		}
+		for _, group := range serverGroups {
+			groups[group.Id] = group
+		}
+	}
+
+	var results []Result
+	for _, group := range groups {
+		suffix, ok := legacyGroupSuffix(group.Name, envName)
+		if !ok {
+			continue
+		}
+		name := "juju-" + controllerUUID + "-" + envUUID + suffix
+		logger.Debugf("renaming security group %s to %s", group.Name, name)
+		_, err := m.nova.UpdateSecurityGroup(group.Id, name, group.Description)
+		if err != nil {
+			err = errors.Annotatef(err, "renaming security group %q", group.Name)
+		}
+		results = append(results, Result{Kind: "security group", ID: name, Error: err})
+	}
+	return results, nil
+}
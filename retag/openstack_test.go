@@ -0,0 +1,100 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retag_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/goose.v1/client"
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/nova"
+	"gopkg.in/goose.v1/testservices/openstackservice"
+
+	"github.com/juju/1.25-upgrade/retag"
+)
+
+type openstackSuite struct {
+	service *openstackservice.Openstack
+	client  client.AuthenticatingClient
+	nova    *nova.Client
+}
+
+var _ = gc.Suite(&openstackSuite{})
+
+func (s *openstackSuite) SetUpTest(c *gc.C) {
+	cred := &identity.Credentials{
+		User:       "fred",
+		Secrets:    "secret",
+		Region:     "some-region",
+		TenantName: "some tenant",
+	}
+	// New points cred.URL at the service.
+	s.service, _ = openstackservice.New(cred, identity.AuthUserPass, false)
+	s.service.SetupHTTP(nil)
+	s.client = client.NewClient(cred, identity.AuthUserPass, nil)
+	s.nova = nova.New(s.client)
+}
+
+func (s *openstackSuite) TearDownTest(c *gc.C) {
+	s.service.Stop()
+}
+
+func (s *openstackSuite) addServer(c *gc.C, name string, metadata map[string]string, groups ...nova.SecurityGroupName) string {
+	entity, err := s.nova.RunServer(nova.RunServerOpts{
+		Name:               name,
+		FlavorId:           "1", // the test service has flavors 1, 2 and 3
+		ImageId:            "1",
+		SecurityGroupNames: groups,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.nova.SetServerMetadata(entity.Id, metadata)
+	c.Assert(err, jc.ErrorIsNil)
+	return entity.Id
+}
+
+func (s *openstackSuite) addGroup(c *gc.C, name string) nova.SecurityGroupName {
+	_, err := s.nova.CreateSecurityGroup(name, "juju group")
+	c.Assert(err, jc.ErrorIsNil)
+	return nova.SecurityGroupName{name}
+}
+
+func (s *openstackSuite) TestMigrateGroups(c *gc.C) {
+	envGroup := s.addGroup(c, "juju-test")
+	machineGroup := s.addGroup(c, "juju-test-0")
+	s.addGroup(c, "juju-test-1")
+	otherGroup := s.addGroup(c, "juju-test-other")
+	s.addServer(c, "juju-test-machine-0", map[string]string{
+		"juju-env-uuid": envUUID,
+	}, envGroup, machineGroup)
+	s.addServer(c, "juju-test-other-machine-0", map[string]string{
+		"juju-env-uuid": otherEnvUUID,
+	}, otherGroup)
+
+	migrator, err := retag.NewOpenstackGroupMigrator(s.client)
+	c.Assert(err, jc.ErrorIsNil)
+	results, err := migrator.MigrateGroups("test", envUUID, controllerUUID)
+	c.Assert(err, jc.ErrorIsNil)
+	prefix := "juju-" + controllerUUID + "-" + envUUID
+	c.Check(results, jc.SameContents, []retag.Result{
+		{Kind: "security group", ID: prefix},
+		{Kind: "security group", ID: prefix + "-0"},
+	})
+
+	// The groups were renamed, so there's nothing left to do.
+	results, err = migrator.MigrateGroups("test", envUUID, controllerUUID)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(results, gc.HasLen, 0)
+
+	groups, err := s.nova.ListSecurityGroups()
+	c.Assert(err, jc.ErrorIsNil)
+	names := make(map[string]bool)
+	for _, group := range groups {
+		names[group.Name] = true
+	}
+	for _, name := range []string{"juju-test-1", "juju-test-other", prefix, prefix + "-0"} {
+		c.Check(names[name], jc.IsTrue, gc.Commentf("group %q", name))
+	}
+	c.Check(names["juju-test"], jc.IsFalse)
+	c.Check(names["juju-test-0"], jc.IsFalse)
+}
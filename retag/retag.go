@@ -0,0 +1,185 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package retag finds the cloud resources of a 1.25 environment and tags
+// them for the 2.x model and controller it was migrated to, and moves
+// its security groups to their 2.x names.
+//
+// 1.25 tags instances and volumes with juju-env-uuid, whereas the 2.x
+// instance poller, firewaller and destroy-model look for
+// juju-model-uuid and juju-controller-uuid. The model keeps the
+// environment's UUID through the migration, so the model tag has the
+// same value as the 1.25 tag.
+package retag
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+	"github.com/juju/loggo"
+	"github.com/juju/version"
+
+	"github.com/juju/1.25-upgrade/juju1/environs/config"
+	tags1 "github.com/juju/1.25-upgrade/juju1/environs/tags"
+	"github.com/juju/1.25-upgrade/juju2/cloud"
+	"github.com/juju/1.25-upgrade/juju2/environs"
+	config2 "github.com/juju/1.25-upgrade/juju2/environs/config"
+	tags2 "github.com/juju/1.25-upgrade/juju2/environs/tags"
+
+	// The providers that can adopt the resources of 1.25 environments.
+	_ "github.com/juju/1.25-upgrade/juju2/provider/ec2"
+	_ "github.com/juju/1.25-upgrade/juju2/provider/gce"
+	_ "github.com/juju/1.25-upgrade/juju2/provider/openstack"
+)
+
+var logger = loggo.GetLogger("upgrader.retag")
+
+// Result records the outcome of migrating one resource.
+type Result struct {
+	// Kind is the kind of resource, such as "security group".
+	Kind string
+
+	// ID is the provider's ID for the resource.
+	ID string
+
+	// Error is set if the resource couldn't be migrated.
+	Error error
+}
+
+func (r Result) String() string {
+	if r.Error != nil {
+		return fmt.Sprintf("%s %s: %v", r.Kind, r.ID, r.Error)
+	}
+	return fmt.Sprintf("%s %s", r.Kind, r.ID)
+}
+
+// Failed returns the results for resources that couldn't be migrated.
+func Failed(results []Result) []Result {
+	var failed []Result
+	for _, r := range results {
+		if r.Error != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// Tags returns the 2.x tags identifying resources of the model with the
+// given UUID, managed by the controller with the given UUID.
+func Tags(modelUUID, controllerUUID string) map[string]string {
+	return map[string]string{
+		tags2.JujuModel:      modelUUID,
+		tags2.JujuController: controllerUUID,
+	}
+}
+
+// envTag is the tag (or metadata key) 1.25 sets to the environment UUID.
+const envTag = tags1.JujuEnv
+
+// Retag tags the cloud resources of the 1.25 environment with the
+// given config for the 2.x model (of the same UUID) and the controller
+// with the given UUID. It's done by the 2.x provider, as when a model
+// is migrated between 2.x controllers, which finds the resources by
+// the environment's 1.25 tags and names. The 1.25 tags are left in
+// place, so that the 1.25 environment still sees them until it is
+// decommissioned.
+func Retag(cfg *config.Config, controllerUUID string) error {
+	fromVersion, ok := cfg.AgentVersion()
+	if !ok {
+		return errors.New("environment has no agent version")
+	}
+	legacyVersion, err := version.Parse(fromVersion.String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !environs.IsLegacyEnvironment(legacyVersion) {
+		return errors.Errorf("environment version %s is not 1.25", fromVersion)
+	}
+	env, err := openEnviron(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotate(env.AdoptResources(controllerUUID, legacyVersion), "adopting resources")
+}
+
+// openEnviron opens the 2.x environ for the cloud of the 1.25
+// environment with the given config, using the credentials in it.
+func openEnviron(cfg *config.Config) (environs.Environ, error) {
+	spec, err := cloudSpec(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	uuid, _ := cfg.UUID()
+	modelConfig, err := config2.New(config2.UseDefaults, map[string]interface{}{
+		config2.NameKey:             cfg.Name(),
+		config2.UUIDKey:             uuid,
+		config2.TypeKey:             cfg.Type(),
+		"ssl-hostname-verification": cfg.SSLHostnameVerification(),
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "making model config")
+	}
+	env, err := environs.New(environs.OpenParams{Cloud: spec, Config: modelConfig})
+	return env, errors.Annotate(err, "opening environ")
+}
+
+// cloudSpec returns the 2.x cloud spec for the cloud of the 1.25
+// environment with the given config, which kept the region, endpoint
+// and credentials in the environment config.
+func cloudSpec(cfg *config.Config) (environs.CloudSpec, error) {
+	attrs := cfg.UnknownAttrs()
+	spec := environs.CloudSpec{
+		Type:   cfg.Type(),
+		Name:   cfg.Type(),
+		Region: attrString(attrs, "region"),
+	}
+	var cred cloud.Credential
+	switch cfg.Type() {
+	case "ec2":
+		cred = cloud.NewCredential(cloud.AccessKeyAuthType, map[string]string{
+			"access-key": attrString(attrs, "access-key"),
+			"secret-key": attrString(attrs, "secret-key"),
+		})
+	case "openstack":
+		spec.Endpoint = attrString(attrs, "auth-url")
+		switch mode := attrString(attrs, "auth-mode"); mode {
+		case "userpass":
+			cred = cloud.NewCredential(cloud.UserPassAuthType, map[string]string{
+				"username":    attrString(attrs, "username"),
+				"password":    attrString(attrs, "password"),
+				"tenant-name": attrString(attrs, "tenant-name"),
+			})
+		case "keypair":
+			cred = cloud.NewCredential(cloud.AccessKeyAuthType, map[string]string{
+				"access-key":  attrString(attrs, "access-key"),
+				"secret-key":  attrString(attrs, "secret-key"),
+				"tenant-name": attrString(attrs, "tenant-name"),
+			})
+		default:
+			// The 2.x provider only authenticates with keystone.
+			return spec, errors.NotSupportedf("re-tagging with auth-mode %q", mode)
+		}
+	case "gce":
+		cred = cloud.NewCredential(cloud.OAuth2AuthType, map[string]string{
+			"client-id":    attrString(attrs, "client-id"),
+			"client-email": attrString(attrs, "client-email"),
+			"private-key":  attrString(attrs, "private-key"),
+			"project-id":   attrString(attrs, "project-id"),
+		})
+	case "azure":
+		// The 1.25 azure provider used the classic (service
+		// management) API, and its resources can't be managed
+		// through the resource manager API the 2.x provider uses.
+		return spec, errors.NotSupportedf("re-tagging 1.25 azure environments")
+	default:
+		return spec, errors.NotSupportedf("re-tagging %q environments", cfg.Type())
+	}
+	spec.Credential = &cred
+	return spec, nil
+}
+
+// attrString returns the named string attribute, or "" if it isn't set.
+func attrString(attrs map[string]interface{}, name string) string {
+	value, _ := attrs[name].(string)
+	return value
+}
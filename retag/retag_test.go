@@ -0,0 +1,47 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package retag_test
+
+import (
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	coretesting "github.com/juju/1.25-upgrade/juju1/testing"
+	"github.com/juju/1.25-upgrade/retag"
+)
+
+type retagSuite struct{}
+
+var _ = gc.Suite(&retagSuite{})
+
+func (*retagSuite) TestRetagAzureNotSupported(c *gc.C) {
+	cfg := coretesting.CustomEnvironConfig(c, coretesting.Attrs{
+		"type":          "azure",
+		"agent-version": "1.25.6",
+	})
+	err := retag.Retag(cfg, controllerUUID)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, "re-tagging 1.25 azure environments not supported")
+}
+
+func (*retagSuite) TestRetagOpenstackLegacyAuthNotSupported(c *gc.C) {
+	cfg := coretesting.CustomEnvironConfig(c, coretesting.Attrs{
+		"type":          "openstack",
+		"agent-version": "1.25.6",
+		"auth-mode":     "legacy",
+	})
+	err := retag.Retag(cfg, controllerUUID)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, `re-tagging with auth-mode "legacy" not supported`)
+}
+
+func (*retagSuite) TestRetagNot125(c *gc.C) {
+	cfg := coretesting.CustomEnvironConfig(c, coretesting.Attrs{
+		"type":          "ec2",
+		"agent-version": "1.24.7",
+	})
+	err := retag.Retag(cfg, controllerUUID)
+	c.Assert(err, gc.ErrorMatches, "environment version 1.24.7 is not 1.25")
+}
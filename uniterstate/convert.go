@@ -0,0 +1,301 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package uniterstate converts the on-disk state kept by 1.25 unit
+// agents (the operation state file, relation state directories and the
+// deployed charm directory) into the layout expected by the 2.x uniter.
+package uniterstate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/juju/errors"
+	"github.com/juju/loggo"
+	names1 "github.com/juju/names"
+	"github.com/juju/utils"
+	hooks1 "gopkg.in/juju/charm.v5/hooks"
+	charm2 "gopkg.in/juju/charm.v6-unstable"
+	hooks2 "gopkg.in/juju/charm.v6-unstable/hooks"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/yaml.v2"
+
+	uniter1 "github.com/juju/1.25-upgrade/juju1/worker/uniter"
+	charmdir1 "github.com/juju/1.25-upgrade/juju1/worker/uniter/charm"
+	hook1 "github.com/juju/1.25-upgrade/juju1/worker/uniter/hook"
+	operation1 "github.com/juju/1.25-upgrade/juju1/worker/uniter/operation"
+	relation1 "github.com/juju/1.25-upgrade/juju1/worker/uniter/relation"
+	hook2 "github.com/juju/1.25-upgrade/juju2/worker/uniter/hook"
+	operation2 "github.com/juju/1.25-upgrade/juju2/worker/uniter/operation"
+	relation2 "github.com/juju/1.25-upgrade/juju2/worker/uniter/relation"
+)
+
+var logger = loggo.GetLogger("upgrader.uniterstate")
+
+// BackupSuffix is appended to the path of a 1.25 operation state file
+// to name the copy kept when it is converted.
+const BackupSuffix = ".1.25"
+
+// Idle returns whether the uniter with the given state is waiting for
+// something to do: in Continue, with no step outstanding. (The uniter
+// records the idle state with the Pending step.)
+func Idle(st *operation1.State) bool {
+	return st.Kind == operation1.Continue && st.Step == operation1.Pending
+}
+
+// Describe returns a short description of the operation recorded in
+// st, for reporting which units are busy.
+func Describe(st *operation1.State) string {
+	var detail string
+	switch st.Kind {
+	case operation1.RunHook:
+		if st.Hook != nil {
+			detail = " " + string(st.Hook.Kind)
+		}
+	case operation1.RunAction:
+		if st.ActionId != nil {
+			detail = " " + *st.ActionId
+		}
+	case operation1.Install, operation1.Upgrade:
+		if st.CharmURL != nil {
+			detail = " " + st.CharmURL.String()
+		}
+	}
+	return fmt.Sprintf("%s%s (%s)", st.Kind, detail, st.Step)
+}
+
+// ConvertState returns the 2.x operation state equivalent to the 1.25
+// state. Unless force is true, only idle units can be converted, and an
+// error satisfying IsMidOperation is returned for any other. With force,
+// the operation in progress (a hook, action, install or charm upgrade,
+// at whatever step it had reached) is carried over as it is, so the 2.x
+// uniter resumes it, including returning to a failed hook.
+//
+// The 2.x uniter records whether the install hook has run separately
+// from the start hook. Unless the unit is still installing, it is
+// marked as installed. The metrics and update-status timers are
+// dropped; 2.x tracks those in memory.
+func ConvertState(st *operation1.State, force bool) (*operation2.State, error) {
+	if !force && !Idle(st) {
+		return nil, &midOperationError{operation: Describe(st)}
+	}
+	converted := &operation2.State{
+		Leader:    st.Leader,
+		Started:   st.Started,
+		Stopped:   st.Stopped,
+		Installed: !installing(st),
+		StatusSet: st.StatusSet,
+		Kind:      operation2.Kind(st.Kind),
+		Step:      operation2.Step(st.Step),
+		Hook:      convertHook(st.Hook),
+	}
+	if st.ActionId != nil {
+		id := *st.ActionId
+		converted.ActionId = &id
+	}
+	if st.CharmURL != nil {
+		url, err := charm2.ParseURL(st.CharmURL.String())
+		if err != nil {
+			return nil, errors.Annotate(err, "converting charm URL")
+		}
+		converted.CharmURL = url
+	}
+	return converted, nil
+}
+
+// installing returns whether the unit hasn't yet finished its install
+// hook: it is deploying its first charm, running (or about to run) the
+// install hook, or running the storage hooks that precede it.
+func installing(st *operation1.State) bool {
+	if st.Started {
+		return false
+	}
+	switch st.Kind {
+	case operation1.Install:
+		return true
+	case operation1.RunHook:
+		if st.Hook == nil {
+			return false
+		}
+		switch st.Hook.Kind {
+		case hooks1.Install, hooks1.StorageAttached:
+			return true
+		}
+	}
+	return false
+}
+
+// UpgradePending returns whether st records a charm upgrade that
+// hasn't finished deploying the new charm. The charm directory of such
+// a unit is left exactly as it is, so that the 2.x uniter can resume the
+// upgrade.
+func UpgradePending(st *operation1.State) bool {
+	return st.Kind == operation1.Upgrade && st.Step != operation1.Done
+}
+
+func convertHook(info *hook1.Info) *hook2.Info {
+	if info == nil {
+		return nil
+	}
+	return &hook2.Info{
+		Kind:          hooks2.Kind(info.Kind),
+		RelationId:    info.RelationId,
+		RemoteUnit:    info.RemoteUnit,
+		ChangeVersion: info.ChangeVersion,
+		StorageId:     info.StorageId,
+	}
+}
+
+type midOperationError struct {
+	operation string
+}
+
+func (e *midOperationError) Error() string {
+	return fmt.Sprintf("unit is part way through %s", e.operation)
+}
+
+// IsMidOperation returns whether err reports that a unit isn't idle.
+func IsMidOperation(err error) bool {
+	_, ok := errors.Cause(err).(*midOperationError)
+	return ok
+}
+
+// ReadState returns the 1.25 operation state of the unit with the given
+// tag in dataDir, reading the backup if the unit has already been
+// converted. If the unit has no state file, nil is returned.
+func ReadState(dataDir string, tag names.UnitTag) (*operation1.State, error) {
+	path, backup := stateFilePaths(dataDir, tag)
+	source := path
+	if _, err := os.Stat(backup); err == nil {
+		source = backup
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+	st, err := operation1.NewStateFile(source).Read()
+	if err == operation1.ErrNoStateFile {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Annotate(err, "reading 1.25 uniter state")
+	}
+	return st, nil
+}
+
+// ParseState returns the 1.25 operation state held in data, the
+// contents of a state file read from elsewhere, such as another
+// machine.
+func ParseState(data []byte) (*operation1.State, error) {
+	var st operation1.State
+	if err := yaml.Unmarshal(data, &st); err != nil {
+		return nil, errors.Annotate(err, "parsing 1.25 uniter state")
+	}
+	return &st, nil
+}
+
+// StateFile returns the path of the operation state file of the unit
+// with the given tag in dataDir, which ConvertUnit rewrites.
+func StateFile(dataDir string, tag names.UnitTag) string {
+	return uniter1.NewPaths(dataDir, names1.NewUnitTag(tag.Id())).State.OperationsFile
+}
+
+func stateFilePaths(dataDir string, tag names.UnitTag) (path, backup string) {
+	path = StateFile(dataDir, tag)
+	return path, path + BackupSuffix
+}
+
+// ConvertUnit converts the uniter state of the unit with the given tag
+// in dataDir, as ConvertState does, and returns the 1.25 state it
+// converted so that the caller can report any operation carried over.
+// The original operation state file is kept alongside, with
+// BackupSuffix appended, and converting again starts from the backup.
+// Units without a state file have never run a hook, so the 2.x uniter
+// will install them from scratch; nil is returned for those.
+//
+// The relation state directories are in the same format in both
+// versions; they are checked so that a unit with damaged relation
+// state is caught before the cutover rather than when the 2.x agent
+// starts. A charm directory still deployed with the long-gone git
+// deployer is converted to the manifest deployer, the only one 2.x
+// supports. (The 1.25 uniter does the same whenever it starts, so this
+// only matters for units that haven't been restarted since 1.20.) The
+// charm directory is left alone if a charm upgrade is pending; see
+// UpgradePending.
+func ConvertUnit(dataDir string, tag names.UnitTag, force bool) (*operation1.State, error) {
+	st, err := ReadState(dataDir, tag)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if st == nil {
+		logger.Debugf("no uniter state file found for %s, skipping", tag)
+		return nil, nil
+	}
+	converted, err := ConvertState(st, force)
+	if err != nil {
+		return nil, errors.Annotatef(err, "converting %s", tag)
+	}
+
+	paths := uniter1.NewPaths(dataDir, names1.NewUnitTag(tag.Id()))
+	if err := checkRelations(paths.State.RelationsDir); err != nil {
+		return nil, errors.Annotatef(err, "checking relation state for %s", tag)
+	}
+	if UpgradePending(st) {
+		logger.Infof("%s has a pending charm upgrade, leaving its charm directory", tag)
+	} else if err := fixDeployer(paths); err != nil {
+		return nil, errors.Annotatef(err, "converting charm directory for %s", tag)
+	}
+
+	path, backup := stateFilePaths(dataDir, tag)
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := utils.AtomicWriteFile(backup, data, 0600); err != nil {
+			return nil, errors.Annotate(err, "backing up 1.25 uniter state")
+		}
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := operation2.NewStateFile(path).Write(converted); err != nil {
+		return nil, errors.Annotate(err, "writing 2.x uniter state")
+	}
+	return st, nil
+}
+
+// Restore puts back the 1.25 operation state file of the unit with the
+// given tag, if it was converted. The charm directory is left as it is,
+// as the 1.25 uniter reads the manifest deployer layout too.
+func Restore(dataDir string, tag names.UnitTag) error {
+	path, backup := stateFilePaths(dataDir, tag)
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Trace(os.Rename(backup, path))
+}
+
+func checkRelations(dir string) error {
+	dirs1, err := relation1.ReadAllStateDirs(dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dirs2, err := relation2.ReadAllStateDirs(dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(dirs1) != len(dirs2) {
+		return errors.Errorf("read %d relations, expected %d", len(dirs2), len(dirs1))
+	}
+	return nil
+}
+
+func fixDeployer(paths uniter1.Paths) error {
+	deployer, err := charmdir1.NewDeployer(
+		paths.State.CharmDir,
+		paths.State.DeployerDir,
+		charmdir1.NewBundlesDir(paths.State.BundlesDir),
+	)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(charmdir1.FixDeployer(&deployer))
+}
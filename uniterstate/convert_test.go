@@ -0,0 +1,227 @@
+// Copyright 2017 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package uniterstate_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	charm1 "gopkg.in/juju/charm.v5"
+	hooks1 "gopkg.in/juju/charm.v5/hooks"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charm.v6-unstable/hooks"
+
+	hook1 "github.com/juju/1.25-upgrade/juju1/worker/uniter/hook"
+	operation1 "github.com/juju/1.25-upgrade/juju1/worker/uniter/operation"
+	"github.com/juju/1.25-upgrade/juju2/worker/uniter/hook"
+	"github.com/juju/1.25-upgrade/juju2/worker/uniter/operation"
+	"github.com/juju/1.25-upgrade/uniterstate"
+)
+
+type convertStateSuite struct{}
+
+var _ = gc.Suite(&convertStateSuite{})
+
+func (s *convertStateSuite) TestIdleUnit(c *gc.C) {
+	actionId := "666"
+	converted, err := uniterstate.ConvertState(&operation1.State{
+		Leader:             true,
+		Started:            true,
+		StatusSet:          true,
+		Kind:               operation1.Continue,
+		Step:               operation1.Pending,
+		Hook:               &hook1.Info{Kind: hooks1.RelationChanged, RelationId: 1, RemoteUnit: "mysql/0", ChangeVersion: 3},
+		ActionId:           &actionId,
+		CharmURL:           charm1.MustParseURL("cs:trusty/wordpress-42"),
+		CollectMetricsTime: 1234,
+		UpdateStatusTime:   5678,
+	}, false)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(converted, jc.DeepEquals, &operation.State{
+		Leader:    true,
+		Started:   true,
+		Installed: true,
+		StatusSet: true,
+		Kind:      operation.Continue,
+		Step:      operation.Pending,
+		Hook:      &hook.Info{Kind: hooks.RelationChanged, RelationId: 1, RemoteUnit: "mysql/0", ChangeVersion: 3},
+		ActionId:  &actionId,
+		CharmURL:  charm.MustParseURL("cs:trusty/wordpress-42"),
+	})
+}
+
+func (s *convertStateSuite) TestRunHookConvertsHookInfo(c *gc.C) {
+	for _, info := range []hook1.Info{
+		{Kind: hooks1.RelationChanged, RelationId: 3, RemoteUnit: "mysql/1", ChangeVersion: 7},
+		{Kind: hooks1.StorageAttached, StorageId: "data/0"},
+	} {
+		c.Logf("%s", info.Kind)
+		info := info
+		converted, err := uniterstate.ConvertState(&operation1.State{
+			Started: true,
+			Kind:    operation1.RunHook,
+			Step:    operation1.Done,
+			Hook:    &info,
+		}, true)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(converted.Kind, gc.Equals, operation.RunHook)
+		c.Check(converted.Step, gc.Equals, operation.Done)
+		c.Check(converted.Hook, jc.DeepEquals, &hook.Info{
+			Kind:          hooks.Kind(info.Kind),
+			RelationId:    info.RelationId,
+			RemoteUnit:    info.RemoteUnit,
+			ChangeVersion: info.ChangeVersion,
+			StorageId:     info.StorageId,
+		})
+	}
+}
+
+func (s *convertStateSuite) TestMidOperation(c *gc.C) {
+	for _, st := range []operation1.State{{
+		Kind: operation1.RunHook,
+		Step: operation1.Pending,
+		Hook: &hook1.Info{Kind: hooks1.ConfigChanged},
+	}, {
+		Kind: operation1.RunHook,
+		Step: operation1.Queued,
+		Hook: &hook1.Info{Kind: hooks1.Start},
+	}, {
+		Kind:     operation1.Upgrade,
+		Step:     operation1.Pending,
+		CharmURL: charm1.MustParseURL("cs:trusty/wordpress-43"),
+	}, {
+		Kind: operation1.Continue,
+		Step: operation1.Queued,
+	}} {
+		c.Logf("%s %s", st.Kind, st.Step)
+		c.Check(uniterstate.Idle(&st), jc.IsFalse)
+		_, err := uniterstate.ConvertState(&st, false)
+		c.Check(uniterstate.IsMidOperation(err), jc.IsTrue)
+	}
+}
+
+func (s *convertStateSuite) TestForceCarriesOverOperation(c *gc.C) {
+	actionId := "42"
+	for i, test := range []struct {
+		about     string
+		state     operation1.State
+		expected  operation.State
+		described string
+	}{{
+		about: "failed hook",
+		state: operation1.State{
+			Started: true,
+			Kind:    operation1.RunHook,
+			Step:    operation1.Pending,
+			Hook:    &hook1.Info{Kind: hooks1.ConfigChanged},
+		},
+		expected: operation.State{
+			Started:   true,
+			Installed: true,
+			Kind:      operation.RunHook,
+			Step:      operation.Pending,
+			Hook:      &hook.Info{Kind: hooks.ConfigChanged},
+		},
+		described: "run-hook config-changed (pending)",
+	}, {
+		about: "queued install hook",
+		state: operation1.State{
+			Kind: operation1.RunHook,
+			Step: operation1.Queued,
+			Hook: &hook1.Info{Kind: hooks1.Install},
+		},
+		expected: operation.State{
+			Kind: operation.RunHook,
+			Step: operation.Queued,
+			Hook: &hook.Info{Kind: hooks.Install},
+		},
+		described: "run-hook install (queued)",
+	}, {
+		about: "running action",
+		state: operation1.State{
+			Started:  true,
+			Kind:     operation1.RunAction,
+			Step:     operation1.Pending,
+			ActionId: &actionId,
+		},
+		expected: operation.State{
+			Started:   true,
+			Installed: true,
+			Kind:      operation.RunAction,
+			Step:      operation.Pending,
+			ActionId:  &actionId,
+		},
+		described: "run-action 42 (pending)",
+	}, {
+		about: "upgrade returning to a failed hook",
+		state: operation1.State{
+			Started:  true,
+			Kind:     operation1.Upgrade,
+			Step:     operation1.Done,
+			Hook:     &hook1.Info{Kind: hooks1.RelationJoined, RelationId: 2, RemoteUnit: "mysql/1"},
+			CharmURL: charm1.MustParseURL("cs:trusty/wordpress-43"),
+		},
+		expected: operation.State{
+			Started:   true,
+			Installed: true,
+			Kind:      operation.Upgrade,
+			Step:      operation.Done,
+			Hook:      &hook.Info{Kind: hooks.RelationJoined, RelationId: 2, RemoteUnit: "mysql/1"},
+			CharmURL:  charm.MustParseURL("cs:trusty/wordpress-43"),
+		},
+		described: "upgrade cs:trusty/wordpress-43 (done)",
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		c.Check(uniterstate.Describe(&test.state), gc.Equals, test.described)
+		converted, err := uniterstate.ConvertState(&test.state, true)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(*converted, jc.DeepEquals, test.expected)
+	}
+}
+
+func (s *convertStateSuite) TestRunHookWithoutHookInfo(c *gc.C) {
+	converted, err := uniterstate.ConvertState(&operation1.State{
+		Kind: operation1.RunHook,
+		Step: operation1.Pending,
+	}, true)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(converted.Installed, jc.IsTrue)
+	c.Check(converted.Hook, gc.IsNil)
+}
+
+func (s *convertStateSuite) TestUpgradePending(c *gc.C) {
+	url := charm1.MustParseURL("cs:trusty/wordpress-43")
+	for _, test := range []struct {
+		state   operation1.State
+		pending bool
+	}{
+		{operation1.State{Kind: operation1.Upgrade, Step: operation1.Queued, CharmURL: url}, true},
+		{operation1.State{Kind: operation1.Upgrade, Step: operation1.Pending, CharmURL: url}, true},
+		{operation1.State{Kind: operation1.Upgrade, Step: operation1.Done, CharmURL: url}, false},
+		{operation1.State{Kind: operation1.Install, Step: operation1.Pending, CharmURL: url}, false},
+		{operation1.State{Kind: operation1.Continue, Step: operation1.Pending}, false},
+	} {
+		c.Logf("%s %s", test.state.Kind, test.state.Step)
+		c.Check(uniterstate.UpgradePending(&test.state), gc.Equals, test.pending)
+	}
+}
+
+func (s *convertStateSuite) TestParseState(c *gc.C) {
+	st, err := uniterstate.ParseState([]byte(`
+started: true
+op: run-hook
+opstep: pending
+hook:
+  kind: config-changed
+`))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(uniterstate.Idle(st), jc.IsFalse)
+	c.Check(uniterstate.Describe(st), gc.Equals, "run-hook config-changed (pending)")
+
+	st, err = uniterstate.ParseState([]byte("op: continue\nopstep: pending\n"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(uniterstate.Idle(st), jc.IsTrue)
+
+	_, err = uniterstate.ParseState([]byte("op: [\n"))
+	c.Check(err, gc.ErrorMatches, "parsing 1.25 uniter state: .*")
+}